@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/inshur/dagger/pkg/agent"
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/inshur/dagger/pkg/reconcile"
+	"github.com/urfave/cli"
+)
+
+func agentFlags() []cli.Flag {
+	return append(settingFlags(),
+		cli.StringFlag{Name: "repo", Usage: "Git repository to watch, a local clone or a bare repository"},
+		cli.StringFlag{Name: "branch", Value: "main", Usage: "Branch to deploy"},
+		cli.StringFlag{Name: "workspace", Value: "./dagger-workspace", Usage: "Folder the branch is checked out into"},
+		cli.DurationFlag{Name: "poll-interval", Value: time.Minute, Usage: "How often to check the branch for new commits"},
+		metricsAddrFlag,
+	)
+}
+
+// newAgent builds the agent watching --repo. Syncs run from the workspace
+// so relative paths in the settings resolve against the checked out tree.
+func newAgent(c *cli.Context) (*agent.Agent, error) {
+	if c.String("repo") == "" {
+		return nil, fmt.Errorf("--repo is required")
+	}
+	workspace, err := filepath.Abs(c.String("workspace"))
+	if err != nil {
+		return nil, err
+	}
+	if configFile := c.String("config"); configFile != "" {
+		abs, err := filepath.Abs(configFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Set("config", abs); err != nil {
+			return nil, err
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	return &agent.Agent{
+		Repo:      c.String("repo"),
+		Branch:    c.String("branch"),
+		Workspace: workspace,
+		Sync: func(ctx context.Context, dir, sha string) (string, error) {
+			if err := os.Chdir(dir); err != nil {
+				return "", err
+			}
+			defer os.Chdir(wd)
+			record, err := syncOnce(ctx, c, false)
+			if err != nil {
+				return "", err
+			}
+			return summarize(record), nil
+		},
+	}, nil
+}
+
+// runAgent syncs every commit the watched branch moves to until interrupted.
+func runAgent(c *cli.Context) error {
+	settings, err := resolveSettings(c)
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	backoff, err := settings.Duration("retry-backoff")
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	a, err := newAgent(c)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	loop := reconcile.Loop{
+		Interval: c.Duration("poll-interval"),
+		Backoff:  backoff,
+		Logf:     logging.Log.Infof,
+		Errorf:   logging.Log.Errorf,
+		Sync:     countCycles(a.Cycle),
+	}
+	serveMetrics(c.String("metrics-addr"))
+	loop.Run(ctx)
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/inshur/dagger/pkg/ci"
+	"github.com/inshur/dagger/pkg/config"
+	"github.com/inshur/dagger/pkg/deploy"
+	"github.com/inshur/dagger/pkg/logging"
+)
+
+// annotate prints anns as GitHub annotations when running in GitHub
+// Actions.
+func annotate(w io.Writer, anns []ci.Annotation) {
+	if !ci.GitHubActions() || len(anns) == 0 {
+		return
+	}
+	if err := ci.WriteAnnotations(w, anns); err != nil {
+		logging.Log.Errorf("error writing annotations: %v", err)
+	}
+}
+
+// reportCI writes the result of a sync for the CI running it: the job
+// summary and step outputs on GitHub Actions and the JUnit report when one
+// is configured. Failing to report is logged without failing the sync.
+func reportCI(s *config.Settings, record deploy.Deployment, plan *deploy.Plan) {
+	if ci.GitHubActions() {
+		if err := ci.WriteStepSummary(record, plan); err != nil {
+			logging.Log.Errorf("error writing the job summary: %v", err)
+		}
+		if err := ci.SetOutputs(os.Stdout, ci.Outputs(record)); err != nil {
+			logging.Log.Errorf("error setting step outputs: %v", err)
+		}
+	}
+	if path := s.String("junit-report"); path != "" {
+		if err := ci.WriteJUnitFile(path, record); err != nil {
+			logging.Log.Errorf("error writing the JUnit report: %v", err)
+		}
+	}
+}
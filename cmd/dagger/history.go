@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/inshur/dagger/pkg/deploy"
+	"github.com/urfave/cli"
+)
+
+// parseSince reads a date, an RFC 3339 time or a duration back from now.
+func parseSince(since string) (time.Time, error) {
+	if d, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, since); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("--since must be a date, a time or a duration, got %q", since)
+}
+
+// history prints the deployments recorded for the environment, latest first.
+func history(c *cli.Context) error {
+	settings, err := resolveSettings(c)
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	composer, err := composerFromSettings(settings)
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	if composer.History == nil {
+		if err := composer.Configure(); err != nil {
+			return fmt.Errorf("configure error: %s", err)
+		}
+	}
+	filter := deploy.DeploymentFilter{Dag: c.String("dag"), User: c.String("user"), Status: c.String("status")}
+	if since := c.String("since"); since != "" {
+		if filter.Since, err = parseSince(since); err != nil {
+			return err
+		}
+	}
+	deployments, err := composer.ReadDeployments()
+	if err != nil {
+		return err
+	}
+	var selected []deploy.Deployment
+	for i := len(deployments) - 1; i >= 0; i-- {
+		if filter.Match(deployments[i]) {
+			selected = append(selected, deployments[i])
+		}
+		if limit := c.Int("limit"); limit > 0 && len(selected) == limit {
+			break
+		}
+	}
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		for _, d := range selected {
+			if err := enc.Encode(d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := "ID\tSTARTED\tUSER\tCOMMIT\tVERSION\tSTATUS\tCHANGES\tDURATION"
+	if filter.Dag != "" {
+		header += "\t" + strings.ToUpper(filter.Dag)
+	}
+	fmt.Fprintln(w, header)
+	for _, d := range selected {
+		commit := d.Commit
+		if len(commit) > 8 {
+			commit = commit[:8]
+		}
+		changes := "-"
+		if d.Plan != nil {
+			changes = fmt.Sprintf("+%d ~%d -%d", d.Plan.Start, d.Plan.Restart, d.Plan.Stop)
+		}
+		duration := time.Duration(d.Duration * float64(time.Second)).Round(time.Second)
+		row := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s", d.ID, d.Started.Format(time.RFC3339),
+			d.User, commit, d.Version, d.Status, changes, duration)
+		if filter.Dag != "" {
+			row += "\t" + d.Dags[filter.Dag]
+		}
+		fmt.Fprintln(w, row)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/inshur/dagger/pkg/deploy"
+	"github.com/inshur/dagger/pkg/store"
+)
+
+// captureStdout returns what run writes to stdout.
+func captureStdout(t *testing.T, run func() error) ([]byte, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		out <- data
+	}()
+	runErr := run()
+	w.Close()
+	return <-out, runErr
+}
+
+func TestHistoryJSONIsParseable(t *testing.T) {
+	dir := t.TempDir()
+	c := deploy.ComposerEnv{Name: "composer-prod", History: store.Dir{Root: dir}}
+	for i, id := range []string{"20210601T120000Z", "20210601T130000Z"} {
+		c.DeployID = id
+		started := time.Date(2021, 6, 1, 12+i, 0, 0, 0, time.UTC)
+		if err := c.RecordDeployment(c.NewDeployment(started, deploy.Provenance{User: "alice"}, nil, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := captureStdout(t, func() error {
+		return newApp().Run([]string{"dagger", "history", "--json",
+			"--project", "analytics-prod", "--location", "europe-west1", "--name", "composer-prod",
+			"--history-dir", dir})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var ids []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		var d deploy.Deployment
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			t.Fatalf("stdout is not JSON lines: %v\n%s", err, out)
+		}
+		ids = append(ids, d.ID)
+	}
+	if len(ids) != 2 || ids[0] != "20210601T130000Z" || ids[1] != "20210601T120000Z" {
+		t.Errorf("expected the deployments latest first, got %v", ids)
+	}
+}
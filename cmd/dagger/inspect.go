@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/inshur/dagger/pkg/deploy"
+	"github.com/urfave/cli"
+)
+
+// inspect prints the provenance of the deployed file of dag.
+func inspect(c *cli.Context, dag string) error {
+	settings, err := resolveSettings(c)
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	composer, err := composerFromSettings(settings)
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	if err := composer.Configure(); err != nil {
+		return fmt.Errorf("configure error: %s", err)
+	}
+	result, err := composer.Inspect(dag)
+	if err != nil {
+		return err
+	}
+	meta := result.Object.Metadata
+	unknown := func(v string) string {
+		if v == "" {
+			return "unknown"
+		}
+		return v
+	}
+	local := result.Local
+	if result.LocalPath != "" {
+		local = fmt.Sprintf("%s %s", result.LocalPath, result.Local)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "DAG:\t%s\n", result.Dag)
+	fmt.Fprintf(w, "Object:\t%s (generation %d, md5 %s)\n", result.Object.Name, result.Object.Generation, result.Object.MD5)
+	fmt.Fprintf(w, "Commit:\t%s\n", unknown(meta[deploy.MetadataCommit]))
+	fmt.Fprintf(w, "Repo:\t%s\n", unknown(meta[deploy.MetadataRepo]))
+	fmt.Fprintf(w, "Dagger version:\t%s\n", unknown(meta[deploy.MetadataVersion]))
+	fmt.Fprintf(w, "Deploy ID:\t%s\n", unknown(meta[deploy.MetadataDeployID]))
+	fmt.Fprintf(w, "Uploaded from:\t%s\n", unknown(meta[deploy.MetadataLocalPath]))
+	fmt.Fprintf(w, "Local file:\t%s\n", local)
+	return w.Flush()
+}
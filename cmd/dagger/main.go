@@ -1,17 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/inshur/dagger/pkg/ci"
+	"github.com/inshur/dagger/pkg/config"
 	"github.com/inshur/dagger/pkg/deploy"
+	"github.com/inshur/dagger/pkg/lint"
+	"github.com/inshur/dagger/pkg/logging"
 	"github.com/urfave/cli"
-	"log"
+	"io"
 	"os"
-	"time"
 )
 
-func main() {
-
-	BANNER := `
+const banner = `
 ██████╗░░█████╗░░██████╗░░██████╗░███████╗██████╗░
 ██╔══██╗██╔══██╗██╔════╝░██╔════╝░██╔════╝██╔══██╗
 ██║░░██║███████║██║░░██╗░██║░░██╗░█████╗░░██████╔╝
@@ -19,129 +21,352 @@ func main() {
 ██████╔╝██║░░██║╚██████╔╝╚██████╔╝███████╗██║░░██║
 ╚═════╝░╚═╝░░╚═╝░╚═════╝░░╚═════╝░╚══════╝╚═╝░░╚═╝
 `
-	fmt.Println(BANNER)
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+func main() {
+	app := newApp()
+	err := app.Run(os.Args)
+	flushTraces()
+	if err != nil {
+		logging.Log.Fatal(err)
+	}
+}
+
+// newApp returns the dagger command line application.
+func newApp() *cli.App {
 	app := cli.NewApp()
 	app.Name = "dagger"
 	app.Usage = "DAG management tool"
+	app.Version = version
+	app.Before = func(*cli.Context) error {
+		// stdout is kept for command output, like lint and history --json
+		fmt.Fprintf(os.Stderr, "%s\n", banner)
+		return nil
+	}
 
-	flags := []cli.Flag{
-		cli.StringFlag{
-			Name:     "list",
-			Value:    "./config/running_dags.txt",
-			Required: true,
-			Usage:    "File with DAGs to run",
+	flags := append(settingFlags(),
+		cli.BoolFlag{
+			Name:  "loop",
+			Usage: "Keep syncing every --interval, retrying failed syncs with backoff",
 		},
-		cli.StringFlag{
-			Name:     "dags",
-			Value:    "./dags",
-			Usage:    "DAGs folder",
-			Required: true,
+		metricsAddrFlag,
+	)
+	// we create our commands
+	app.Commands = []cli.Command{
+		{
+			Name:  "sync",
+			Usage: "Sync DAGs to GCP Composer",
+			Flags: flags,
+			Action: func(c *cli.Context) error {
+				if err := setupTracing(c); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				if !c.Bool("loop") {
+					if _, err := syncOnce(context.Background(), c, false); err != nil {
+						logging.Log.Fatal(err)
+					}
+					return nil
+				}
+				if err := syncLoop(c); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				return nil
+			},
 		},
-		cli.StringFlag{
-			Name:     "plugins",
-			Value:    "./plugins",
-			Usage:    "Airflow plugins",
-			Required: false,
+		{
+			Name:  "agent",
+			Usage: "Watch a branch of a git repository and sync every commit it moves to",
+			Flags: agentFlags(),
+			Action: func(c *cli.Context) error {
+				if err := setupTracing(c); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				if err := runAgent(c); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				return nil
+			},
 		},
-		cli.StringFlag{
-			Name:     "data",
-			Value:    "./data",
-			Usage:    "Airflow data (ie: sql files)",
-			Required: false,
+		{
+			Name:  "serve",
+			Usage: "Sync the commits pushed to a branch, as reported by GitHub or GitLab webhooks",
+			Flags: serveFlags(),
+			Action: func(c *cli.Context) error {
+				if err := setupTracing(c); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				if err := serve(c); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "watch",
+			Usage: "Push local changes to a dev environment as they happen, restarting only the affected DAGs",
+			Flags: watchFlags(),
+			Action: func(c *cli.Context) error {
+				if err := runWatch(c); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				return nil
+			},
 		},
-		cli.StringFlag{
-			Name:     "project",
-			Value:    "",
-			Required: true,
-			Usage:    "GCP project name",
+		{
+			Name:  "plan",
+			Usage: "Show what a sync would change without applying it",
+			Flags: settingFlags(),
+			Action: func(c *cli.Context) error {
+				settings, err := resolveSettings(c)
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("config error: %s", err), 1)
+				}
+				composer, err := composerFromSettings(settings)
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("config error: %s", err), 1)
+				}
+				if err := composer.Configure(); err != nil {
+					return cli.NewExitError(fmt.Sprintf("configure error: %s", err), 1)
+				}
+				plan, err := composer.Plan(settings.String("list"))
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("plan error: %s", err), 1)
+				}
+				fmt.Println()
+				plan.Print(os.Stdout)
+				return nil
+			},
+		},
+		{
+			Name:      "rollback",
+			Usage:     "Restore the environment bucket and DAG pause states recorded before a deploy",
+			ArgsUsage: "<deploy-id>",
+			Flags:     settingFlags(),
+			Action: func(c *cli.Context) error {
+				settings, err := resolveSettings(c)
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("config error: %s", err), 1)
+				}
+				composer, err := composerFromSettings(settings)
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("config error: %s", err), 1)
+				}
+				if err := composer.Configure(); err != nil {
+					return cli.NewExitError(fmt.Sprintf("configure error: %s", err), 1)
+				}
+				if c.NArg() != 1 {
+					ids, err := composer.ListSnapshots()
+					if err != nil {
+						return cli.NewExitError(err.Error(), 1)
+					}
+					return cli.NewExitError(fmt.Sprintf("usage: dagger rollback <deploy-id>, recorded deploys: %v", ids), 1)
+				}
+				composer.Provenance = deploy.DetectProvenance(composer.LocalDagsDir, version)
+				_, release, err := holdLock(composer, func(err error) {
+					logging.Log.Errorf("lost the environment lock: %v", err)
+				})
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				defer release()
+				if err := composer.Rollback(c.Args().First()); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				fmt.Printf("rolled back to the state before %s\n", c.Args().First())
+				return nil
+			},
 		},
-		cli.StringFlag{
-			Name:     "location",
-			Value:    "",
-			Required: true,
-			Usage:    "GCP Composer location",
+		{
+			Name:  "unlock",
+			Usage: "Remove the environment lock left behind by a sync that stopped renewing it",
+			Flags: append(settingFlags(),
+				cli.BoolFlag{Name: "force", Usage: "Remove the lock even though its holder is still renewing it"},
+			),
+			Action: func(c *cli.Context) error {
+				if err := unlock(c); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				return nil
+			},
 		},
-		cli.StringFlag{
-			Name:     "name",
-			Value:    "",
-			Required: true,
-			Usage:    "Name of GCP Composer environment",
+		{
+			Name:  "history",
+			Usage: "List the recorded deployments of the environment",
+			Flags: append(settingFlags(),
+				cli.StringFlag{Name: "dag", Usage: "Only deployments that touched this DAG"},
+				cli.StringFlag{Name: "user", Usage: "Only deployments by this user"},
+				cli.StringFlag{Name: "status", Usage: "Only succeeded or failed deployments"},
+				cli.StringFlag{Name: "since", Usage: "Only deployments since a date (2021-06-01) or for a duration (24h)"},
+				cli.IntFlag{Name: "limit", Value: 20, Usage: "Show at most this many of the latest deployments, 0 for all"},
+				cli.BoolFlag{Name: "json", Usage: "Print the records as JSON lines"},
+			),
+			Action: func(c *cli.Context) error {
+				if err := history(c); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				return nil
+			},
 		},
-		cli.StringFlag{
-			Name:     "variables",
-			Value:    "",
-			Required: false,
-			Usage:    "Airflow variables config file",
+		{
+			Name:      "inspect",
+			Usage:     "Show where the deployed file of a DAG came from and whether it matches the local checkout",
+			ArgsUsage: "<dag_id>",
+			Flags:     settingFlags(),
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return cli.NewExitError("usage: dagger inspect <dag_id>", 1)
+				}
+				if err := inspect(c, c.Args().First()); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				return nil
+			},
 		},
-		cli.StringFlag{
-			Name:     "connections",
-			Value:    "",
-			Required: false,
-			Usage:    "Airflow connections config file",
+		{
+			Name:  "promote",
+			Usage: "Copy the DAG files deployed in one environment to the next",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "config",
+					EnvVar: "DAGGER_CONFIG",
+					Usage:  "Dagger config file declaring the environments",
+				},
+				cli.StringFlag{
+					Name:     "from",
+					Required: true,
+					Usage:    "Environment to promote from",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "Environment to promote to (default: promote_to of --from)",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				results, err := promote(c)
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("promote error: %s", err), 1)
+				}
+				for _, r := range results {
+					status := "unchanged"
+					if r.Copied {
+						status = "promoted"
+					}
+					fmt.Printf("%s\t%s\t%s\n", r.Dag, r.RelPath, status)
+				}
+				return nil
+			},
 		},
-		cli.BoolFlag{
-			Name:  "loop",
-			Usage: "Run Dagger in a loop (useful for continues sync)",
+		{
+			Name:  "config",
+			Usage: "Inspect dagger configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:  "show",
+					Usage: "Print resolved settings and where each value came from",
+					Flags: settingFlags(),
+					Action: func(c *cli.Context) error {
+						settings, err := resolveSettings(c)
+						if err != nil {
+							return cli.NewExitError(fmt.Sprintf("config error: %s", err), 1)
+						}
+						printSettings(settings)
+						return nil
+					},
+				},
+			},
 		},
-	}
-	// we create our commands
-	app.Commands = []cli.Command{
 		{
-			Name:  "sync",
-			Usage: "Sync DAGs to GCP Composer",
-			Flags: flags,
+			Name:  "validate",
+			Usage: "Validate Airflow variables, connections and running DAGs files and load the DAGs with Airflow",
+			Flags: settingFlags(),
 			Action: func(c *cli.Context) error {
-				fmt.Printf("Composer environment: %s\n", c.String("name"))
-				fmt.Printf("Project: %s, Location: %s\n", c.String("project"), c.String("location"))
-				fmt.Println()
+				settings, err := resolveSettings(c)
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("config error: %s", err), 1)
+				}
 				composer := deploy.ComposerEnv{
-					Name:            c.String("name"),
-					Project:         c.String("project"),
-					Location:        c.String("location"),
-					LocalDagsDir:    c.String("dags"),
-					LocalPluginsDir: c.String("plugins"),
-					LocalDataDir:    c.String("data"),
-					VariablesFile:   c.String("variables"),
-					ConnectionsFile: c.String("connections"),
-				}
-				err := composer.Configure()
+					VariablesFile:     settings.String("variables"),
+					VariablesOverlays: settings.List("variable-overlays"),
+					ConnectionsFile:   settings.String("connections"),
+				}
+				// the default list path is only validated when it exists
+				if _, err := os.Stat(settings.String("list")); err == nil || settings.Source("list") != config.SourceDefault {
+					composer.RunningDagsFile = settings.String("list")
+				}
+				if err := composer.Validate(); err != nil {
+					annotate(os.Stdout, ci.ValidationAnnotations(err))
+					return cli.NewExitError(fmt.Sprintf("validation failed:\n%s", err), 1)
+				}
+				if python, image := settings.String("python"), settings.String("airflow-image"); python != "" || image != "" {
+					composer.LocalDagsDir = settings.String("dags")
+					dags, err := deploy.ReadRunningDags(settings.String("list"), settings.String("env"), composer.LocalDagsDir)
+					if err != nil {
+						return cli.NewExitError(fmt.Sprintf("validation failed:\n%s", err), 1)
+					}
+					if err := composer.ValidateDagBag(dags.IDs(), python, image); err != nil {
+						annotate(os.Stdout, ci.ValidationAnnotations(err))
+						return cli.NewExitError(fmt.Sprintf("DagBag validation failed:\n%s", err), 1)
+					}
+				}
+				fmt.Println("validation passed")
+				return nil
+			},
+		},
+		{
+			Name:  "lint",
+			Usage: "Check the local DAG files for common mistakes without Airflow installed",
+			Flags: append(settingFlags(),
+				cli.StringFlag{
+					Name:  "format",
+					Value: lint.FormatText,
+					Usage: "Output format: text, json or sarif",
+				},
+				cli.StringFlag{
+					Name:  "output",
+					Usage: "Write the findings to this file instead of stdout",
+				},
+			),
+			Action: func(c *cli.Context) error {
+				settings, err := resolveSettings(c)
 				if err != nil {
-					log.Fatalf("configure error: %s", err)
+					return cli.NewExitError(fmt.Sprintf("config error: %s", err), 1)
 				}
-				err = composer.SyncPlugins()
+				levels, err := lint.ParseLevels(settings.List("lint-rules"))
 				if err != nil {
-					log.Fatalf("sync plugins error: %s", err)
+					return cli.NewExitError(fmt.Sprintf("config error: %s", err), 1)
 				}
-				err = composer.SyncData()
+				findings, err := lint.Lint(settings.String("dags"), lint.Config{
+					Levels:       levels,
+					HeavyImports: settings.List("lint-heavy-imports"),
+				})
 				if err != nil {
-					log.Fatalf("sync data error: %s", err)
-				}
-				//err = composer.ImportVariables()
-				//if err != nil {
-				//	log.Fatalf("import variables error: %s", err)
-				//}
-				//err = composer.ImportConnections()
-				//if err != nil {
-				//	log.Fatalf("import connections error: %s", err)
-				//}
-				dagsToStop, dagsToStart := composer.GetStopAndStartDags(c.String("list"))
-				composer.StopDags(dagsToStop)
-				composer.StartDags(c.String("dags"), dagsToStart)
-				composer.StartMonitoringDag()
-				for {
-					if !c.Bool("loop") {
-						break
+					return cli.NewExitError(err.Error(), 1)
+				}
+				out := os.Stdout
+				if path := c.String("output"); path != "" {
+					out, err = os.Create(path)
+					if err != nil {
+						return cli.NewExitError(err.Error(), 1)
 					}
-					time.Sleep(1 * time.Hour)
+					defer out.Close()
+				}
+				if err := lint.Write(out, c.String("format"), findings); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				// keep json and sarif written to stdout parseable
+				annotations := io.Writer(os.Stdout)
+				if out == os.Stdout && c.String("format") != lint.FormatText {
+					annotations = os.Stderr
+				}
+				annotate(annotations, ci.LintAnnotations(settings.String("dags"), findings))
+				if lint.HasErrors(findings) {
+					return cli.NewExitError("lint failed", 1)
 				}
 				return nil
 			},
 		},
 	}
-	// start our application
-	err := app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return app
 }
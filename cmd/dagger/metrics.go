@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/inshur/dagger/pkg/metrics"
+	"github.com/urfave/cli"
+)
+
+var metricsAddrFlag = cli.StringFlag{
+	Name:  "metrics-addr",
+	Usage: "Address to serve Prometheus metrics on at /metrics, e.g. :9090",
+}
+
+// serveMetrics serves /metrics on addr in the background. It does nothing
+// when addr is empty.
+func serveMetrics(addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go func() {
+		logging.Log.Infof("serving metrics on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logging.Log.Errorf("metrics server error: %v", err)
+		}
+	}()
+}
+
+// countCycles counts every cycle of sync and its result.
+func countCycles(sync func(context.Context) (string, error)) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		summary, err := sync(ctx)
+		metrics.Cycle(err)
+		return summary, err
+	}
+}
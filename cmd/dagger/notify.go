@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/inshur/dagger/pkg/config"
+	"github.com/inshur/dagger/pkg/deploy"
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/inshur/dagger/pkg/notify"
+)
+
+// notifySinks builds the notification sinks of the config file, reading
+// SMTP passwords from the environment variables they name.
+func notifySinks(s *config.Settings) ([]notify.Sink, error) {
+	var sinks []notify.Sink
+	for i, n := range s.Notify() {
+		sink := notify.Sink{
+			Type:         n.Type,
+			URL:          n.URL,
+			On:           n.On,
+			Environments: n.Environments,
+			Template:     n.Template,
+			Subject:      n.Subject,
+			SMTP:         n.SMTP,
+			From:         n.From,
+			To:           n.To,
+			Username:     n.Username,
+		}
+		if n.PasswordEnv != "" {
+			password, ok := os.LookupEnv(n.PasswordEnv)
+			if !ok {
+				return nil, fmt.Errorf("notify sink %d: %s is not set", i+1, n.PasswordEnv)
+			}
+			sink.Password = password
+		}
+		if err := sink.Validate(); err != nil {
+			return nil, fmt.Errorf("notify sink %d: %v", i+1, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// notifyDeployment sends the summary of a deployment to the sinks that want
+// it. Failing to notify is logged without failing the sync.
+func notifyDeployment(sinks []notify.Sink, env string, record deploy.Deployment) {
+	if len(sinks) == 0 {
+		return
+	}
+	for _, err := range notify.Notify(sinks, notify.NewSummary(record, env)) {
+		logging.Log.Error(err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/inshur/dagger/pkg/metrics"
+	"github.com/inshur/dagger/pkg/webhook"
+	"github.com/urfave/cli"
+)
+
+func serveFlags() []cli.Flag {
+	return append(settingFlags(),
+		cli.StringFlag{Name: "listen", Value: ":8080", Usage: "Address to listen on"},
+		cli.StringFlag{Name: "repo", Usage: "Git repository to deploy from, a local clone or a bare repository"},
+		cli.StringFlag{Name: "branch", Value: "main", Usage: "Branch whose pushes are deployed"},
+		cli.StringFlag{Name: "workspace", Value: "./dagger-workspace", Usage: "Folder pushed commits are checked out into"},
+		cli.StringFlag{Name: "secret", EnvVar: "DAGGER_WEBHOOK_SECRET", Usage: "Webhook secret of the default environment, the HMAC key for GitHub and the token for GitLab"},
+		cli.StringSliceFlag{Name: "env-secret", EnvVar: "DAGGER_WEBHOOK_ENV_SECRETS", Usage: "Allow ?env=NAME with its own webhook secret, as NAME=SECRET, repeatable"},
+	)
+}
+
+// envSecrets parses the NAME=SECRET values of --env-secret.
+func envSecrets(values []string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("--env-secret must be NAME=SECRET, got %q", v)
+		}
+		secrets[parts[0]] = parts[1]
+	}
+	return secrets, nil
+}
+
+// serve syncs the head of --branch whenever webhooks on /webhook report a
+// push to it. The environment is picked with ?env=NAME, using the config
+// file's named environments, and only environments given a secret of their
+// own with --env-secret can be picked.
+func serve(c *cli.Context) error {
+	secrets, err := envSecrets(c.StringSlice("env-secret"))
+	if err != nil {
+		return err
+	}
+	if c.String("secret") == "" && len(secrets) == 0 {
+		return fmt.Errorf("--secret or --env-secret is required")
+	}
+	a, err := newAgent(c)
+	if err != nil {
+		return err
+	}
+	// syncs change the working directory and the env flag, so they run one
+	// at a time even across environments
+	var mu sync.Mutex
+	hooks := &webhook.Server{
+		Secret:  c.String("secret"),
+		Secrets: secrets,
+		Branch:  c.String("branch"),
+		Head:    a.Head,
+		Sync: func(ctx context.Context, env, sha string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if env != "" {
+				previous := c.String("env")
+				if err := c.Set("env", env); err != nil {
+					return err
+				}
+				defer c.Set("env", previous)
+			}
+			summary, err := a.Deploy(ctx, sha)
+			metrics.Cycle(err)
+			if err != nil {
+				return err
+			}
+			logging.Log.Infof("synced %.12s: %s", sha, summary)
+			return nil
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/webhook", hooks)
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	srv := &http.Server{Addr: c.String("listen"), Handler: mux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	logging.Log.Infof("listening on %s", srv.Addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	logging.Log.Infof("waiting for queued syncs to finish")
+	hooks.Wait()
+	return nil
+}
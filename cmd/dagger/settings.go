@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/inshur/dagger/pkg/config"
+	"github.com/inshur/dagger/pkg/deploy"
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/inshur/dagger/pkg/store"
+	"github.com/urfave/cli"
+)
+
+// settingFlags returns --config and a flag for every config key. Defaults
+// are applied by config.Resolve so flags only override when passed.
+func settingFlags() []cli.Flag {
+	flags := []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			EnvVar: "DAGGER_CONFIG",
+			Usage:  fmt.Sprintf("Dagger config file (default: first of %v)", config.DefaultFiles),
+		},
+	}
+	for _, k := range config.Keys {
+		usage := k.Usage
+		if k.Default != "" {
+			usage = fmt.Sprintf("%s (default: %q)", usage, k.Default)
+		}
+		flags = append(flags, cli.StringFlag{
+			Name:  k.Name,
+			Usage: fmt.Sprintf("%s [$%s]", usage, k.EnvVar()),
+		})
+	}
+	return flags
+}
+
+// loadConfigFile finds and loads the config file, returning a nil file when
+// there is none.
+func loadConfigFile(c *cli.Context) (*config.File, string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, "", err
+	}
+	fileName, err := config.Find(c.String("config"), wd)
+	if err != nil || fileName == "" {
+		return nil, "", err
+	}
+	file, err := config.Load(fileName)
+	return file, fileName, err
+}
+
+// resolveSettings loads the config file and layers env vars and flags on top.
+func resolveSettings(c *cli.Context) (*config.Settings, error) {
+	file, fileName, err := loadConfigFile(c)
+	if err != nil {
+		return nil, err
+	}
+	flags := func(name string) (string, bool) {
+		if !c.IsSet(name) {
+			return "", false
+		}
+		return c.String(name), true
+	}
+	settings, err := config.Resolve(file, fileName, os.LookupEnv, flags)
+	if err != nil {
+		return nil, err
+	}
+	if err := logging.Configure(settings.String("log-format"), settings.String("log-level")); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// resolveEnvSettings resolves a named environment from the config file alone,
+// so flags and env vars meant for one environment can't leak into another.
+func resolveEnvSettings(file *config.File, fileName, env string) (*config.Settings, error) {
+	none := func(string) (string, bool) { return "", false }
+	flags := func(name string) (string, bool) {
+		if name == "env" {
+			return env, true
+		}
+		return "", false
+	}
+	return config.Resolve(file, fileName, none, flags)
+}
+
+// composerFromSettings builds the Composer environment to sync.
+func composerFromSettings(s *config.Settings) (*deploy.ComposerEnv, error) {
+	if err := s.Require("project", "location", "name"); err != nil {
+		return nil, err
+	}
+	concurrency, err := s.Int("concurrency")
+	if err != nil {
+		return nil, err
+	}
+	maxStop, err := s.Int("max-stop")
+	if err != nil {
+		return nil, err
+	}
+	drainTimeout, err := s.Duration("drain-timeout")
+	if err != nil {
+		return nil, err
+	}
+	deployTimeout, err := s.Duration("deploy-timeout")
+	if err != nil {
+		return nil, err
+	}
+	lockTTL, err := s.Duration("lock-ttl")
+	if err != nil {
+		return nil, err
+	}
+	retention, err := s.Int("snapshot-retention")
+	if err != nil {
+		return nil, err
+	}
+	rollback, err := s.Bool("rollback-on-import-error")
+	if err != nil {
+		return nil, err
+	}
+	onTimeout := s.String("drain-on-timeout")
+	switch onTimeout {
+	case deploy.DrainForce, deploy.DrainSkip, deploy.DrainFail:
+	default:
+		return nil, fmt.Errorf("drain-on-timeout from %s must be force, skip or fail, got %q", s.Source("drain-on-timeout"), onTimeout)
+	}
+	protected := make(map[string]bool)
+	for _, dag := range s.List("protected") {
+		protected[dag] = true
+	}
+	composer := &deploy.ComposerEnv{
+		Name:              s.String("name"),
+		Project:           s.String("project"),
+		Location:          s.String("location"),
+		LocalDagsDir:      s.String("dags"),
+		LocalPluginsDir:   s.String("plugins"),
+		LocalDataDir:      s.String("data"),
+		VariablesFile:     s.String("variables"),
+		VariablesOverlays: s.List("variable-overlays"),
+		ConnectionsFile:   s.String("connections"),
+		ConnTypes:         s.List("conn-types"),
+		RunningDagsFile:   s.String("list"),
+		Env:               s.String("env"),
+		Concurrency:       concurrency,
+		MaxStop:           maxStop,
+		ProtectedDags:     protected,
+		DrainTimeout:      drainTimeout,
+		DrainOnTimeout:    onTimeout,
+		DeployTimeout:     deployTimeout,
+		LockTTL:           lockTTL,
+		SnapshotRetention: retention,
+
+		RollbackOnImportError: rollback,
+	}
+	if dir := s.String("history-dir"); dir != "" {
+		composer.History = store.Dir{Root: dir}
+	}
+	return composer, nil
+}
+
+// printSettings writes every resolved setting with its source.
+func printSettings(s *config.Settings) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, v := range s.All() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Key, v.Value, v.Source)
+	}
+	w.Flush()
+}
+
+// promote resolves the --from and --to environments and promotes the DAGs in
+// the target environment's running list. The target is locked for the whole
+// promotion, which stops before the next DAG if the lock is lost.
+func promote(c *cli.Context) ([]deploy.PromoteResult, error) {
+	file, fileName, err := loadConfigFile(c)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("promote needs a config file declaring environments")
+	}
+	fromSettings, err := resolveEnvSettings(file, fileName, c.String("from"))
+	if err != nil {
+		return nil, err
+	}
+	to := c.String("to")
+	if to == "" {
+		to = fromSettings.String("promote-to")
+	}
+	if to == "" {
+		return nil, fmt.Errorf("no --to given and %s has no promote_to", c.String("from"))
+	}
+	toSettings, err := resolveEnvSettings(file, fileName, to)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := composerFromSettings(fromSettings)
+	if err != nil {
+		return nil, err
+	}
+	target, err := composerFromSettings(toSettings)
+	if err != nil {
+		return nil, err
+	}
+	if err := from.Configure(); err != nil {
+		return nil, fmt.Errorf("configure %s: %v", c.String("from"), err)
+	}
+	if err := target.Configure(); err != nil {
+		return nil, fmt.Errorf("configure %s: %v", to, err)
+	}
+	dags, err := deploy.ReadRunningDags(toSettings.String("list"), to, toSettings.String("dags"))
+	if err != nil {
+		return nil, err
+	}
+	target.Provenance = deploy.DetectProvenance(from.LocalDagsDir, version)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lock, release, err := holdLock(target, func(err error) {
+		logging.Log.Errorf("lost the environment lock, stopping the promotion: %v", err)
+		cancel()
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	target.Context = ctx
+	results, err := from.Promote(target, dags.IDs())
+	if lockErr := lock.Err(); lockErr != nil {
+		return results, fmt.Errorf("lost the environment lock during the promotion: %v", lockErr)
+	}
+	return results, err
+}
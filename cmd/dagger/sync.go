@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/inshur/dagger/pkg/ci"
+	"github.com/inshur/dagger/pkg/config"
+	"github.com/inshur/dagger/pkg/deploy"
+	"github.com/inshur/dagger/pkg/lease"
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/inshur/dagger/pkg/reconcile"
+	"github.com/inshur/dagger/pkg/tracing"
+	"github.com/urfave/cli"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// syncOnce resolves the settings and runs a full sync, recording it in the
+// deployments log, failed or not, once the composer environment is set up:
+// a sync refused by validation or the lock is recorded too, as long as the
+// history store, the environment bucket by default, can be found. The
+// environment is locked for the sync unless the caller already holds its
+// lock, and losing the lock, or ctx being cancelled, stops the sync before it
+// starts DAGs. The whole sync is traced under one "sync" span.
+func syncOnce(ctx context.Context, c *cli.Context, locked bool) (record *deploy.Deployment, err error) {
+	settings, err := resolveSettings(c)
+	if err != nil {
+		return nil, fmt.Errorf("config error: %s", err)
+	}
+	sinks, err := notifySinks(settings)
+	if err != nil {
+		return nil, fmt.Errorf("config error: %s", err)
+	}
+	composer, err := composerFromSettings(settings)
+	if err != nil {
+		return nil, fmt.Errorf("config error: %s", err)
+	}
+	started := time.Now()
+	composer.DeployID = deploy.NewDeployID(started)
+	composer.Log = logging.Run(composer.DeployID, composer.Name)
+	ctx, span := tracing.Tracer().Start(ctx, "sync", trace.WithAttributes(
+		tracing.DeployID.String(composer.DeployID),
+		tracing.Environment.String(composer.Name),
+	))
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	composer.Context = ctx
+	composer.Provenance = deploy.DetectProvenance(composer.LocalDagsDir, version)
+	var plan *deploy.Plan
+	defer func() {
+		r := composer.NewDeployment(started, composer.Provenance, plan, err)
+		if err := recordDeployment(composer, r); err != nil {
+			fmt.Fprintf(os.Stderr, "error recording deployment: %s\n", err)
+		}
+		notifyDeployment(sinks, settings.String("env"), r)
+		reportCI(settings, r, plan)
+		record = &r
+	}()
+	fmt.Printf("Composer environment: %s\n", composer.Name)
+	fmt.Printf("Project: %s, Location: %s\n", composer.Project, composer.Location)
+	fmt.Println()
+	if err := composer.Validate(); err != nil {
+		annotate(os.Stdout, ci.ValidationAnnotations(err))
+		return nil, fmt.Errorf("validation failed, refusing to sync:\n%s", err)
+	}
+	if err := composer.Configure(); err != nil {
+		return nil, fmt.Errorf("configure error: %s", err)
+	}
+	var lock *lease.Lease
+	if !locked {
+		l, release, err := holdLock(composer, func(err error) {
+			composer.Log.Errorf("lost the environment lock, stopping the sync: %v", err)
+			cancel()
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		lock = l
+	}
+	list := settings.String("list")
+	if composer.DagSpecs, err = deploy.ReadRunningDags(list, composer.Env, composer.LocalDagsDir); err != nil {
+		return nil, fmt.Errorf("couldn't read running dags list %v: %v", list, err)
+	}
+	snapshot, err := composer.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("snapshot error: %s", err)
+	}
+	// the ID is suffixed when another deploy started in the same second
+	composer.Log = logging.Run(composer.DeployID, composer.Name)
+	span.SetAttributes(tracing.DeployID.String(composer.DeployID))
+	fmt.Printf("Deploy %s, undo with `dagger rollback %s`\n", snapshot.ID, snapshot.ID)
+	var syncErr error
+	plan, syncErr = runSync(composer, settings)
+	if lock != nil && lock.Err() != nil {
+		lost := fmt.Errorf("lost the environment lock during the sync: %v", lock.Err())
+		if syncErr != nil {
+			lost = fmt.Errorf("%v\n%v", lost, syncErr)
+		}
+		syncErr = lost
+	}
+	return nil, syncErr
+}
+
+// recordDeployment appends d to the deployments log. A sync refused by
+// validation stops before Configure, so the environment bucket holding the
+// log is looked up first unless history-dir is set.
+func recordDeployment(composer *deploy.ComposerEnv, d deploy.Deployment) error {
+	if composer.History == nil && composer.DagBucketPrefix == "" {
+		if err := composer.Configure(); err != nil {
+			return fmt.Errorf("couldn't find the environment bucket: %s", err)
+		}
+	}
+	return composer.RecordDeployment(d)
+}
+
+// holdLock locks the environment and keeps renewing the lock in the
+// background until release is called. onLost is called if the lock is
+// broken or taken over in the meantime.
+func holdLock(composer *deploy.ComposerEnv, onLost func(error)) (*lease.Lease, func(), error) {
+	l, err := composer.Lock(deploy.LockOwner(composer.Provenance))
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't lock environment %s: %v", composer.Name, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go l.Keep(ctx, onLost)
+	release := func() {
+		cancel()
+		if err := l.Release(); err != nil {
+			logging.Log.Errorf("error releasing the environment lock: %v", err)
+		}
+	}
+	return l, release, nil
+}
+
+// syncLoop syncs every interval until interrupted, never exiting on a failed
+// sync. The environment stays locked for the whole loop.
+func syncLoop(c *cli.Context) error {
+	settings, err := resolveSettings(c)
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	interval, err := settings.Duration("interval")
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	backoff, err := settings.Duration("retry-backoff")
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	composer, err := composerFromSettings(settings)
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	if err := composer.Configure(); err != nil {
+		return fmt.Errorf("configure error: %s", err)
+	}
+	composer.Provenance = deploy.DetectProvenance(composer.LocalDagsDir, version)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lock, release, err := holdLock(composer, func(err error) {
+		logging.Log.Errorf("lost the environment lock, stopping: %v", err)
+		cancel()
+	})
+	if err != nil {
+		return err
+	}
+	defer release()
+	loop := reconcile.Loop{
+		Interval: interval,
+		Backoff:  backoff,
+		Logf:     logging.Log.Infof,
+		Errorf:   logging.Log.Errorf,
+		Sync: countCycles(func(ctx context.Context) (string, error) {
+			record, err := syncOnce(ctx, c, true)
+			if err != nil {
+				return "", err
+			}
+			return summarize(record), nil
+		}),
+	}
+	serveMetrics(c.String("metrics-addr"))
+	loop.Run(ctx)
+	if err := lock.Err(); err != nil {
+		return fmt.Errorf("lost the environment lock: %v", err)
+	}
+	return nil
+}
+
+// summarize describes the changes of a deployment in one line.
+func summarize(d *deploy.Deployment) string {
+	if d.Plan == nil {
+		return fmt.Sprintf("deploy %s", d.ID)
+	}
+	p := d.Plan
+	return fmt.Sprintf("deploy %s: %d started, %d restarted, %d stopped, %d paused, %d unpaused, %d unchanged",
+		d.ID, p.Start, p.Restart, p.Stop, p.Pause, p.Unpause, p.Unchanged)
+}
+
+// runSync deploys the local plugins, data and DAGs to the environment, and
+// imports the variables and connections files when import-variables and
+// import-connections are set. The returned plan is nil when the sync failed
+// before planning.
+func runSync(composer *deploy.ComposerEnv, settings *config.Settings) (*deploy.Plan, error) {
+	importVariables, err := settings.Bool("import-variables")
+	if err != nil {
+		return nil, fmt.Errorf("config error: %s", err)
+	}
+	importConnections, err := settings.Bool("import-connections")
+	if err != nil {
+		return nil, fmt.Errorf("config error: %s", err)
+	}
+	if err := composer.SyncPlugins(); err != nil {
+		return nil, fmt.Errorf("sync plugins error: %s", err)
+	}
+	if err := composer.SyncData(); err != nil {
+		return nil, fmt.Errorf("sync data error: %s", err)
+	}
+	if importVariables {
+		if err := composer.ImportVariables(); err != nil {
+			return nil, fmt.Errorf("import variables error: %s", err)
+		}
+	}
+	if importConnections {
+		if _, err := composer.ImportConnections(); err != nil {
+			return nil, fmt.Errorf("import connections error: %s", err)
+		}
+	}
+	plan, err := composer.Plan(settings.String("list"))
+	if err != nil {
+		return nil, fmt.Errorf("plan error: %s", err)
+	}
+	plan.Print(os.Stdout)
+	if err := composer.Apply(plan); err != nil {
+		return plan, fmt.Errorf("apply error: %s", err)
+	}
+	composer.StartMonitoringDag()
+	return plan, nil
+}
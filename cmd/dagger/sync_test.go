@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/inshur/dagger/pkg/deploy"
+	"github.com/inshur/dagger/pkg/store"
+	"github.com/urfave/cli"
+)
+
+func TestSyncRecordsValidationFailure(t *testing.T) {
+	dir := t.TempDir()
+	history := filepath.Join(dir, "history")
+	connections := filepath.Join(dir, "connections.json")
+	if err := ioutil.WriteFile(connections, []byte(`[{"name": "api", "port": 80}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var syncErr error
+	app := cli.NewApp()
+	app.Commands = []cli.Command{{
+		Name:  "sync",
+		Flags: settingFlags(),
+		Action: func(c *cli.Context) error {
+			_, syncErr = syncOnce(context.Background(), c, false)
+			return nil
+		},
+	}}
+	_, err := captureStdout(t, func() error {
+		return app.Run([]string{"dagger", "sync",
+			"--project", "analytics-prod", "--location", "europe-west1", "--name", "composer-prod",
+			"--dags", filepath.Join(dir, "dags"), "--connections", connections, "--history-dir", history})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if syncErr == nil || !strings.Contains(syncErr.Error(), "validation failed") {
+		t.Fatalf("expected the sync to be refused by validation, got %v", syncErr)
+	}
+
+	c := deploy.ComposerEnv{History: store.Dir{Root: history}}
+	deployments, err := c.ReadDeployments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployments) != 1 || deployments[0].Status != deploy.DeployFailed || !strings.Contains(deployments[0].Error, "validation failed") {
+		t.Errorf("expected the refused sync to be recorded as failed, got %+v", deployments)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/inshur/dagger/pkg/tracing"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var traceShutdown func(context.Context) error
+
+// setupTracing installs the trace exporter of the command settings. Commands
+// that sync call it once before their first sync, so the syncs of long
+// running modes share one exporter.
+func setupTracing(c *cli.Context) error {
+	settings, err := resolveSettings(c)
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter: settings.String("trace-exporter"),
+		Endpoint: settings.String("trace-endpoint"),
+		File:     settings.String("trace-file"),
+		Version:  version,
+	})
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	traceShutdown = shutdown
+	// log.Fatal and cli exit errors exit without running deferred calls, so
+	// the spans are flushed on their way out too
+	logrus.RegisterExitHandler(flushTraces)
+	exit := cli.OsExiter
+	cli.OsExiter = func(code int) {
+		flushTraces()
+		exit(code)
+	}
+	return nil
+}
+
+// flushTraces exports the spans still buffered, giving up after a few
+// seconds if the collector is unreachable.
+func flushTraces() {
+	if traceShutdown == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := traceShutdown(ctx); err != nil {
+		logging.Log.Errorf("error exporting traces: %v", err)
+	}
+	traceShutdown = nil
+}
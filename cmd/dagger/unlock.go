@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/inshur/dagger/pkg/lease"
+	"github.com/inshur/dagger/pkg/store"
+	"github.com/urfave/cli"
+)
+
+// unlock removes the environment lock, refusing to remove a lock that is
+// still being renewed unless --force is set.
+func unlock(c *cli.Context) error {
+	settings, err := resolveSettings(c)
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	composer, err := composerFromSettings(settings)
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	if err := composer.Configure(); err != nil {
+		return fmt.Errorf("configure error: %s", err)
+	}
+	info, err := composer.Unlock(c.Bool("force"))
+	if err == store.ErrNotExist {
+		fmt.Printf("environment %s is not locked\n", composer.Name)
+		return nil
+	}
+	var held *lease.HeldError
+	if errors.As(err, &held) {
+		return fmt.Errorf("%v, last renewed %s; use --force to remove it anyway", held,
+			held.Info.Heartbeat.Format(time.RFC3339))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("removed lock held by %s since %s\n", info.Owner, info.Acquired.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/inshur/dagger/pkg/config"
+	"github.com/inshur/dagger/pkg/deploy"
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/inshur/dagger/pkg/store"
+	"github.com/inshur/dagger/pkg/watch"
+	"github.com/urfave/cli"
+)
+
+func watchFlags() []cli.Flag {
+	return append(settingFlags(),
+		cli.StringFlag{Name: "local-airflow", Usage: "AIRFLOW_HOME of a local Airflow to push to instead of the Composer environment"},
+		cli.StringFlag{Name: "airflow-binary", Value: "airflow", Usage: "Airflow CLI of the local Airflow"},
+		cli.DurationFlag{Name: "debounce", Value: watch.DefaultDebounce, Usage: "How long to wait for changes to settle before pushing"},
+	)
+}
+
+// watchTarget builds the environment changes are pushed to: a local Airflow
+// whose home folder stands in for the bucket, or the configured Composer
+// environment.
+func watchTarget(c *cli.Context, s *config.Settings) (*deploy.ComposerEnv, error) {
+	home := c.String("local-airflow")
+	if home == "" {
+		composer, err := composerFromSettings(s)
+		if err != nil {
+			return nil, fmt.Errorf("config error: %s", err)
+		}
+		if err := composer.Configure(); err != nil {
+			return nil, fmt.Errorf("configure error: %s", err)
+		}
+		return composer, nil
+	}
+	deployTimeout, err := s.Duration("deploy-timeout")
+	if err != nil {
+		return nil, fmt.Errorf("config error: %s", err)
+	}
+	return &deploy.ComposerEnv{
+		Name:            "local",
+		LocalDagsDir:    s.String("dags"),
+		LocalPluginsDir: s.String("plugins"),
+		LocalDataDir:    s.String("data"),
+		Env:             s.String("env"),
+		DeployTimeout:   deployTimeout,
+		Runner:          deploy.LocalAirflow{Binary: c.String("airflow-binary")},
+		Objects:         store.Dir{Root: home},
+	}, nil
+}
+
+// runWatch pushes local changes to the dags, plugins and data folders as
+// they happen, restarting only the DAGs whose file changed. The environment
+// stays locked for the whole watch, which stops if the lock is lost.
+func runWatch(c *cli.Context) error {
+	settings, err := resolveSettings(c)
+	if err != nil {
+		return fmt.Errorf("config error: %s", err)
+	}
+	composer, err := watchTarget(c, settings)
+	if err != nil {
+		return err
+	}
+	list := settings.String("list")
+	running, err := deploy.ReadRunningDags(list, composer.Env, composer.LocalDagsDir)
+	if err != nil {
+		return fmt.Errorf("couldn't read running dags list %v: %v", list, err)
+	}
+	composer.DagSpecs = running
+	composer.Log = logging.Run("", composer.Name)
+	composer.Provenance = deploy.DetectProvenance(composer.LocalDagsDir, version)
+
+	var roots []string
+	for _, dir := range []string{composer.LocalDagsDir, composer.LocalPluginsDir, composer.LocalDataDir} {
+		if dir == "" {
+			continue
+		}
+		if _, err := os.Stat(dir); err == nil {
+			roots = append(roots, dir)
+		}
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("none of the dags, plugins and data folders exist")
+	}
+
+	w := watch.Watcher{
+		Roots:    roots,
+		Debounce: c.Duration("debounce"),
+		OnChange: func(paths []string) {
+			if composer.Context.Err() != nil {
+				return
+			}
+			changes, err := composer.MapChanges(paths)
+			if err != nil {
+				composer.Log.Errorf("error mapping changes: %v", err)
+				return
+			}
+			if len(changes) == 0 {
+				return
+			}
+			if err := composer.PushChanges(changes); err != nil {
+				composer.Log.Error(err)
+				return
+			}
+			composer.Log.Infof("pushed %d changes to %s", len(changes), composer.Name)
+		},
+		OnError: func(err error) {
+			composer.Log.Errorf("watch error: %v", err)
+		},
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lock, release, err := holdLock(composer, func(err error) {
+		composer.Log.Errorf("lost the environment lock, stopping: %v", err)
+		cancel()
+	})
+	if err != nil {
+		return err
+	}
+	defer release()
+	composer.Context = ctx
+	composer.Log.Infof("watching %v", roots)
+	err = w.Run(ctx)
+	if lockErr := lock.Err(); lockErr != nil {
+		return fmt.Errorf("lost the environment lock: %v", lockErr)
+	}
+	return err
+}
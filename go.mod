@@ -4,8 +4,17 @@ go 1.16
 
 require (
 	cloud.google.com/go/storage v1.15.0
+	github.com/BurntSushi/toml v0.4.1
 	github.com/bmatcuk/doublestar v1.3.4
-	github.com/urfave/cli v1.22.5 // indirect
-	google.golang.org/api v0.45.0 // indirect
-	gopkg.in/yaml.v2 v2.2.2 // indirect
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/prometheus/client_golang v1.11.0
+	github.com/sirupsen/logrus v1.8.1
+	github.com/urfave/cli v1.22.5
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	google.golang.org/api v0.45.0
+	gopkg.in/yaml.v2 v2.3.0
 )
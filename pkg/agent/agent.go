@@ -0,0 +1,121 @@
+// Package agent watches a branch of a git repository and syncs every commit
+// it moves to, GitOps style.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// convergedFile records the last commit synced, inside the workspace's
+// .git folder so it survives restarts without showing up in the tree.
+const convergedFile = "DAGGER_CONVERGED"
+
+// SyncFunc syncs the tree checked out at dir, the commit sha.
+type SyncFunc func(ctx context.Context, dir, sha string) (string, error)
+
+// Agent checks out the head of Branch of Repo, a local clone or bare
+// repository, into Workspace and syncs it whenever the branch moves.
+type Agent struct {
+	Repo      string
+	Branch    string
+	Workspace string
+	Sync      SyncFunc
+}
+
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Head returns the commit the branch points to in the repository.
+func (a *Agent) Head() (string, error) {
+	out, err := git("", "ls-remote", "--heads", a.Repo, "refs/heads/"+a.Branch)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("branch %v not found in %v", a.Branch, a.Repo)
+	}
+	return fields[0], nil
+}
+
+// Checkout makes the workspace a clean checkout of sha, cloning the
+// repository on first use.
+func (a *Agent) Checkout(sha string) error {
+	if _, err := os.Stat(filepath.Join(a.Workspace, ".git")); os.IsNotExist(err) {
+		if _, err := git("", "clone", "--no-checkout", a.Repo, a.Workspace); err != nil {
+			return err
+		}
+	}
+	steps := [][]string{
+		{"fetch", "--quiet", "origin", "refs/heads/" + a.Branch},
+		{"checkout", "--quiet", "--force", "--detach", sha},
+		{"clean", "--quiet", "-d", "--force", "-x"},
+	}
+	for _, args := range steps {
+		if _, err := git(a.Workspace, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Agent) convergedPath() string {
+	return filepath.Join(a.Workspace, ".git", convergedFile)
+}
+
+// Converged returns the last commit synced successfully, empty if none.
+func (a *Agent) Converged() string {
+	data, err := ioutil.ReadFile(a.convergedPath())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Cycle syncs the head of the branch unless it has already converged to it.
+// A failed sync is retried on the next cycle.
+func (a *Agent) Cycle(ctx context.Context) (string, error) {
+	sha, err := a.Head()
+	if err != nil {
+		return "", err
+	}
+	if sha == a.Converged() {
+		return fmt.Sprintf("%s is up to date at %.12s", a.Branch, sha), nil
+	}
+	summary, err := a.Deploy(ctx, sha)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("converged to %.12s: %s", sha, summary), nil
+}
+
+// Deploy checks out sha and syncs it, recording it as converged on success.
+func (a *Agent) Deploy(ctx context.Context, sha string) (string, error) {
+	if err := a.Checkout(sha); err != nil {
+		return "", err
+	}
+	summary, err := a.Sync(ctx, a.Workspace, sha)
+	if err != nil {
+		return "", fmt.Errorf("sync of %.12s failed: %v", sha, err)
+	}
+	if err := ioutil.WriteFile(a.convergedPath(), []byte(sha+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("error recording converged commit: %v", err)
+	}
+	return summary, nil
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func commit(t *testing.T, repo, content string) string {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(repo, "running_dags.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(t, repo, "add", "-A")
+	run(t, repo, "commit", "--quiet", "-m", content)
+	return run(t, repo, "rev-parse", "HEAD")
+}
+
+func TestAgent(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	run(t, repo, "init", "--quiet", "--initial-branch=main")
+	first := commit(t, repo, "finance_daily\n")
+
+	var synced []string
+	var failSync bool
+	a := Agent{
+		Repo:      repo,
+		Branch:    "main",
+		Workspace: filepath.Join(t.TempDir(), "workspace"),
+		Sync: func(ctx context.Context, dir, sha string) (string, error) {
+			content, err := ioutil.ReadFile(filepath.Join(dir, "running_dags.txt"))
+			if err != nil {
+				return "", err
+			}
+			synced = append(synced, sha[:7]+" "+strings.TrimSpace(string(content)))
+			if failSync {
+				return "", errors.New("apply error")
+			}
+			return "ok", nil
+		},
+	}
+
+	ctx := context.Background()
+	if _, err := a.Cycle(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if summary, err := a.Cycle(ctx); err != nil || !strings.Contains(summary, "up to date") {
+		t.Errorf("expected no sync without a new commit, got %q, %v", summary, err)
+	}
+	if a.Converged() != first {
+		t.Errorf("expected to converge to %s, got %s", first, a.Converged())
+	}
+
+	second := commit(t, repo, "finance_daily\nweekly_report\n")
+	failSync = true
+	if _, err := a.Cycle(ctx); err == nil {
+		t.Errorf("expected the failed sync to be reported")
+	}
+	if a.Converged() != first {
+		t.Errorf("a failed sync must not converge")
+	}
+	failSync = false
+	if _, err := a.Cycle(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if a.Converged() != second {
+		t.Errorf("expected to converge to %s, got %s", second, a.Converged())
+	}
+	want := []string{
+		first[:7] + " finance_daily",
+		second[:7] + " finance_daily\nweekly_report",
+		second[:7] + " finance_daily\nweekly_report",
+	}
+	if strings.Join(synced, "|") != strings.Join(want, "|") {
+		t.Errorf("expected syncs %q, got %q", want, synced)
+	}
+}
@@ -0,0 +1,170 @@
+package ci
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inshur/dagger/pkg/deploy"
+	"github.com/inshur/dagger/pkg/lint"
+)
+
+// setenv sets an environment variable until the test ends.
+func setenv(t *testing.T, key, value string) {
+	previous, had := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func deployment() (deploy.Deployment, *deploy.Plan) {
+	p := &deploy.Plan{
+		Start:   map[string]string{"finance_daily": "finance/daily.py", "billing_hourly": "billing_hourly.py"},
+		Stop:    map[string]string{"legacy_export": "legacy_export.py"},
+		Restart: map[string]string{"billing_hourly": "billing_hourly.py"},
+		Pause:   map[string]bool{"audit_export": true},
+	}
+	d := deploy.Deployment{
+		ID:          "20210601T120000Z",
+		Environment: "composer-prod",
+		Started:     time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		Duration:    42,
+		Provenance:  deploy.Provenance{Commit: "4f2c1e9b7d3a5c8e", User: "jdoe"},
+		Status:      deploy.DeployFailed,
+		Error:       "apply error: sync failed for 1 DAGs:\nbilling_hourly: dag billing_hourly was not parsed",
+		Dags: map[string]string{
+			"finance_daily":  "started",
+			"billing_hourly": "failed: dag billing_hourly was not parsed",
+			"legacy_export":  "skipped: tasks still running",
+			"audit_export":   "paused",
+		},
+	}
+	return d, p
+}
+
+func TestWriteAnnotations(t *testing.T) {
+	setenv(t, "GITHUB_WORKSPACE", "/home/runner/work/dags")
+	var buf bytes.Buffer
+	anns := ValidationAnnotations(deploy.ValidationErrors{
+		{File: "/home/runner/work/dags/config/variables.json", Line: 3, Field: "env", Message: "must be a string"},
+		{File: "config/running_dags.txt", Message: "100% wrong,\nreally"},
+	})
+	anns = append(anns, LintAnnotations("dags", []lint.Finding{
+		{Rule: "catchup", Level: lint.LevelWarning, File: "finance/daily.py", Line: 7, Message: "DAGs must set catchup explicitly"},
+	})...)
+	if err := WriteAnnotations(&buf, anns); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := "::error file=config/variables.json,line=3,title=dagger validate::env: must be a string\n" +
+		"::error file=config/running_dags.txt,title=dagger validate::100%25 wrong,%0Areally\n" +
+		"::warning file=dags/finance/daily.py,line=7,title=dagger lint%3A catchup::DAGs must set catchup explicitly\n"
+	if buf.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, buf.String())
+	}
+	if ValidationAnnotations(os.ErrNotExist) != nil {
+		t.Errorf("expected no annotations for other errors")
+	}
+}
+
+func TestSetOutputs(t *testing.T) {
+	d, _ := deployment()
+	outputs := Outputs(d)
+	for k, want := range map[string]string{
+		"deploy_id":      "20210601T120000Z",
+		"dags_started":   "finance_daily",
+		"dags_stopped":   "",
+		"dags_failed":    "billing_hourly",
+		"dags_restarted": "",
+		"status":         "failed",
+	} {
+		if outputs[k] != want {
+			t.Errorf("expected output %s=%q, got %q", k, want, outputs[k])
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "output")
+	setenv(t, "GITHUB_OUTPUT", file)
+	var buf bytes.Buffer
+	if err := SetOutputs(&buf, map[string]string{"deploy_id": d.ID, "dags_started": "a,b"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "dags_started=a,b\ndeploy_id=20210601T120000Z\n"; string(got) != want || buf.Len() != 0 {
+		t.Errorf("expected %q in the output file, got %q", want, got)
+	}
+
+	os.Unsetenv("GITHUB_OUTPUT")
+	if err := SetOutputs(&buf, map[string]string{"deploy_id": d.ID}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := "::set-output name=deploy_id::20210601T120000Z\n"; buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+}
+
+func TestStepSummary(t *testing.T) {
+	d, p := deployment()
+	file := filepath.Join(t.TempDir(), "summary.md")
+	setenv(t, "GITHUB_STEP_SUMMARY", file)
+	if err := WriteStepSummary(d, p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"### dagger sync of composer-prod failed",
+		"Deploy `20210601T120000Z` of `4f2c1e9b7d3a` by jdoe",
+		"1 started, 1 restarted, 1 stopped, 1 paused, 0 unpaused, 0 unchanged.",
+		"| `audit_export` | pause |  | paused |",
+		"| `billing_hourly` | restart | billing_hourly.py | failed: dag billing_hourly was not parsed |",
+		"| `finance_daily` | start | finance/daily.py | started |",
+		"| `legacy_export` | stop | legacy_export.py | skipped: tasks still running |",
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("expected %q in summary:\n%s", want, got)
+		}
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	d, _ := deployment()
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, d); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var report junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("expected a JUnit report: %s\n%s", err, buf.String())
+	}
+	if len(report.Suites) != 1 {
+		t.Fatalf("expected one suite, got %+v", report)
+	}
+	suite := report.Suites[0]
+	if suite.Tests != 5 || suite.Failures != 2 || suite.Skipped != 1 {
+		t.Errorf("expected 5 tests, 2 failures and 1 skipped, got %d, %d and %d", suite.Tests, suite.Failures, suite.Skipped)
+	}
+	failed := make(map[string]string)
+	for _, c := range suite.Cases {
+		if c.Failure != nil {
+			failed[c.Name] = c.Failure.Message
+		}
+	}
+	if failed["billing_hourly"] != "dag billing_hourly was not parsed" || failed["sync"] != "apply error: sync failed for 1 DAGs:" {
+		t.Errorf("unexpected failures: %v", failed)
+	}
+}
@@ -0,0 +1,252 @@
+// Package ci reports dagger's results in the native formats of CI systems:
+// annotations, a job summary and step outputs on GitHub Actions, and a JUnit
+// report that GitLab shows as test results.
+package ci
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/inshur/dagger/pkg/deploy"
+	"github.com/inshur/dagger/pkg/lint"
+)
+
+// GitHubActions reports whether dagger runs in a GitHub Actions job.
+func GitHubActions() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// Annotation levels.
+const (
+	LevelError   = "error"
+	LevelWarning = "warning"
+)
+
+// Annotation is a problem GitHub shows on a line of a file.
+type Annotation struct {
+	Level   string
+	File    string
+	Line    int
+	Title   string
+	Message string
+}
+
+// ValidationAnnotations annotates the problems of a failed validation. It
+// returns nil when err doesn't come from validation.
+func ValidationAnnotations(err error) []Annotation {
+	errs, ok := err.(deploy.ValidationErrors)
+	if !ok {
+		return nil
+	}
+	anns := make([]Annotation, 0, len(errs))
+	for _, e := range errs {
+		msg := e.Message
+		if e.Field != "" {
+			msg = e.Field + ": " + msg
+		}
+		anns = append(anns, Annotation{Level: LevelError, File: e.File, Line: e.Line, Title: "dagger validate", Message: msg})
+	}
+	return anns
+}
+
+// LintAnnotations annotates lint findings, whose files are relative to
+// dagsDir.
+func LintAnnotations(dagsDir string, findings []lint.Finding) []Annotation {
+	anns := make([]Annotation, 0, len(findings))
+	for _, f := range findings {
+		level := LevelError
+		if f.Level == lint.LevelWarning {
+			level = LevelWarning
+		}
+		anns = append(anns, Annotation{
+			Level:   level,
+			File:    filepath.Join(dagsDir, filepath.FromSlash(f.File)),
+			Line:    f.Line,
+			Title:   "dagger lint: " + f.Rule,
+			Message: f.Message,
+		})
+	}
+	return anns
+}
+
+// WriteAnnotations prints anns as GitHub workflow commands. Absolute paths
+// are made relative to the workspace so GitHub can place them.
+func WriteAnnotations(w io.Writer, anns []Annotation) error {
+	workspace := os.Getenv("GITHUB_WORKSPACE")
+	for _, a := range anns {
+		file := a.File
+		if workspace != "" && filepath.IsAbs(file) {
+			if rel, err := filepath.Rel(workspace, file); err == nil && !strings.HasPrefix(rel, "..") {
+				file = rel
+			}
+		}
+		props := []string{"file=" + escapeProperty(filepath.ToSlash(file))}
+		if a.Line > 0 {
+			props = append(props, fmt.Sprintf("line=%d", a.Line))
+		}
+		if a.Title != "" {
+			props = append(props, "title="+escapeProperty(a.Title))
+		}
+		level := a.Level
+		if level == "" {
+			level = LevelError
+		}
+		if _, err := fmt.Fprintf(w, "::%s %s::%s\n", level, strings.Join(props, ","), escapeData(a.Message)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapeData escapes the message of a workflow command.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property value of a workflow command.
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// Outputs are the step outputs of a sync: the deploy ID and comma separated
+// lists of the DAGs it started, restarted, stopped and failed.
+func Outputs(d deploy.Deployment) map[string]string {
+	byOutcome := make(map[string][]string)
+	for dag, outcome := range d.Dags {
+		key := outcome
+		if strings.HasPrefix(outcome, "failed: ") {
+			key = "failed"
+		}
+		byOutcome[key] = append(byOutcome[key], dag)
+	}
+	outputs := map[string]string{"deploy_id": d.ID, "status": d.Status}
+	for _, outcome := range []string{"started", "restarted", "stopped", "failed"} {
+		dags := byOutcome[outcome]
+		sort.Strings(dags)
+		outputs["dags_"+outcome] = strings.Join(dags, ",")
+	}
+	return outputs
+}
+
+// SetOutputs sets step outputs in the $GITHUB_OUTPUT file, or with the
+// set-output command on w for runners that predate it.
+func SetOutputs(w io.Writer, outputs map[string]string) error {
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	path := os.Getenv("GITHUB_OUTPUT")
+	if path == "" {
+		for _, name := range names {
+			if _, err := fmt.Fprintf(w, "::set-output name=%s::%s\n", name, escapeData(outputs[name])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%s\n", name, strings.ReplaceAll(outputs[name], "\n", " "))
+	}
+	return appendFile(path, b.String())
+}
+
+// WriteStepSummary appends the markdown summary of a sync to the
+// $GITHUB_STEP_SUMMARY file. It does nothing outside GitHub Actions.
+func WriteStepSummary(d deploy.Deployment, p *deploy.Plan) error {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return nil
+	}
+	return appendFile(path, StepSummary(d, p))
+}
+
+// StepSummary is the markdown job summary of a sync: its status, the plan
+// and the result of every DAG it changed. p is nil when the sync failed
+// before planning.
+func StepSummary(d deploy.Deployment, p *deploy.Plan) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### dagger sync of %s %s\n\n", d.Environment, d.Status)
+	fmt.Fprintf(&b, "Deploy `%s`", d.ID)
+	if d.Commit != "" {
+		fmt.Fprintf(&b, " of `%.12s`", d.Commit)
+	}
+	if d.User != "" {
+		fmt.Fprintf(&b, " by %s", d.User)
+	}
+	fmt.Fprintf(&b, ", undo with `dagger rollback %s`.\n\n", d.ID)
+	if d.Error != "" {
+		fmt.Fprintf(&b, "```\n%s\n```\n\n", strings.TrimSpace(d.Error))
+	}
+	if p == nil {
+		return b.String()
+	}
+	s := p.Summary()
+	fmt.Fprintf(&b, "%d started, %d restarted, %d stopped, %d paused, %d unpaused, %d unchanged.\n\n",
+		s.Start, s.Restart, s.Stop, s.Pause, s.Unpause, s.Unchanged)
+
+	rows := planRows(p)
+	if len(rows) == 0 {
+		return b.String()
+	}
+	b.WriteString("| DAG | Plan | File | Result |\n|---|---|---|---|\n")
+	for _, r := range rows {
+		result := d.Dags[r.dag]
+		if result == "" {
+			result = "not applied"
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s |\n", r.dag, r.action, markdownCell(r.file), markdownCell(result))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+type planRow struct {
+	dag, action, file string
+}
+
+// planRows lists the changes of p by DAG.
+func planRows(p *deploy.Plan) []planRow {
+	var rows []planRow
+	for dag, file := range p.Start {
+		action := "start"
+		if _, ok := p.Restart[dag]; ok {
+			action = "restart"
+		}
+		rows = append(rows, planRow{dag, action, file})
+	}
+	for dag, file := range p.Stop {
+		rows = append(rows, planRow{dag, "stop", file})
+	}
+	for dag := range p.Pause {
+		rows = append(rows, planRow{dag, "pause", ""})
+	}
+	for dag := range p.Unpause {
+		rows = append(rows, planRow{dag, "unpause", ""})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].dag < rows[j].dag })
+	return rows
+}
+
+// markdownCell keeps text on one line of a markdown table.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func appendFile(path, text string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
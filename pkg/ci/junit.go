@@ -0,0 +1,113 @@
+package ci
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/inshur/dagger/pkg/deploy"
+)
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      float64     `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes a deployment as a JUnit report: one test case for the
+// sync and one for every DAG it touched, failed when the DAG failed and
+// skipped when it was left alone while draining.
+func WriteJUnit(w io.Writer, d deploy.Deployment) error {
+	suite := junitSuite{
+		Name:      "dagger sync " + d.Environment,
+		Time:      d.Duration,
+		Timestamp: d.Started.Format("2006-01-02T15:04:05"),
+	}
+	sync := junitCase{Name: "sync", Classname: "dagger." + d.Environment, SystemOut: "deploy " + d.ID}
+	if d.Status != deploy.DeploySucceeded {
+		sync.Failure = &junitMessage{Message: firstLine(d.Error), Text: d.Error}
+	}
+	suite.Cases = append(suite.Cases, sync)
+
+	dags := make([]string, 0, len(d.Dags))
+	for dag := range d.Dags {
+		dags = append(dags, dag)
+	}
+	sort.Strings(dags)
+	for _, dag := range dags {
+		outcome := d.Dags[dag]
+		c := junitCase{Name: dag, Classname: "dagger." + d.Environment + ".dags", SystemOut: outcome}
+		switch {
+		case strings.HasPrefix(outcome, "failed: "):
+			reason := strings.TrimPrefix(outcome, "failed: ")
+			c.Failure = &junitMessage{Message: firstLine(reason), Text: reason}
+			c.SystemOut = ""
+		case strings.HasPrefix(outcome, "skipped: "):
+			c.Skipped = &junitMessage{Message: strings.TrimPrefix(outcome, "skipped: ")}
+			c.SystemOut = ""
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	for _, c := range suite.Cases {
+		suite.Tests++
+		if c.Failure != nil {
+			suite.Failures++
+		}
+		if c.Skipped != nil {
+			suite.Skipped++
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// WriteJUnitFile writes the JUnit report of a deployment to path.
+func WriteJUnitFile(path string, d deploy.Deployment) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteJUnit(f, d); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func firstLine(s string) string {
+	return strings.SplitN(strings.TrimSpace(s), "\n", 2)[0]
+}
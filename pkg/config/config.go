@@ -0,0 +1,331 @@
+// Package config loads the declarative dagger.yaml / dagger.toml file and
+// resolves it together with DAGGER_* environment variables and CLI flags.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultFiles are looked up in the working directory when no config file is
+// passed explicitly.
+var DefaultFiles = []string{"dagger.yaml", "dagger.yml", "dagger.toml"}
+
+// File is the on-disk configuration.
+type File struct {
+	Environment Environment `yaml:"environment" toml:"environment"`
+	Paths       Paths       `yaml:"paths" toml:"paths"`
+	Variables   string      `yaml:"variables" toml:"variables"`
+	Connections string      `yaml:"connections" toml:"connections"`
+	// ImportVariables and ImportConnections import the files into Airflow
+	// during sync.
+	ImportVariables   bool `yaml:"import_variables" toml:"import_variables"`
+	ImportConnections bool `yaml:"import_connections" toml:"import_connections"`
+	// ConnTypes are connection types accepted in the connections file on
+	// top of the known ones, like those added by providers.
+	ConnTypes []string `yaml:"conn_types" toml:"conn_types"`
+	// Concurrency limits how many DAGs are stopped or started in parallel, 0
+	// means no limit.
+	Concurrency int    `yaml:"concurrency" toml:"concurrency"`
+	Safety      Safety `yaml:"safety" toml:"safety"`
+	Drain       Drain  `yaml:"drain" toml:"drain"`
+	// DeployTimeout is how long to wait for a deployed DAG to be parsed.
+	DeployTimeout string `yaml:"deploy_timeout" toml:"deploy_timeout"`
+	// RollbackOnImportError removes deployed files that fail to import.
+	RollbackOnImportError bool `yaml:"rollback_on_import_error" toml:"rollback_on_import_error"`
+	// Airflow is used by `dagger validate` to load the DAGs.
+	Airflow Airflow `yaml:"airflow" toml:"airflow"`
+	Lint    Lint    `yaml:"lint" toml:"lint"`
+	Loop    Loop    `yaml:"loop" toml:"loop"`
+	Log     Log     `yaml:"log" toml:"log"`
+	Tracing Tracing `yaml:"tracing" toml:"tracing"`
+	// Notify are the sinks the summary of every sync is sent to.
+	Notify []Notify `yaml:"notify" toml:"notify"`
+	CI     CI       `yaml:"ci" toml:"ci"`
+	// LockTTL is how long the environment lock outlives a run that stopped
+	// renewing it.
+	LockTTL string `yaml:"lock_ttl" toml:"lock_ttl"`
+	// HistoryDir keeps deploy snapshots in a local folder instead of the
+	// environment bucket.
+	HistoryDir string `yaml:"history_dir" toml:"history_dir"`
+	// SnapshotRetention is how many deploy snapshots are kept.
+	SnapshotRetention int `yaml:"snapshot_retention" toml:"snapshot_retention"`
+	// Environments are named overlays selected with --env.
+	Environments map[string]NamedEnvironment `yaml:"environments" toml:"environments"`
+}
+
+// NamedEnvironment is one of several Composer environments sharing the same
+// DAG repository. Its fields override the top level settings when selected.
+type NamedEnvironment struct {
+	Project  string `yaml:"project" toml:"project"`
+	Location string `yaml:"location" toml:"location"`
+	Name     string `yaml:"name" toml:"name"`
+	// List is the environment's running DAGs list.
+	List string `yaml:"list" toml:"list"`
+	// Variables are merged on top of the top level variables file.
+	Variables []string `yaml:"variables" toml:"variables"`
+	// Connections replaces the top level connections file.
+	Connections string `yaml:"connections" toml:"connections"`
+	// PromoteTo names the environment `dagger promote` copies DAGs to.
+	PromoteTo string `yaml:"promote_to" toml:"promote_to"`
+}
+
+// Environment identifies the Composer environment to sync.
+type Environment struct {
+	Project  string `yaml:"project" toml:"project"`
+	Location string `yaml:"location" toml:"location"`
+	Name     string `yaml:"name" toml:"name"`
+}
+
+// Paths are the local sources synced to the environment.
+type Paths struct {
+	List    string `yaml:"list" toml:"list"`
+	Dags    string `yaml:"dags" toml:"dags"`
+	Plugins string `yaml:"plugins" toml:"plugins"`
+	Data    string `yaml:"data" toml:"data"`
+}
+
+// Airflow is the local Airflow installation `dagger validate` loads the DAGs
+// with, either a Python interpreter or a container image.
+type Airflow struct {
+	Python string `yaml:"python" toml:"python"`
+	Image  string `yaml:"image" toml:"image"`
+}
+
+// Loop configures `dagger sync --loop`.
+type Loop struct {
+	// Interval is how often to sync.
+	Interval string `yaml:"interval" toml:"interval"`
+	// RetryBackoff is the delay before retrying a failed sync, doubled on
+	// every consecutive failure up to Interval.
+	RetryBackoff string `yaml:"retry_backoff" toml:"retry_backoff"`
+}
+
+// Log configures dagger's own log output.
+type Log struct {
+	// Format is text or json.
+	Format string `yaml:"format" toml:"format"`
+	// Level is the minimum level logged: debug, info, warn or error.
+	Level string `yaml:"level" toml:"level"`
+}
+
+// Tracing configures where the trace spans of syncs are exported.
+type Tracing struct {
+	// Exporter is none, otlp or file.
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// Endpoint is the OTLP/HTTP collector of the otlp exporter.
+	Endpoint string `yaml:"endpoint" toml:"endpoint"`
+	// File receives the spans of the file exporter.
+	File string `yaml:"file" toml:"file"`
+}
+
+// CI configures the reports written for CI systems.
+type CI struct {
+	// JUnitReport receives the DAGs a sync deployed as JUnit test cases.
+	JUnitReport string `yaml:"junit_report" toml:"junit_report"`
+}
+
+// Notify is a sink of sync summaries.
+type Notify struct {
+	// Type is webhook, slack or email.
+	Type string `yaml:"type" toml:"type"`
+	// URL receives the payloads of webhook and slack sinks.
+	URL string `yaml:"url" toml:"url"`
+	// On is always, failure or success.
+	On string `yaml:"on" toml:"on"`
+	// Environments limits the sink to syncs of these environments.
+	Environments []string `yaml:"environments" toml:"environments"`
+	// Template and Subject are Go templates of the message and of the
+	// email subject.
+	Template string `yaml:"template" toml:"template"`
+	Subject  string `yaml:"subject" toml:"subject"`
+	// SMTP is the host:port of the mail server of email sinks.
+	SMTP     string   `yaml:"smtp" toml:"smtp"`
+	From     string   `yaml:"from" toml:"from"`
+	To       []string `yaml:"to" toml:"to"`
+	Username string   `yaml:"username" toml:"username"`
+	// PasswordEnv names the environment variable holding the SMTP password,
+	// so it stays out of the config file.
+	PasswordEnv string `yaml:"password_env" toml:"password_env"`
+}
+
+// Lint configures `dagger lint`.
+type Lint struct {
+	// Rules overrides the level of lint rules: error, warning or off.
+	Rules map[string]string `yaml:"rules" toml:"rules"`
+	// HeavyImports are the modules that must not be imported at the top level
+	// of a DAG file.
+	HeavyImports []string `yaml:"heavy_imports" toml:"heavy_imports"`
+}
+
+// Safety guards against syncs that would remove more than intended.
+type Safety struct {
+	// MaxStop refuses a sync that would stop more DAGs than this, 0 disables
+	// the check.
+	MaxStop int `yaml:"max_stop" toml:"max_stop"`
+	// Protected DAGs are never stopped because they are missing from the
+	// running list.
+	Protected []string `yaml:"protected" toml:"protected"`
+}
+
+// Drain controls waiting for running tasks before a DAG is replaced or
+// deleted.
+type Drain struct {
+	// Timeout is a duration such as "10m", draining is disabled when empty.
+	Timeout string `yaml:"timeout" toml:"timeout"`
+	// OnTimeout is "force", "skip" or "fail".
+	OnTimeout string `yaml:"on_timeout" toml:"on_timeout"`
+}
+
+// Load reads a config file, picking the format from its extension.
+func Load(filename string) (*File, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read config file %s: %v", filename, err)
+	}
+	var f File
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		if err := yaml.UnmarshalStrict(data, &f); err != nil {
+			return nil, fmt.Errorf("couldn't parse %s: %v", filename, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), &f)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse %s: %v", filename, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("couldn't parse %s: unknown keys %v", filename, undecoded)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file format %s, expected .yaml or .toml", filename)
+	}
+	return &f, nil
+}
+
+// Find returns the config file to use: explicit when set, otherwise the first
+// of DefaultFiles present in dir. It returns "" when there is none.
+func Find(explicit, dir string) (string, error) {
+	if explicit != "" {
+		if _, err := os.Stat(explicit); err != nil {
+			return "", fmt.Errorf("config file %s: %v", explicit, err)
+		}
+		return explicit, nil
+	}
+	for _, name := range DefaultFiles {
+		p := filepath.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+// EnvironmentNames returns the names of the declared environments.
+func (f *File) EnvironmentNames() []string {
+	names := make([]string, 0, len(f.Environments))
+	for name := range f.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// envValues flattens the named environment into setting keys, omitting unset
+// fields.
+func (f *File) envValues(env string) (map[string]string, error) {
+	e, ok := f.Environments[env]
+	if !ok {
+		return nil, fmt.Errorf("environment %q is not declared in the config file, expected one of %v", env, f.EnvironmentNames())
+	}
+	v := map[string]string{
+		"project":           e.Project,
+		"location":          e.Location,
+		"name":              e.Name,
+		"list":              e.List,
+		"variable-overlays": strings.Join(e.Variables, ","),
+		"connections":       e.Connections,
+		"promote-to":        e.PromoteTo,
+	}
+	for k, val := range v {
+		if val == "" {
+			delete(v, k)
+		}
+	}
+	return v, nil
+}
+
+// values flattens the file into setting keys, omitting unset fields.
+func (f *File) values() map[string]string {
+	v := map[string]string{
+		"project":     f.Environment.Project,
+		"location":    f.Environment.Location,
+		"name":        f.Environment.Name,
+		"list":        f.Paths.List,
+		"dags":        f.Paths.Dags,
+		"plugins":     f.Paths.Plugins,
+		"data":        f.Paths.Data,
+		"variables":   f.Variables,
+		"connections": f.Connections,
+		"conn-types":  strings.Join(f.ConnTypes, ","),
+		"protected":   strings.Join(f.Safety.Protected, ","),
+
+		"drain-timeout":    f.Drain.Timeout,
+		"drain-on-timeout": f.Drain.OnTimeout,
+		"deploy-timeout":   f.DeployTimeout,
+		"python":           f.Airflow.Python,
+		"airflow-image":    f.Airflow.Image,
+
+		"lint-heavy-imports": strings.Join(f.Lint.HeavyImports, ","),
+		"history-dir":        f.HistoryDir,
+		"interval":           f.Loop.Interval,
+		"retry-backoff":      f.Loop.RetryBackoff,
+		"lock-ttl":           f.LockTTL,
+		"log-format":         f.Log.Format,
+		"log-level":          f.Log.Level,
+		"trace-exporter":     f.Tracing.Exporter,
+		"trace-endpoint":     f.Tracing.Endpoint,
+		"trace-file":         f.Tracing.File,
+		"junit-report":       f.CI.JUnitReport,
+	}
+	if len(f.Lint.Rules) > 0 {
+		rules := make([]string, 0, len(f.Lint.Rules))
+		for rule, level := range f.Lint.Rules {
+			rules = append(rules, rule+"="+level)
+		}
+		sort.Strings(rules)
+		v["lint-rules"] = strings.Join(rules, ",")
+	}
+	if f.RollbackOnImportError {
+		v["rollback-on-import-error"] = "true"
+	}
+	if f.ImportVariables {
+		v["import-variables"] = "true"
+	}
+	if f.ImportConnections {
+		v["import-connections"] = "true"
+	}
+	if f.Concurrency != 0 {
+		v["concurrency"] = strconv.Itoa(f.Concurrency)
+	}
+	if f.Safety.MaxStop != 0 {
+		v["max-stop"] = strconv.Itoa(f.Safety.MaxStop)
+	}
+	if f.SnapshotRetention != 0 {
+		v["snapshot-retention"] = strconv.Itoa(f.SnapshotRetention)
+	}
+	for k, val := range v {
+		if val == "" {
+			delete(v, k)
+		}
+	}
+	return v
+}
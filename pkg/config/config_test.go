@@ -0,0 +1,116 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	yamlFile, err := Load(filepath.Join("testdata", "dagger.yaml"))
+	if err != nil {
+		t.Fatalf("error loading yaml config: %s", err)
+	}
+	tomlFile, err := Load(filepath.Join("testdata", "dagger.toml"))
+	if err != nil {
+		t.Fatalf("error loading toml config: %s", err)
+	}
+	if !reflect.DeepEqual(yamlFile, tomlFile) {
+		t.Errorf("yaml and toml configs differ:\n%+v\n%+v", yamlFile, tomlFile)
+	}
+	if yamlFile.Safety.MaxStop != 10 || len(yamlFile.Safety.Protected) != 2 {
+		t.Errorf("safety settings not loaded: %+v", yamlFile.Safety)
+	}
+	if len(yamlFile.Notify) != 2 || yamlFile.Notify[1].PasswordEnv != "SMTP_PASSWORD" {
+		t.Errorf("notify sinks not loaded: %+v", yamlFile.Notify)
+	}
+
+	if _, err := Load(filepath.Join("testdata", "unknown_key.yaml")); err == nil {
+		t.Errorf("expected an error for unknown keys")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	fileName := filepath.Join("testdata", "dagger.yaml")
+	file, err := Load(fileName)
+	if err != nil {
+		t.Fatalf("error loading config: %s", err)
+	}
+	env := map[string]string{"DAGGER_NAME": "composer-env", "DAGGER_LOCATION": "us-central1"}
+	flags := map[string]string{"location": "asia-east1"}
+	lookup := func(m map[string]string) func(string) (string, bool) {
+		return func(k string) (string, bool) {
+			v, ok := m[k]
+			return v, ok
+		}
+	}
+	s, err := Resolve(file, fileName, lookup(env), lookup(flags))
+	if err != nil {
+		t.Fatalf("error resolving settings: %s", err)
+	}
+
+	want := []Value{
+		{Key: "project", Value: "analytics-dev", Source: fileName},
+		{Key: "location", Value: "asia-east1", Source: SourceFlag},
+		{Key: "name", Value: "composer-env", Source: SourceEnv},
+		{Key: "plugins", Value: "./plugins", Source: SourceDefault},
+		{Key: "max-stop", Value: "10", Source: fileName},
+	}
+	for _, w := range want {
+		if got := s.values[w.Key]; got != w {
+			t.Errorf("expected %+v, got %+v", w, got)
+		}
+	}
+	if got := s.List("protected"); !reflect.DeepEqual(got, []string{"billing_daily", "audit_export"}) {
+		t.Errorf("unexpected protected list: %v", got)
+	}
+	if got := s.Notify(); len(got) != 2 || got[0].On != "failure" {
+		t.Errorf("unexpected notify sinks: %+v", got)
+	}
+	if err := s.Require("project", "variables"); err == nil {
+		t.Errorf("expected missing variables to be reported")
+	}
+}
+
+func TestResolveEnvironment(t *testing.T) {
+	fileName := filepath.Join("testdata", "dagger.yaml")
+	file, err := Load(fileName)
+	if err != nil {
+		t.Fatalf("error loading config: %s", err)
+	}
+	none := func(string) (string, bool) { return "", false }
+	getenv := func(k string) (string, bool) {
+		if k == "DAGGER_ENV" {
+			return "prod", true
+		}
+		return "", false
+	}
+	s, err := Resolve(file, fileName, getenv, none)
+	if err != nil {
+		t.Fatalf("error resolving settings: %s", err)
+	}
+	envSource := fileName + " [prod]"
+	want := []Value{
+		{Key: "project", Value: "analytics-prod", Source: envSource},
+		{Key: "location", Value: "europe-west1", Source: fileName},
+		{Key: "list", Value: "./config/prod_running_dags.txt", Source: envSource},
+		{Key: "variable-overlays", Value: "./config/variables.prod.json", Source: envSource},
+		{Key: "connections", Value: "./config/connections.prod.json", Source: envSource},
+		{Key: "dags", Value: "./src/dags", Source: fileName},
+	}
+	for _, w := range want {
+		if got := s.values[w.Key]; got != w {
+			t.Errorf("expected %+v, got %+v", w, got)
+		}
+	}
+
+	flags := func(k string) (string, bool) {
+		if k == "env" {
+			return "qa", true
+		}
+		return "", false
+	}
+	if _, err := Resolve(file, fileName, none, flags); err == nil {
+		t.Errorf("expected an error for an undeclared environment")
+	}
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources a resolved setting can come from besides a config file, which is
+// reported by its path.
+const (
+	SourceDefault = "default"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Key describes a setting that can be given in the config file, as a DAGGER_*
+// environment variable or as a CLI flag of the same name.
+type Key struct {
+	Name    string
+	Default string
+	Usage   string
+}
+
+// EnvVar is the environment variable overriding the key.
+func (k Key) EnvVar() string {
+	return "DAGGER_" + strings.ToUpper(strings.ReplaceAll(k.Name, "-", "_"))
+}
+
+// Keys lists every setting in the order `config show` prints them.
+var Keys = []Key{
+	{Name: "env", Usage: "Named environment from the config file"},
+	{Name: "project", Usage: "GCP project name"},
+	{Name: "location", Usage: "GCP Composer location"},
+	{Name: "name", Usage: "Name of GCP Composer environment"},
+	{Name: "list", Default: "./config/running_dags.txt", Usage: "File with DAGs to run"},
+	{Name: "dags", Default: "./dags", Usage: "DAGs folder"},
+	{Name: "plugins", Default: "./plugins", Usage: "Airflow plugins"},
+	{Name: "data", Default: "./data", Usage: "Airflow data (ie: sql files)"},
+	{Name: "variables", Usage: "Airflow variables config file"},
+	{Name: "connections", Usage: "Airflow connections config file"},
+	{Name: "import-variables", Default: "false", Usage: "Import the variables file into Airflow during sync"},
+	{Name: "import-connections", Default: "false", Usage: "Import and test the connections file in Airflow during sync"},
+	{Name: "conn-types", Usage: "Comma separated connection types accepted in the connections file on top of the known ones, like those added by providers"},
+	{Name: "concurrency", Default: "0", Usage: "Maximum DAGs stopped or started in parallel (0 is unlimited)"},
+	{Name: "max-stop", Default: "0", Usage: "Refuse to sync when more DAGs would be stopped (0 disables)"},
+	{Name: "protected", Usage: "Comma separated DAG IDs that are never stopped"},
+	{Name: "drain-timeout", Default: "0s", Usage: "How long to wait for running tasks before replacing or deleting a DAG (0s disables)"},
+	{Name: "drain-on-timeout", Default: "fail", Usage: "What to do when a DAG doesn't drain in time: force, skip or fail"},
+	{Name: "deploy-timeout", Default: "10m", Usage: "How long to wait for a deployed DAG to be parsed before failing"},
+	{Name: "rollback-on-import-error", Default: "false", Usage: "Remove deployed DAG files that fail to import"},
+	{Name: "python", Usage: "Python interpreter with Airflow installed, used by validate to load the DAGs"},
+	{Name: "airflow-image", Usage: "Airflow container image used by validate to load the DAGs instead of a local interpreter"},
+	{Name: "lint-rules", Usage: "Comma separated rule=level overrides for lint, level is error, warning or off"},
+	{Name: "lint-heavy-imports", Usage: "Comma separated modules lint reports when imported at the top level of a DAG file"},
+	{Name: "history-dir", Usage: "Local folder to keep deploy snapshots in instead of the environment bucket"},
+	{Name: "snapshot-retention", Default: "30", Usage: "How many deploy snapshots to keep (0 keeps all)"},
+	{Name: "interval", Default: "1h", Usage: "How often sync --loop syncs"},
+	{Name: "retry-backoff", Default: "30s", Usage: "Delay before retrying a failed sync --loop cycle, doubled on every consecutive failure up to the interval"},
+	{Name: "lock-ttl", Default: "2m", Usage: "How long the environment lock outlives a sync that stopped renewing it"},
+	{Name: "log-format", Default: "text", Usage: "Log output format: text or json"},
+	{Name: "log-level", Default: "info", Usage: "Minimum log level: debug, info, warn or error"},
+	{Name: "trace-exporter", Default: "none", Usage: "Where to export the trace spans of syncs: none, otlp or file"},
+	{Name: "trace-endpoint", Usage: "OTLP/HTTP collector the otlp exporter sends spans to, e.g. http://localhost:4318"},
+	{Name: "trace-file", Usage: "File the file exporter appends spans to as JSON"},
+	{Name: "junit-report", Usage: "File to write a JUnit report of the DAGs a sync deployed to, e.g. for GitLab"},
+	{Name: "variable-overlays", Usage: "Comma separated variables files merged over --variables"},
+	{Name: "promote-to", Usage: "Environment `dagger promote` copies DAGs to"},
+}
+
+// Value is a resolved setting and where it came from.
+type Value struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// Settings are the resolved values of every key in Keys.
+type Settings struct {
+	values map[string]Value
+	notify []Notify
+}
+
+// Resolve layers defaults, the config file, the selected named environment,
+// environment variables and flags, later layers taking precedence. file may
+// be nil when there is no config file. getenv and flags return whether the
+// key was set.
+func Resolve(file *File, fileName string, getenv func(string) (string, bool), flags func(string) (string, bool)) (*Settings, error) {
+	s := &Settings{values: make(map[string]Value)}
+	var fileValues, envValues map[string]string
+	var envSource string
+	if file != nil {
+		fileValues = file.values()
+		s.notify = file.Notify
+	}
+
+	env, _ := getenv(Key{Name: "env"}.EnvVar())
+	if fv, ok := flags("env"); ok {
+		env = fv
+	}
+	if env != "" {
+		if file == nil {
+			return nil, fmt.Errorf("environment %q selected but there is no config file", env)
+		}
+		var err error
+		if envValues, err = file.envValues(env); err != nil {
+			return nil, err
+		}
+		envSource = fmt.Sprintf("%s [%s]", fileName, env)
+	}
+
+	for _, k := range Keys {
+		v := Value{Key: k.Name, Value: k.Default, Source: SourceDefault}
+		if fv, ok := fileValues[k.Name]; ok {
+			v.Value, v.Source = fv, fileName
+		}
+		if ev, ok := envValues[k.Name]; ok {
+			v.Value, v.Source = ev, envSource
+		}
+		if ev, ok := getenv(k.EnvVar()); ok {
+			v.Value, v.Source = ev, SourceEnv
+		}
+		if fv, ok := flags(k.Name); ok {
+			v.Value, v.Source = fv, SourceFlag
+		}
+		s.values[k.Name] = v
+	}
+	return s, nil
+}
+
+// Notify returns the notification sinks of the config file, which have no
+// environment variable or flag.
+func (s *Settings) Notify() []Notify {
+	return s.notify
+}
+
+// String returns the value of key.
+func (s *Settings) String(key string) string {
+	return s.values[key].Value
+}
+
+// Int returns the value of key parsed as an integer.
+func (s *Settings) Int(key string) (int, error) {
+	v := s.values[key]
+	if v.Value == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(v.Value)
+	if err != nil {
+		return 0, fmt.Errorf("%s from %s must be an integer, got %q", key, v.Source, v.Value)
+	}
+	return i, nil
+}
+
+// Bool returns the value of key parsed as a boolean.
+func (s *Settings) Bool(key string) (bool, error) {
+	v := s.values[key]
+	if v.Value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v.Value)
+	if err != nil {
+		return false, fmt.Errorf("%s from %s must be true or false, got %q", key, v.Source, v.Value)
+	}
+	return b, nil
+}
+
+// Duration returns the value of key parsed as a duration.
+func (s *Settings) Duration(key string) (time.Duration, error) {
+	v := s.values[key]
+	if v.Value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v.Value)
+	if err != nil {
+		return 0, fmt.Errorf("%s from %s must be a duration such as 10m, got %q", key, v.Source, v.Value)
+	}
+	return d, nil
+}
+
+// List returns the value of key split on commas.
+func (s *Settings) List(key string) []string {
+	var list []string
+	for _, item := range strings.Split(s.values[key].Value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Source returns where the value of key came from.
+func (s *Settings) Source(key string) string {
+	return s.values[key].Source
+}
+
+// All returns every resolved value in Keys order.
+func (s *Settings) All() []Value {
+	all := make([]Value, 0, len(Keys))
+	for _, k := range Keys {
+		all = append(all, s.values[k.Name])
+	}
+	return all
+}
+
+// Require returns an error naming every key in keys that has no value.
+func (s *Settings) Require(keys ...string) error {
+	var missing []string
+	for _, k := range keys {
+		if s.String(k) == "" {
+			missing = append(missing, fmt.Sprintf("%s (--%s or %s)", k, k, Key{Name: k}.EnvVar()))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required settings: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -8,8 +8,6 @@ import (
 	"gopkg.in/yaml.v2"
 	"io"
 	"io/ioutil"
-	"log"
-	"math/rand"
 	"net/url"
 	"os"
 	"os/exec"
@@ -24,6 +22,12 @@ import (
 	"github.com/bmatcuk/doublestar"
 	"github.com/inshur/dagger/internal"
 	"github.com/inshur/dagger/pkg/gcshasher"
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/inshur/dagger/pkg/metrics"
+	"github.com/inshur/dagger/pkg/store"
+	"github.com/inshur/dagger/pkg/tracing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/api/iterator"
 )
 
@@ -37,7 +41,64 @@ type ComposerEnv struct {
 	LocalPluginsDir string
 	LocalDataDir    string
 	VariablesFile   string
-	ConnectionsFile string
+	// VariablesOverlays are merged over VariablesFile, later files winning.
+	VariablesOverlays []string
+	ConnectionsFile   string
+	// ConnTypes are connection types accepted in ConnectionsFile on top of
+	// the known ones, like those added by providers.
+	ConnTypes []string
+	// RunningDagsFile is the running DAGs list, in txt or YAML format.
+	RunningDagsFile string
+	// Env is the named environment used to select running list entries.
+	Env string
+	// DagSpecs holds the per-DAG settings of the last running list read.
+	DagSpecs RunningList
+	// Runner overrides how Airflow CLI commands are run, gcloud is used when nil.
+	Runner Runner
+	// Concurrency limits parallel DAG stops and starts, 0 is unlimited.
+	Concurrency int
+	// MaxStop refuses syncs stopping more DAGs than this, 0 disables the check.
+	MaxStop int
+	// ProtectedDags are never stopped for being absent from the running list.
+	ProtectedDags map[string]bool
+	// DrainTimeout is how long a DAG is given to finish its running tasks
+	// before it is replaced or deleted, 0 disables draining.
+	DrainTimeout time.Duration
+	// DrainOnTimeout is DrainForce, DrainSkip or DrainFail (the default).
+	DrainOnTimeout string
+	// DrainPollInterval is how often running tasks are checked while draining.
+	DrainPollInterval time.Duration
+	// DeployTimeout is how long to wait for an uploaded DAG to be parsed.
+	DeployTimeout time.Duration
+	// DeployPollInterval is how often the scheduler is checked while waiting.
+	DeployPollInterval time.Duration
+	// RollbackOnImportError removes deployed files that fail to import.
+	RollbackOnImportError bool
+	// DeployID identifies the current deploy, set by Snapshot if empty.
+	DeployID string
+	// Provenance is stamped on every uploaded object.
+	Provenance Provenance
+	// Objects is the environment bucket, Cloud Storage by default.
+	Objects store.Store
+	// History keeps deploy snapshots, the environment bucket by default.
+	History store.Store
+	// SnapshotRetention is how many deploy snapshots are kept, all when
+	// zero.
+	SnapshotRetention int
+	// LockTTL is how long the environment lock lasts without renewal.
+	LockTTL time.Duration
+	// Context parents the trace spans of the environment's calls. Once it is
+	// cancelled, like when the environment lock is lost, Apply starts no
+	// more DAGs.
+	Context context.Context
+	// Log tags the environment's log lines, like with the run ID of a sync.
+	// Lines are logged without fields when it is nil.
+	Log *logrus.Entry
+}
+
+// Runner runs Airflow CLI sub commands against an environment.
+type Runner interface {
+	Run(subCmd string, args ...string) ([]byte, error)
 }
 
 // Dag is a type for dag containing it's path
@@ -46,17 +107,50 @@ type Dag struct {
 	Path string
 }
 
+// Connection is an Airflow connection as declared in the connections file.
+// Extra may be an inline JSON object or a string holding encoded JSON.
 type Connection struct {
-	Name     string                 `json:"name"`
-	Uri      string                 `json:"uri"`
-	Type     string                 `json:"type"`
-	Schema   string                 `json:"schema"`
-	Port     string                 `json:"port"`
-	Password string                 `json:"password"`
-	Login    string                 `json:"login"`
-	Host     string                 `json:"host"`
-	Extra    map[string]interface{} `json:"extra"`
-	Test     string                 `json:"test"`
+	Name     string          `json:"name"`
+	Uri      string          `json:"uri"`
+	Type     string          `json:"type"`
+	Schema   string          `json:"schema"`
+	Port     string          `json:"port"`
+	Password string          `json:"password"`
+	Login    string          `json:"login"`
+	Host     string          `json:"host"`
+	Extra    json.RawMessage `json:"extra"`
+	Test     string          `json:"test"`
+}
+
+// addArgs builds the `connections add` arguments for the connection. Airflow
+// rejects a URI combined with discrete fields, so only one form is passed.
+func (conn Connection) addArgs() ([]string, error) {
+	extra, err := extraObject(conn.Extra)
+	if err != nil {
+		return nil, fmt.Errorf("connection %s extra %v", conn.Name, err)
+	}
+	var args []string
+	if conn.Uri != "" {
+		args = append(args, "--conn-uri", conn.Uri)
+	} else {
+		flags := []struct{ name, value string }{
+			{"--conn-type", conn.Type},
+			{"--conn-host", conn.Host},
+			{"--conn-login", conn.Login},
+			{"--conn-password", conn.Password},
+			{"--conn-schema", conn.Schema},
+			{"--conn-port", conn.Port},
+		}
+		for _, f := range flags {
+			if f.value != "" {
+				args = append(args, f.name, f.value)
+			}
+		}
+	}
+	if extra != "" {
+		args = append(args, "--conn-extra", extra)
+	}
+	return append(args, conn.Name), nil
 }
 
 type Describe struct {
@@ -65,33 +159,32 @@ type Describe struct {
 	}
 }
 
-func logDagList(a map[string]bool) {
-	for k := range a {
-		log.Printf("=> \t%s", k)
-	}
-	return
+// logDagList logs msg with the sorted DAG IDs of a set.
+func logDagList(entry *logrus.Entry, msg string, a map[string]bool) {
+	entry.WithField("dag_ids", sortedKeys(a)).Info(msg)
 }
 
 // DagList is a set of dags (for quick membership check)
 type DagList map[string]bool
 
-// ReadRunningDagsTxt reads a newline separated list of dags from a text file
+// ReadRunningDagsTxt reads a newline separated list of dags from a text file,
+// ignoring blank lines and # comments
 func ReadRunningDagsTxt(filename string) (map[string]bool, error) {
-	file, err := os.Open(filename)
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	specs, errs := parseRunningDagsTxt(filename, data)
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
 	dagsToRun := make(map[string]bool)
-	sc := bufio.NewScanner(file)
-
-	for sc.Scan() {
-		dagsToRun[sc.Text()] = true
+	for _, spec := range specs {
+		dagsToRun[spec.ID] = true
 	}
-	log.Printf("reading DAGs to run from %s:", filename)
-	logDagList(dagsToRun)
-	return dagsToRun, err
+	logDagList(logging.Log.WithField("file", filename), "read DAGs to run", dagsToRun)
+	return dagsToRun, nil
 }
 
 // DagListIntersect finds the common keys in two map[string]bool representing a
@@ -126,38 +219,54 @@ func DagListDiff(a map[string]bool, b map[string]bool) map[string]bool {
 	return diff
 }
 
-func Upload(bucket, object, file string) error {
-	ctx := context.Background()
+// Upload copies a local file to an object, setting its custom metadata.
+func Upload(ctx context.Context, bucket, object, file string, metadata map[string]string) error {
 	client, err := storage.NewClient(ctx)
 	if err != nil {
 		return fmt.Errorf("storage.NewClient: %v", err)
 	}
 	defer client.Close()
-	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
-	defer cancel()
 
-	// Upload an object with storage.Writer.
 	f, err := os.Open(file)
 	if err != nil {
 		return fmt.Errorf("os.Open: %v", err)
 	}
-	wc := client.Bucket(bucket).Object(object).NewWriter(ctx)
-	if _, err = io.Copy(wc, f); err != nil {
-		return fmt.Errorf("io.Copy: %v", err)
-	}
-	if err := wc.Close(); err != nil {
-		return fmt.Errorf("Writer.Close: %v", err)
+	n, err := uploadFile(ctx, client, bucket, object, f, metadata)
+	if err != nil {
+		return err
 	}
 	if err = f.Close(); err != nil {
 		return fmt.Errorf("File.Close: %v", err)
 	}
-	fmt.Printf("%v uploaded.\n", object)
+	logging.FromContext(ctx).WithField(logging.Object, object).WithField("bytes", n).Info("uploaded")
 	return nil
 }
 
-// BulkUpload uploads files in bulk
-func BulkUpload(bucket, folder, rootPath string) error {
-	ctx := context.Background()
+// uploadFile writes r to an object with storage.Writer, tracing the upload
+// with the object and the bytes written.
+func uploadFile(ctx context.Context, client *storage.Client, bucket, object string, r io.Reader, metadata map[string]string) (n int64, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Upload", trace.WithAttributes(tracing.Object.String(object)))
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
+	defer cancel()
+
+	wc := client.Bucket(bucket).Object(object).NewWriter(ctx)
+	wc.Metadata = metadata
+	n, err = io.Copy(wc, r)
+	if err != nil {
+		return n, fmt.Errorf("io.Copy: %v", err)
+	}
+	if err := wc.Close(); err != nil {
+		return n, fmt.Errorf("Writer.Close: %v", err)
+	}
+	metrics.Uploaded(n)
+	span.SetAttributes(tracing.Bytes.Int64(n))
+	return n, nil
+}
+
+// BulkUpload uploads files in bulk, setting metadata on every object along
+// with the local path it was uploaded from.
+func BulkUpload(ctx context.Context, bucket, folder, rootPath string, metadata map[string]string) error {
 	client, err := storage.NewClient(ctx)
 	if err != nil {
 		return fmt.Errorf("storage.NewClient: %v", err)
@@ -174,8 +283,8 @@ func BulkUpload(bucket, folder, rootPath string) error {
 	for i := 0; i < len(fileList); i++ {
 		// Open and read local file
 		info, err := os.Stat(fileList[i])
-		if os.IsNotExist(err) {
-			log.Fatal("File does not exist.")
+		if err != nil {
+			return fmt.Errorf("os.Stat: %v", err)
 		}
 		if info.IsDir() {
 			continue
@@ -188,25 +297,19 @@ func BulkUpload(bucket, folder, rootPath string) error {
 				return fmt.Errorf("os.Open: %v", err)
 			}
 
-			ctx, cancel := context.WithTimeout(ctx, time.Second*50)
-			defer cancel()
-
 			// Upload an object with storage.Writer.
 			object := objPath[i]
 			if folder != "" {
 				object = fmt.Sprintf("%s/%s", folder, objPath[i])
 			}
-			wc := client.Bucket(bucket).Object(object).NewWriter(ctx)
-			if _, err = io.Copy(wc, f); err != nil {
-				return fmt.Errorf("io.Copy: %v", err)
-			}
-			if err := wc.Close(); err != nil {
-				return fmt.Errorf("Writer.Close: %v", err)
+			n, err := uploadFile(ctx, client, bucket, object, f, withLocalPath(metadata, fileList[i]))
+			if err != nil {
+				return err
 			}
 			if err = f.Close(); err != nil {
 				return fmt.Errorf("File.Close: %v", err)
 			}
-			fmt.Printf("%v uploaded.\n", objPath[i])
+			logging.FromContext(ctx).WithField(logging.Object, object).WithField("bytes", n).Info("uploaded")
 		}
 	}
 
@@ -253,13 +356,14 @@ func BulkDownload(bucket, folder, localDir string) error {
 		if err != nil {
 			return fmt.Errorf("error writing to file: %v", err)
 		}
-		fmt.Printf("%v downloaded.\n", objects[i])
+		logging.ForObject(objects[i]).Debug("downloaded")
 	}
 	return nil
 }
 
-func DeleteFile(bucket, object string) error {
-	ctx := context.Background()
+func DeleteFile(ctx context.Context, bucket, object string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Delete", trace.WithAttributes(tracing.Object.String(object)))
+	defer func() { tracing.End(span, err) }()
 	client, err := storage.NewClient(ctx)
 	if err != nil {
 		return fmt.Errorf("storage.NewClient: %v", err)
@@ -273,7 +377,28 @@ func DeleteFile(bucket, object string) error {
 	if err := o.Delete(ctx); err != nil {
 		return fmt.Errorf("Object(%q).Delete: %v", object, err)
 	}
-	fmt.Printf("%v deleted.\n", object)
+	logging.FromContext(ctx).WithField(logging.Object, object).Info("deleted")
+	return nil
+}
+
+// CopyFile copies an object between buckets without downloading it.
+func CopyFile(srcBucket, srcObject, dstBucket, dstObject string) error {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
+	defer cancel()
+
+	src := client.Bucket(srcBucket).Object(srcObject)
+	dst := client.Bucket(dstBucket).Object(dstObject)
+	if _, err := dst.CopierFrom(src).Run(ctx); err != nil {
+		return fmt.Errorf("Object(%q).CopierFrom(%q).Run: %v", dstObject, srcObject, err)
+	}
+	logging.ForObject(dstObject).WithField("source", fmt.Sprintf("gs://%v/%v", srcBucket, srcObject)).Info("copied")
 	return nil
 }
 
@@ -308,18 +433,23 @@ func ListFiles(bucket string, prefix string) ([]string, error) {
 	return objectPath, nil
 }
 
-func (c *ComposerEnv) Configure() error {
+func (c *ComposerEnv) Configure() (err error) {
+	defer metrics.Phase("configure", time.Now())
+	_, span := tracing.Tracer().Start(c.traceContext(), "Configure")
+	defer func() { tracing.End(span, err) }()
 	subCmdArgs := []string{
 		"composer", "environments", "describe",
 		c.Name,
 		fmt.Sprintf("--location=%s", c.Location),
 	}
-	log.Printf("running gcloud %s", strings.Join(subCmdArgs, " "))
+	c.phaseLog("configure").Debugf("running gcloud %s", strings.Join(subCmdArgs, " "))
 	cmd := exec.Command(
 		"gcloud", subCmdArgs...)
 
 	var config Describe
+	start := time.Now()
 	data, err := cmd.CombinedOutput()
+	metrics.Call("composer environments describe", start, err)
 	if err != nil {
 		return err
 	}
@@ -328,78 +458,134 @@ func (c *ComposerEnv) Configure() error {
 	return nil
 }
 
-func (c *ComposerEnv) SyncPlugins() error {
+func (c *ComposerEnv) SyncPlugins() (err error) {
 	bucket := strings.TrimSuffix(strings.TrimPrefix(c.DagBucketPrefix, "gs://"), "/dags")
-	log.Printf("syncing plugins from %s\n", c.LocalPluginsDir)
-	err := BulkUpload(bucket, "plugins", c.LocalPluginsDir)
+	defer metrics.Phase("plugins", time.Now())
+	traced, span := c.span("SyncPlugins")
+	defer func() { tracing.End(span, err) }()
+	c.phaseLog("plugins").Infof("syncing plugins from %s", c.LocalPluginsDir)
+	err = BulkUpload(traced.logContext(), bucket, "plugins", c.LocalPluginsDir, c.provenanceMetadata())
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *ComposerEnv) SyncData() error {
+func (c *ComposerEnv) SyncData() (err error) {
 	bucket := strings.TrimSuffix(strings.TrimPrefix(c.DagBucketPrefix, "gs://"), "/dags")
-	log.Printf("syncing data from %s\n", c.LocalDataDir)
-	err := BulkUpload(bucket, "data", c.LocalDataDir)
+	defer metrics.Phase("data", time.Now())
+	traced, span := c.span("SyncData")
+	defer func() { tracing.End(span, err) }()
+	c.phaseLog("data").Infof("syncing data from %s", c.LocalDataDir)
+	err = BulkUpload(traced.logContext(), bucket, "data", c.LocalDataDir, c.provenanceMetadata())
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// ImportVariables imports the variables file merged with any overlays.
 func (c *ComposerEnv) ImportVariables() error {
-	if c.VariablesFile != "" {
-		out, err := c.Run("variables", "import", c.VariablesFile)
+	if c.VariablesFile == "" && len(c.VariablesOverlays) == 0 {
+		return nil
+	}
+	files := c.variablesFiles()
+	for _, f := range files {
+		if err := ValidateVariablesFile(f); err != nil {
+			return err
+		}
+	}
+	variablesFile := files[0]
+	if len(files) > 1 {
+		merged, err := ioutil.TempFile("", "variables_*.json")
 		if err != nil {
-			log.Fatalf("Variables import failed: %s with %s", err, out)
+			return fmt.Errorf("error creating merged variables file: %v", err)
 		}
-		log.Printf("Imported variables: %s", c.VariablesFile)
-		log.Printf("Output: \n%s", out)
-		return err
+		merged.Close()
+		defer os.Remove(merged.Name())
+		if err := MergeVariablesFiles(merged.Name(), files...); err != nil {
+			return err
+		}
+		variablesFile = merged.Name()
+	}
+	out, err := c.Run("variables", "import", variablesFile)
+	if err != nil {
+		return fmt.Errorf("variables import failed: %s with %s", err, out)
 	}
+	c.phaseLog("variables").WithField("output", string(out)).Infof("imported variables: %s", strings.Join(files, ", "))
 	return nil
 }
 
-func (c *ComposerEnv) ImportConnections() error {
-	if c.ConnectionsFile != "" {
-		file, _ := ioutil.ReadFile(c.ConnectionsFile)
-		var connections []Connection
-		_ = json.Unmarshal(file, &connections)
-
-		for i := 0; i < len(connections); i++ {
-			out, err := c.Run("connections", "delete",
-				connections[i].Name,
-			)
-			if err != nil {
-				log.Fatalf("Connections delete failed: %s with %s", err, out)
-			}
-			extra, err := json.Marshal(connections[i].Extra)
-			if err != nil {
-				log.Fatalf("Connections json marshal failed: %s", err)
-			}
+// variablesFiles lists the variables file followed by its overlays.
+func (c *ComposerEnv) variablesFiles() []string {
+	var files []string
+	if c.VariablesFile != "" {
+		files = append(files, c.VariablesFile)
+	}
+	return append(files, c.VariablesOverlays...)
+}
 
-			out, err = c.Run("connections", "add",
-				"--conn-uri", connections[i].Uri,
-				"--conn-type", connections[i].Type,
-				"--conn-schema", connections[i].Schema,
-				"--conn-port", connections[i].Port,
-				"--conn-password", connections[i].Password,
-				"--conn-login", connections[i].Login,
-				"--conn-host", connections[i].Host,
-				"--conn-extra", string(extra),
-				connections[i].Name,
-			)
-			if err != nil {
-				log.Fatalf("Connections import failed: %s with %s", err, out)
-			}
-			log.Printf("Imported variables: %s", c.ConnectionsFile)
-			log.Printf("Output: \n%s", out)
-			return err
+// MergeVariablesFiles writes the union of the variables in files to out,
+// values in later files replacing those in earlier ones.
+func MergeVariablesFiles(out string, files ...string) error {
+	merged := make(map[string]json.RawMessage)
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("couldn't read variables file %s: %v", f, err)
+		}
+		var vars map[string]json.RawMessage
+		if err := json.Unmarshal(data, &vars); err != nil {
+			return fmt.Errorf("couldn't decode variables file %s: %v", f, err)
+		}
+		for k, v := range vars {
+			merged[k] = v
 		}
+	}
+	data, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(out, data, 0644)
+}
 
+// ImportConnections recreates every connection in the connections file and
+// then tests each one according to its Test field. The file is validated
+// first so a malformed file fails instead of importing nothing. The returned
+// error is a ConnectionTestError when only required connection tests failed.
+func (c *ComposerEnv) ImportConnections() ([]ConnectionTestResult, error) {
+	if c.ConnectionsFile == "" {
+		return nil, nil
 	}
-	return nil
+	if err := ValidateConnectionsFile(c.ConnectionsFile, c.ConnTypes...); err != nil {
+		return nil, err
+	}
+	file, err := ioutil.ReadFile(c.ConnectionsFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read connections file %s: %v", c.ConnectionsFile, err)
+	}
+	var connections []Connection
+	if err := json.Unmarshal(file, &connections); err != nil {
+		return nil, fmt.Errorf("couldn't decode connections file %s: %v", c.ConnectionsFile, err)
+	}
+
+	for i := 0; i < len(connections); i++ {
+		args, err := connections[i].addArgs()
+		if err != nil {
+			return nil, err
+		}
+		out, err := c.Run("connections", "delete", connections[i].Name)
+		if err != nil {
+			c.phaseLog("connections").WithField("output", string(out)).Warnf("connections delete %s failed (may not exist yet): %s", connections[i].Name, err)
+		}
+		out, err = c.Run("connections", append([]string{"add"}, args...)...)
+		if err != nil {
+			return nil, fmt.Errorf("connections import of %s failed: %s with %s", connections[i].Name, err, out)
+		}
+		c.phaseLog("connections").WithField("output", string(out)).Infof("imported connection: %s", connections[i].Name)
+	}
+	c.phaseLog("connections").Infof("imported connections: %s", c.ConnectionsFile)
+	return c.TestConnections(connections)
 }
 
 func (c *ComposerEnv) assembleComposerRunCmd(subCmd string, args ...string) []string {
@@ -417,19 +603,29 @@ func (c *ComposerEnv) assembleComposerRunCmd(subCmd string, args ...string) []st
 }
 
 // Run is used to run airflow cli commands
-// it is a wrapper of gcloud composer environments run
-func (c *ComposerEnv) Run(subCmd string, args ...string) ([]byte, error) {
+// it is a wrapper of gcloud composer environments run unless a Runner is set
+func (c *ComposerEnv) Run(subCmd string, args ...string) (out []byte, err error) {
+	// the span only names the subcommand, as the arguments may hold
+	// connection secrets
+	subcommand := metrics.Subcommand(subCmd, args...)
+	_, span := tracing.Tracer().Start(c.traceContext(), subcommand, trace.WithAttributes(tracing.Command.String(subcommand)))
+	defer func(start time.Time) {
+		metrics.Call(subcommand, start, err)
+		tracing.End(span, err)
+	}(time.Now())
+	if c.Runner != nil {
+		return c.Runner.Run(subCmd, args...)
+	}
 	subCmdArgs := c.assembleComposerRunCmd(subCmd, args...)
-	log.Printf("running gcloud %s", strings.Join(subCmdArgs, " "))
+	c.log().Debugf("running gcloud %s", strings.Join(subCmdArgs, " "))
 	cmd := exec.Command(
 		"gcloud", subCmdArgs...)
 	return cmd.CombinedOutput()
 }
 
-func parseListDagsOuput(out []byte) map[string]bool {
+func parseListDagsOuput(out []byte) (map[string]bool, error) {
 	runningDags := make(map[string]bool)
 	outArr := strings.Split(string(out[:]), "\n")
-	fmt.Println(outArr)
 
 	// Find the DAGs in output
 	dagSep := "="
@@ -442,37 +638,78 @@ func parseListDagsOuput(out []byte) map[string]bool {
 		}
 		dagsIdx++
 		if dagsIdx >= len(outArr) {
-			log.Fatalf("dags list output did not contain expected separators: %s", out)
+			return nil, fmt.Errorf("dags list output did not contain expected separators: %s", out)
 		}
 	}
 
 	// Ignore empty newline and airflow_monitoring dag.
 	for _, dag := range outArr[dagsIdx:] {
-		dag = strings.Split(dag, "|")[0]
+		dag = strings.TrimSpace(strings.Split(dag, "|")[0])
 		if dag != "" && dag != "airflow_monitoring" {
 			runningDags[dag] = true
 		}
 	}
 
-	return runningDags
+	return runningDags, nil
+}
+
+// parseDagPauseStates reads the paused column of `dags list` output. DAGs are
+// missing from the result when the output has no paused column.
+func parseDagPauseStates(out []byte) map[string]bool {
+	paused := make(map[string]bool)
+	lines := strings.Split(string(out), "\n")
+	for i := 1; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "=") {
+			continue
+		}
+		col := -1
+		for j, h := range strings.Split(lines[i-1], "|") {
+			if h = strings.TrimSpace(h); h == "paused" || h == "is_paused" {
+				col = j
+			}
+		}
+		if col < 0 {
+			return paused
+		}
+		for _, row := range lines[i+1:] {
+			cells := strings.Split(row, "|")
+			if len(cells) <= col {
+				continue
+			}
+			dag := strings.TrimSpace(cells[0])
+			if dag != "" && dag != "airflow_monitoring" {
+				paused[dag] = strings.EqualFold(strings.TrimSpace(cells[col]), "true")
+			}
+		}
+		break
+	}
+	return paused
 }
 
 // GetRunningDags lists dags currently running in Composer Environment.
 func (c *ComposerEnv) GetRunningDags() (map[string]bool, error) {
-	runningDags := make(map[string]bool)
+	runningDags, _, err := c.GetDeployedDags()
+	return runningDags, err
+}
+
+// GetDeployedDags lists dags currently running in Composer Environment along
+// with their pause state, as far as `dags list` reports it.
+func (c *ComposerEnv) GetDeployedDags() (map[string]bool, map[string]bool, error) {
 	out, err := c.Run("dags", "list")
 	if err != nil {
-		log.Fatalf("list_dags failed: %s with %s", err, out)
+		return nil, nil, fmt.Errorf("list_dags failed: %s with %s", err, out)
 	}
 
-	runningDags = parseListDagsOuput(out)
-	log.Printf("running DAGs:")
-	logDagList(runningDags)
-	return runningDags, err
+	runningDags, err := parseListDagsOuput(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	logDagList(c.log(), "running DAGs", runningDags)
+	return runningDags, parseDagPauseStates(out), nil
 }
 
 func readCommentScrubbedLines(path string) ([]string, error) {
-	log.Printf("scrubbing comments in %v", path)
+	logging.Log.Debugf("scrubbing comments in %v", path)
 	commentPattern, err := regexp.Compile(`#.+`)
 	if err != nil {
 		return nil, fmt.Errorf("error compiling regex: %v", err)
@@ -501,8 +738,7 @@ func FindDagFilesInLocalTree(dagsRoot string, dagNames map[string]bool) (map[str
 	if len(dagNames) == 0 {
 		return make(map[string][]string), nil
 	}
-	log.Printf("searching for these DAGs in %v:", dagsRoot)
-	logDagList(dagNames)
+	logDagList(logging.Log.WithField("dir", dagsRoot), "searching for DAGs", dagNames)
 	matches := make(map[string][]string)
 	// This should map a dir to the ignore patterns in it's airflow ignore if relevant
 	// this allows us to easily identify the patterns relevant to this dir and it's parents, grandparents, etc.
@@ -521,7 +757,7 @@ func FindDagFilesInLocalTree(dagsRoot string, dagNames map[string]bool) (map[str
 		}
 		// resepect .airflowignore
 		if info.Name() == ".airflowignore" {
-			log.Printf("found %v, adding to airflowignoreTree", path)
+			logging.Log.Debugf("found %v, adding to airflowignoreTree", path)
 			patterns, err := readCommentScrubbedLines(path)
 			if err != nil {
 				return err
@@ -534,7 +770,7 @@ func FindDagFilesInLocalTree(dagsRoot string, dagNames map[string]bool) (map[str
 			for _, p := range patterns {
 				fullyQualifiedPatterns = append(fullyQualifiedPatterns, filepath.Join(dir, p))
 			}
-			log.Printf("adding the following patterns to airflowignoreTree[%v]: %+v", dir, fullyQualifiedPatterns)
+			logging.Log.Debugf("adding the following patterns to airflowignoreTree[%v]: %+v", dir, fullyQualifiedPatterns)
 			airflowignoreTree[filepath.Dir(path)] = fullyQualifiedPatterns
 			return nil
 		}
@@ -566,7 +802,6 @@ func FindDagFilesInLocalTree(dagsRoot string, dagNames map[string]bool) (map[str
 
 		thisMatch := make(map[string]bool)
 		if err != nil {
-			log.Printf("error making %v relative to %v, %v", path, dagsRoot, err)
 			return fmt.Errorf("error making %v relative to %v, %v", path, dagsRoot, err)
 		}
 
@@ -589,20 +824,19 @@ func FindDagFilesInLocalTree(dagsRoot string, dagNames map[string]bool) (map[str
 			if !match && !strings.Contains(ignore, "**") {
 				match, err = regexp.MatchString(ignore, relPath)
 				if err != nil {
-					log.Printf("ERROR: comparing %v %v: %v", relPath, ignore, err)
 					return err
 				}
 			}
 
 			// don't walk dirs we don't have to
 			if match && info.IsDir() {
-				log.Printf("ignoring dir: %v because matched %v", relPath, ignore)
+				logging.Log.Debugf("ignoring dir: %v because matched %v", relPath, ignore)
 				return filepath.SkipDir
 			}
 
 			// remove matches if previously added but now matches this ignore pattern
 			if match && !info.IsDir() && dagNames[dagID] {
-				log.Printf("ignoring path: %v because matched %v", relPath, ignore)
+				logging.Log.Debugf("ignoring path: %v because matched %v", relPath, ignore)
 				if _, ok := matches[dagID]; ok {
 					matches[dagID] = make([]string, 0)
 					break // no other ignore patterns relevant if we now know this file should be ignored
@@ -658,7 +892,7 @@ func FindDagFilesInGcsPrefix(prefix string, dagFileNames map[string]bool) (map[s
 	defer os.RemoveAll(dir) // clean up temp dir
 
 	// copy gcs dags dir to local temp dir
-	log.Printf("pulling down %v", prefix)
+	logging.InPhase("plan").Debugf("pulling down %v", prefix)
 	err = BulkDownload(bucket, "dags/", dir)
 	if err != nil {
 		return nil, err
@@ -666,167 +900,166 @@ func FindDagFilesInGcsPrefix(prefix string, dagFileNames map[string]bool) (map[s
 	return FindDagFilesInLocalTree(filepath.Join(dir, "dags"), dagFileNames)
 }
 
-func (c *ComposerEnv) getRestartDags(sameDags map[string]string) map[string]bool {
-	dagsToRestart := make(map[string]bool)
+// getRestartDags compares the deployed files of sameDags with the local ones
+// and maps the DAGs whose file changed or moved to their deployed path. A
+// comparison that fails fails the plan rather than restarting the DAG.
+func (c *ComposerEnv) getRestartDags(sameDags map[string]string) (map[string]string, error) {
+	dagsToRestart := make(map[string]string)
 	for dag, relPath := range sameDags {
-		// We know that the file name = dag id from the dag validation test asseting this.
+		// We know that the file name = dag id, `dagger validate` checks it with a DagBag load.
 		local := filepath.Join(c.LocalDagsDir, relPath)
+		if _, err := os.Stat(local); os.IsNotExist(err) {
+			dagsToRestart[dag] = relPath
+			continue
+		}
 		gcs, err := url.Parse(c.DagBucketPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing dag bucket prefix: %v", err)
+		}
 		gcs.Path = path.Join(gcs.Path, relPath)
 		eq, err := gcshasher.LocalFileEqGCS(local, gcs.String())
 		if err != nil {
-			log.Printf("error comparing file hashes %s, attempting to restart: %s", err, dag)
-			dagsToRestart[dag] = true
-		} else if !eq {
-			dagsToRestart[dag] = true
+			return nil, fmt.Errorf("error comparing %v with %v: %v", local, gcs.String(), err)
+		}
+		if !eq {
+			dagsToRestart[dag] = relPath
 		}
 	}
-	return dagsToRestart
+	return dagsToRestart, nil
 }
 
 // GetStopAndStartDags uses set differences between dags running in the Composer
-// Environment and those in the running dags text config file.
+// Environment and those in the running dags config file.
 func (c *ComposerEnv) GetStopAndStartDags(filename string) (map[string]string, map[string]string) {
-	dagsToRun, err := ReadRunningDagsTxt(filename)
-	if err != nil {
-		log.Fatalf("couldn't read running_dags.txt: %v", filename)
-	}
-	runningDags, err := c.GetRunningDags()
-	if err != nil {
-		log.Fatalf("couldn't list dags in composer environment: %v", err)
-	}
-	dagsToStop := DagListDiff(runningDags, dagsToRun)
-	dagsToStart := DagListDiff(dagsToRun, runningDags)
-	dagsSame := DagListIntersect(runningDags, dagsToRun)
-	log.Printf("DAGs same:")
-	logDagList(dagsSame)
-
-	dagPathListsSame, err := FindDagFilesInGcsPrefix(c.DagBucketPrefix, dagsToStop)
+	plan, err := c.Plan(filename)
 	if err != nil {
-		log.Fatalf("error finding dags to stop: %v", err)
-	}
-	// unnest out of slice
-	dagPathsSame := make(map[string]string)
-	for k, v := range dagPathListsSame {
-		dagPathsSame[k] = v[0]
-	}
-	restartDags := c.getRestartDags(dagPathsSame)
-
-	for k, v := range restartDags {
-		dagsToStop[k], dagsToStart[k] = v, v
+		c.log().Fatalf("error planning sync: %v", err)
 	}
+	return plan.Stop, plan.Start
+}
 
-	log.Printf("DAGs to Stop:")
-	logDagList(dagsToStop)
-	log.Printf("DAGs to Start:")
-	logDagList(dagsToStart)
+func (c *ComposerEnv) pauseDag(dag string) ([]byte, error) {
+	return c.Run("dags", "pause", dag)
+}
 
-	dagPathListsToStop, err := FindDagFilesInGcsPrefix(c.DagBucketPrefix, dagsToStop)
-	if err != nil {
-		log.Fatalf("error finding dags to stop: %v", err)
-	}
-	dagPathsToStop := make(map[string]string)
-	for k, v := range dagPathListsToStop {
-		dagPathsToStop[k] = v[0]
-	}
-	dagPathListsToStart, err := FindDagFilesInLocalTree(c.LocalDagsDir, dagsToStart)
-	if err != nil {
-		log.Fatalf("error finding dags to start: %v", err)
-	}
+func (c *ComposerEnv) unpauseDag(dag string) ([]byte, error) {
+	return c.Run("dags", "unpause", dag)
+}
 
-	dagPathsToStart := make(map[string]string)
-	for k, v := range dagPathListsToStart {
-		dagPathsToStart[k] = v[0]
-	}
-	return dagPathsToStop, dagPathsToStart
+func (c *ComposerEnv) deleteDag(dag string) ([]byte, error) {
+	return c.Run("dags", "delete", "--yes", dag)
 }
 
-// ComposerEnv.stopDag pauses the dag, removes the dag definition file from gcs
-// and deletes the DAG from the airflow db.
-func (c *ComposerEnv) stopDag(dag string, relPath string, wg *sync.WaitGroup) (err error) {
+// ComposerEnv.stopDag pauses the dag, optionally waits for its running tasks
+// to drain, removes the dag definition file from gcs and deletes the DAG from
+// the airflow db.
+func (c *ComposerEnv) stopDag(dag string, relPath string) (err error) {
 	bucket := strings.TrimSuffix(strings.TrimPrefix(c.DagBucketPrefix, "gs://"), "/dags")
-	defer wg.Done()
-	log.Printf("pausing dag: %v with relPath: %v", dag, relPath)
-	out, err := c.Run("pause", dag)
+	c, span := c.span("stopDag", tracing.DagID.String(dag), tracing.Object.String("dags/"+relPath))
+	defer func() { tracing.End(span, err) }()
+	dagLog := c.dagLog(dag).WithField(logging.Phase, "stop")
+	dagLog.WithField(logging.Object, "dags/"+relPath).Info("pausing dag")
+	out, err := c.pauseDag(dag)
 	if err != nil {
 		return fmt.Errorf("error pausing dag %v: %v", dag, string(out))
 	}
-	log.Printf("parsing gcs url %v", c.DagBucketPrefix)
+	if err := c.drainDag(dag); err != nil {
+		return err
+	}
+	dagLog.Debugf("parsing gcs url %v", c.DagBucketPrefix)
 	gcs, err := url.Parse(c.DagBucketPrefix)
 	if err != nil {
-		panic("error parsing dag bucket prefix")
+		return fmt.Errorf("error parsing dag bucket prefix: %v", err)
 	}
 
 	gcs.Path = path.Join(gcs.Path, relPath)
-	log.Printf("deleting %v", gcs.String())
-	err = DeleteFile(bucket, fmt.Sprintf("dags/%s", relPath))
-	if err != nil {
-		panic("error deleting from gcs")
-	}
-
-	_, err = c.Run("delete_dag", dag)
+	dagLog.WithField(logging.Object, "dags/"+relPath).Info("deleting dag file")
+	err = DeleteFile(c.logContext(), bucket, fmt.Sprintf("dags/%s", relPath))
 	if err != nil {
-		panic("error deleteing dag")
+		return fmt.Errorf("error deleting %v from gcs: %v", gcs.String(), err)
 	}
 
-	for i := 0; i < 5; i++ {
+	out, err = c.deleteDag(dag)
+	retries := 0
+	for ; retries < 5; retries++ {
 		if err == nil {
 			break
 		}
-		log.Printf("Waiting 5s to retry")
+		dagLog.Warn("deleting dag failed, retrying in 5s")
 		dur, _ := time.ParseDuration("5s")
 		time.Sleep(dur)
-		log.Printf("Retrying delete %s", dag)
-		_, err = c.Run("delete_dag", dag)
+		out, err = c.deleteDag(dag)
 	}
+	span.SetAttributes(tracing.Retries.Int(retries))
 	if err != nil {
-		return fmt.Errorf("Retried 5x, pause still failing with: %v", string(out))
+		return fmt.Errorf("Retried 5x, delete still failing with: %v", string(out))
 	}
 	return err
 }
 
-// StopDags deletes a list of dags in parallel go routines
-func (c *ComposerEnv) StopDags(dagsToStop map[string]string) error {
-	var stopWg sync.WaitGroup
-	for k, v := range dagsToStop {
-		stopWg.Add(1)
-		go c.stopDag(k, v, &stopWg)
+// ApplySafety drops protected DAGs from dagsToStop unless they are being
+// started again, then refuses the sync if more than MaxStop DAGs remain.
+// Restarted DAGs are replaced in place and never stopped.
+func (c *ComposerEnv) ApplySafety(dagsToStop, dagsToStart map[string]string) error {
+	for dag := range dagsToStop {
+		if _, restart := dagsToStart[dag]; c.ProtectedDags[dag] && !restart {
+			c.dagLog(dag).Info("not stopping protected dag")
+			delete(dagsToStop, dag)
+		}
+	}
+	if c.MaxStop > 0 && len(dagsToStop) > c.MaxStop {
+		return fmt.Errorf("refusing to stop %d DAGs, max-stop is %d", len(dagsToStop), c.MaxStop)
 	}
-	stopWg.Wait()
 	return nil
 }
 
-func jitter(d time.Duration) time.Duration {
-	const pct = 0.10 //Jitter up to 10% of the supplied duration.
-	jit := 1 + pct*(rand.Float64()*2-1)
-	return time.Duration(jit * float64(d))
+// limiter returns a semaphore bounding parallel work to Concurrency, or nil
+// when unlimited.
+func (c *ComposerEnv) limiter() chan struct{} {
+	if c.Concurrency <= 0 {
+		return nil
+	}
+	return make(chan struct{}, c.Concurrency)
 }
 
-// ComposerEnv.waitForDeploy polls a Composer environment trying to unpause
-// dags. This should be called after copying a dag file to gcs when
-// dag_paused_on_creation=True.
-func (c *ComposerEnv) waitForDeploy(dag string) error {
-	_, err := c.Run("unpause", dag)
-	for i := 0; i < 5; i++ {
-		if err == nil {
-			break
+// StopDags deletes a list of dags in parallel go routines, returning the
+// error of every dag that failed or was skipped.
+func (c *ComposerEnv) StopDags(dagsToStop map[string]string) map[string]error {
+	c, span := c.span("StopDags")
+	defer span.End()
+	var stopWg sync.WaitGroup
+	var mu sync.Mutex
+	errs := make(map[string]error)
+	sem := c.limiter()
+	for k, v := range dagsToStop {
+		stopWg.Add(1)
+		if sem != nil {
+			sem <- struct{}{}
 		}
-		log.Printf("Waiting 60s to retry")
-		time.Sleep(jitter(time.Minute))
-		log.Printf("Retrying unpause %s", dag)
-		_, err = c.Run("unpause", dag)
-	}
-	if err != nil {
-		err = fmt.Errorf("Retried 5x, unpause still failing with: %s", err)
+		go func(dag, relPath string) {
+			// done only once the error is recorded
+			defer stopWg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			if err := c.stopDag(dag, relPath); err != nil {
+				c.dagLog(dag).WithField(logging.Phase, "stop").WithError(err).Error("error stopping dag")
+				mu.Lock()
+				errs[dag] = err
+				mu.Unlock()
+			}
+		}(k, v)
 	}
-	return err
+	stopWg.Wait()
+	return errs
 }
 
-// ComposerEnv.startDag copies a DAG definition file to GCS and waits until you can
-// successfully unpause.
-func (c *ComposerEnv) startDag(dagsFolder string, dag string, relPath string, wg *sync.WaitGroup) error {
+// ComposerEnv.startDag copies a DAG definition file to GCS and waits until the
+// scheduler has parsed it before unpausing.
+func (c *ComposerEnv) startDag(dagsFolder string, dag string, relPath string) (err error) {
 	bucket := strings.TrimSuffix(strings.TrimPrefix(c.DagBucketPrefix, "gs://"), "/dags")
-	defer wg.Done()
+	c, span := c.span("startDag", tracing.DagID.String(dag), tracing.Object.String("dags/"+relPath))
+	defer func() { tracing.End(span, err) }()
 	loc := filepath.Join(dagsFolder, relPath)
 	gcs, err := url.Parse(c.DagBucketPrefix)
 	if err != nil {
@@ -834,30 +1067,164 @@ func (c *ComposerEnv) startDag(dagsFolder string, dag string, relPath string, wg
 	}
 	gcs.Path = path.Join(gcs.Path, relPath)
 	// remove DAG first before uploading it
-	err = DeleteFile(bucket, fmt.Sprintf("dags/%s", relPath))
+	err = DeleteFile(c.logContext(), bucket, fmt.Sprintf("dags/%s", relPath))
 	if err != nil {
-		fmt.Printf("Cant delete dags/%s\n", relPath)
+		c.dagLog(dag).WithField(logging.Object, "dags/"+relPath).WithError(err).Warn("couldn't delete dag file before uploading it")
 	}
-	err = Upload(bucket, fmt.Sprintf("dags/%s", relPath), loc)
+	uploadedAt := time.Now()
+	err = Upload(c.logContext(), bucket, fmt.Sprintf("dags/%s", relPath), loc, withLocalPath(c.provenanceMetadata(), loc))
 	if err != nil {
 		return fmt.Errorf("error copying file %v to gcs: %v", loc, err)
 	}
-	c.waitForDeploy(dag)
+	spec := c.DagSpecs[dag]
+	if spec.State == DagPaused {
+		return c.waitForPaused(dag, relPath, uploadedAt)
+	}
+	if err := c.waitForDeploy(dag, relPath, uploadedAt); err != nil {
+		return err
+	}
+	if spec.Trigger {
+		c.dagLog(dag).Info("triggering dag")
+		out, err := c.Run("dags", "trigger", dag)
+		if err != nil {
+			return fmt.Errorf("error triggering dag %v: %s", dag, out)
+		}
+	}
 	return err
 }
 
+// ComposerEnv.restartDag replaces the deployed file of a changed DAG in place
+// so the DAG keeps its history: it is paused and drained, its file
+// overwritten and, once the new file is parsed, unpaused unless the running
+// list keeps it paused. previous is the path of the deployed file, removed
+// once replaced when the DAG moved. The overwritten file is returned so it
+// can be restored if the new one fails to import.
+func (c *ComposerEnv) restartDag(dagsFolder, dag, relPath, previous string) (replaced *replacedFile, err error) {
+	c, span := c.span("restartDag", tracing.DagID.String(dag), tracing.Object.String("dags/"+relPath))
+	defer func() { tracing.End(span, err) }()
+	dagLog := c.dagLog(dag).WithField(logging.Phase, "restart")
+	dagLog.WithField(logging.Object, "dags/"+previous).Info("pausing dag")
+	out, err := c.pauseDag(dag)
+	if err != nil {
+		return nil, fmt.Errorf("error pausing dag %v: %s", dag, out)
+	}
+	if err := c.drainDag(dag); err != nil {
+		return nil, err
+	}
+	loc := filepath.Join(dagsFolder, relPath)
+	data, err := ioutil.ReadFile(loc)
+	if err != nil {
+		return nil, err
+	}
+	if replaced, err = c.keepDeployedFile("dags/" + previous); err != nil {
+		return nil, err
+	}
+	uploadedAt := time.Now()
+	dagLog.WithField(logging.Object, "dags/"+relPath).Info("replacing dag file")
+	if err := c.objects().Write("dags/"+relPath, data, withLocalPath(c.provenanceMetadata(), loc)); err != nil {
+		return nil, fmt.Errorf("error copying file %v to gcs: %v", loc, err)
+	}
+	if previous != relPath {
+		dagLog.WithField(logging.Object, "dags/"+previous).Info("deleting moved dag file")
+		if err := c.objects().Delete("dags/" + previous); err != nil {
+			return replaced, fmt.Errorf("error deleting moved file %v: %v", previous, err)
+		}
+	}
+	spec := c.DagSpecs[dag]
+	if spec.State == DagPaused {
+		// still wait for the parse, so an import error rolls the file back
+		if err := c.waitForParse(dag, relPath, uploadedAt); err != nil {
+			return replaced, err
+		}
+		dagLog.Info("dag parsed, leaving it paused")
+		return replaced, nil
+	}
+	if err := c.waitForDeploy(dag, relPath, uploadedAt); err != nil {
+		return replaced, err
+	}
+	if spec.Trigger {
+		dagLog.Info("triggering dag")
+		out, err := c.Run("dags", "trigger", dag)
+		if err != nil {
+			return replaced, fmt.Errorf("error triggering dag %v: %s", dag, out)
+		}
+	}
+	return replaced, nil
+}
+
+// restartDags replaces the files of changed dags in parallel go routines,
+// returning the error of every dag that failed or was skipped and the files
+// they overwrote. previous maps each dag to the path of its deployed file.
+func (c *ComposerEnv) restartDags(dagsFolder string, dagsToRestart, previous map[string]string) (map[string]error, map[string]*replacedFile) {
+	c, span := c.span("RestartDags")
+	defer span.End()
+	var restartWg sync.WaitGroup
+	var mu sync.Mutex
+	errs := make(map[string]error)
+	replaced := make(map[string]*replacedFile)
+	sem := c.limiter()
+	for k, v := range dagsToRestart {
+		restartWg.Add(1)
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		go func(dag, relPath string) {
+			// done only once the replaced file is recorded for rollback
+			defer restartWg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			kept, err := c.restartDag(dagsFolder, dag, relPath, previous[dag])
+			if err != nil {
+				c.dagLog(dag).WithField(logging.Phase, "restart").WithError(err).Error("error restarting dag")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if kept != nil {
+				replaced[dag] = kept
+			}
+			if err != nil {
+				errs[dag] = err
+			}
+		}(k, v)
+	}
+	restartWg.Wait()
+	return errs, replaced
+}
+
 func (c *ComposerEnv) StartMonitoringDag() error {
-	c.Run("unpause", "airflow_monitoring")
+	c.unpauseDag("airflow_monitoring")
 	return nil
 }
 
-// StartDags deploys a list of dags in parallel go routines
-func (c *ComposerEnv) StartDags(dagsFolder string, dagsToStart map[string]string) error {
+// StartDags deploys a list of dags in parallel go routines, returning the
+// error of every dag that failed.
+func (c *ComposerEnv) StartDags(dagsFolder string, dagsToStart map[string]string) map[string]error {
+	c, span := c.span("StartDags")
+	defer span.End()
 	var startWg sync.WaitGroup
+	var mu sync.Mutex
+	errs := make(map[string]error)
+	sem := c.limiter()
 	for k, v := range dagsToStart {
 		startWg.Add(1)
-		go c.startDag(dagsFolder, k, v, &startWg)
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		go func(dag, relPath string) {
+			// done only once the error is recorded
+			defer startWg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			if err := c.startDag(dagsFolder, dag, relPath); err != nil {
+				c.dagLog(dag).WithField(logging.Phase, "start").WithError(err).Error("error starting dag")
+				mu.Lock()
+				errs[dag] = err
+				mu.Unlock()
+			}
+		}(k, v)
 	}
 	startWg.Wait()
-	return nil
+	return errs
 }
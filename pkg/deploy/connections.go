@@ -0,0 +1,130 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Values of Connection.Test controlling whether a connection is tested
+// after import and whether a failure fails the sync.
+const (
+	ConnTestRequired = "required"
+	ConnTestOptional = "optional"
+	ConnTestSkip     = "skip"
+)
+
+// Outcomes of a connection test.
+const (
+	ConnTestPassed  = "passed"
+	ConnTestFailed  = "failed"
+	ConnTestSkipped = "skipped"
+	// ConnTestUnsupported means Airflow can't test connections, so only
+	// that the connection was stored is known.
+	ConnTestUnsupported = "unsupported"
+)
+
+// ConnectionTestResult is the outcome of testing one imported connection.
+type ConnectionTestResult struct {
+	Name string
+	// Mode is the connection's Test field, empty is treated as skip.
+	Mode string
+	// Method is the Airflow command used: "test" or the "get" fallback.
+	Method string
+	Status string
+	Output string
+}
+
+// ConnectionTestError is returned when a connection marked required fails
+// its test.
+type ConnectionTestError struct {
+	Failed []ConnectionTestResult
+}
+
+func (e *ConnectionTestError) Error() string {
+	names := make([]string, 0, len(e.Failed))
+	for _, r := range e.Failed {
+		if r.Status == ConnTestUnsupported {
+			names = append(names, r.Name+" (connections test unsupported)")
+			continue
+		}
+		names = append(names, r.Name)
+	}
+	return fmt.Sprintf("required connection tests failed: %s", strings.Join(names, ", "))
+}
+
+// connTestUnsupported matches output of Airflow versions without a usable
+// `connections test` (older than 2.2, or with testing disabled).
+func connTestUnsupported(out string) bool {
+	return strings.Contains(out, "invalid choice") ||
+		strings.Contains(out, "Testing connections is disabled")
+}
+
+// TestConnection runs `connections test` for conn. On Airflow versions where
+// that is unavailable it falls back to `connections get`, which only checks
+// that the connection was stored, and reports it as unsupported.
+func (c *ComposerEnv) TestConnection(conn Connection) ConnectionTestResult {
+	result := ConnectionTestResult{Name: conn.Name, Mode: conn.Test, Method: "test"}
+	if conn.Test == "" || conn.Test == ConnTestSkip {
+		result.Status = ConnTestSkipped
+		return result
+	}
+
+	out, err := c.Run("connections", "test", conn.Name)
+	result.Output = strings.TrimSpace(string(out))
+	if connTestUnsupported(result.Output) {
+		c.phaseLog("connections").Infof("connections test unavailable, checking %s with connections get", conn.Name)
+		result.Method = "get"
+		out, err = c.Run("connections", "get", conn.Name)
+		result.Output = strings.TrimSpace(string(out))
+		result.Status = ConnTestUnsupported
+		if err != nil {
+			result.Status = ConnTestFailed
+		}
+		return result
+	}
+
+	switch {
+	case err != nil, strings.Contains(result.Output, "Connection failed!"):
+		result.Status = ConnTestFailed
+	case strings.Contains(result.Output, "Connection success!"):
+		result.Status = ConnTestPassed
+	default:
+		result.Status = ConnTestFailed
+	}
+	return result
+}
+
+// TestConnections tests every connection and logs the results. Failures of
+// optional connections are only reported, failures of required connections
+// are returned as a *ConnectionTestError. A required connection that can't
+// be tested fails too, rather than passing unchecked.
+func (c *ComposerEnv) TestConnections(connections []Connection) ([]ConnectionTestResult, error) {
+	results := make([]ConnectionTestResult, 0, len(connections))
+	var failed []ConnectionTestResult
+	for _, conn := range connections {
+		r := c.TestConnection(conn)
+		results = append(results, r)
+		switch r.Status {
+		case ConnTestSkipped:
+			c.phaseLog("connections").WithField("connection", r.Name).Info("test skipped")
+		case ConnTestPassed:
+			c.phaseLog("connections").WithField("connection", r.Name).Infof("test passed (%s)", r.Method)
+		case ConnTestFailed:
+			c.phaseLog("connections").WithFields(logrus.Fields{"connection": r.Name, "output": r.Output}).Warnf("%s test failed (%s)", r.Mode, r.Method)
+			if r.Mode == ConnTestRequired {
+				failed = append(failed, r)
+			}
+		case ConnTestUnsupported:
+			c.phaseLog("connections").WithField("connection", r.Name).Warnf("%s test unsupported by this Airflow, only checked that it exists", r.Mode)
+			if r.Mode == ConnTestRequired {
+				failed = append(failed, r)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return results, &ConnectionTestError{Failed: failed}
+	}
+	return results, nil
+}
@@ -0,0 +1,57 @@
+package deploy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTestConnections(t *testing.T) {
+	runner := newFakeRunner()
+	runner.outputs["connections test warehouse"] = "Testing...\n\nConnection success!\n"
+	runner.outputs["connections test api"] = "Testing...\n\nConnection failed!\n404 Not Found\n"
+	runner.outputs["connections test legacy"] = "airflow: error: argument COMMAND: invalid choice: 'test'"
+	runner.errs["connections test legacy"] = errors.New("exit status 2")
+	runner.outputs["connections test old_api"] = "Testing connections is disabled in Airflow configuration."
+	runner.errs["connections test old_api"] = errors.New("exit status 1")
+	runner.outputs["connections test gcp"] = "Testing...\n\nConnection failed!\nbad key\n"
+	c := ComposerEnv{Runner: runner}
+
+	results, err := c.TestConnections([]Connection{
+		{Name: "warehouse", Test: ConnTestRequired},
+		{Name: "api", Test: ConnTestOptional},
+		{Name: "legacy", Test: ConnTestRequired},
+		{Name: "skipped", Test: ConnTestSkip},
+		{Name: "untested"},
+		{Name: "gcp", Test: ConnTestRequired},
+		{Name: "old_api", Test: ConnTestOptional},
+	})
+
+	want := []struct{ status, method string }{
+		{ConnTestPassed, "test"},
+		{ConnTestFailed, "test"},
+		{ConnTestUnsupported, "get"},
+		{ConnTestSkipped, "test"},
+		{ConnTestSkipped, "test"},
+		{ConnTestFailed, "test"},
+		{ConnTestUnsupported, "get"},
+	}
+	for i, w := range want {
+		if results[i].Status != w.status || results[i].Method != w.method {
+			t.Errorf("%s: expected %s via %s, got %s via %s", results[i].Name, w.status, w.method, results[i].Status, results[i].Method)
+		}
+	}
+	if runner.called("connections test skipped") || runner.called("connections test untested") {
+		t.Errorf("skipped connections should not be tested: %v", runner.calls)
+	}
+
+	var testErr *ConnectionTestError
+	if !errors.As(err, &testErr) {
+		t.Fatalf("expected ConnectionTestError, got %v", err)
+	}
+	if len(testErr.Failed) != 2 || testErr.Failed[0].Name != "legacy" || testErr.Failed[1].Name != "gcp" {
+		t.Errorf("expected the untestable required legacy and gcp to fail the sync, got %+v", testErr.Failed)
+	}
+	if want := "required connection tests failed: legacy (connections test unsupported), gcp"; err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+}
@@ -0,0 +1,131 @@
+package deploy
+
+import (
+	"bytes"
+	_ "embed" // dagbag.py is run in the local interpreter
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//go:embed dagbag.py
+var dagBagScript string
+
+// DagBagResult is what a DagBag load of the local dags folder found. File
+// paths are relative to the dags folder.
+type DagBagResult struct {
+	Dags []struct {
+		DagID   string `json:"dag_id"`
+		Fileloc string `json:"fileloc"`
+	} `json:"dags"`
+	ImportErrors map[string]string `json:"import_errors"`
+}
+
+// LoadDagBag imports the local dags folder with Airflow's DagBag, either in
+// the Python interpreter at python or, when image is set, in a container
+// started from it.
+func LoadDagBag(dagsRoot, python, image string) (*DagBagResult, error) {
+	root, err := filepath.Abs(dagsRoot)
+	if err != nil {
+		return nil, err
+	}
+	var cmd *exec.Cmd
+	if image != "" {
+		cmd = exec.Command("docker", "run", "--rm", "-i",
+			"-v", root+":/dags:ro", "--entrypoint", "python", image, "-", "/dags")
+	} else {
+		cmd = exec.Command(python, "-", root)
+		cmd.Env = append(os.Environ(), "AIRFLOW__CORE__LOAD_EXAMPLES=False")
+	}
+	cmd.Stdin = strings.NewReader(dagBagScript)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error loading DagBag with %v: %v\n%s", cmd.Args[0], err, stderr.String())
+	}
+	var result DagBagResult
+	if err := json.Unmarshal(extractJSON(out), &result); err != nil {
+		return nil, fmt.Errorf("error parsing DagBag output: %v", err)
+	}
+	return &result, nil
+}
+
+// checkDagBag compares a DagBag load of root against the files expected for
+// each DAG and reports import errors, DAGs defined in several files and DAGs that are
+// missing or don't come from the file that was matched for them.
+func checkDagBag(root string, expected map[string][]string, result *DagBagResult) ValidationErrors {
+	var errs ValidationErrors
+
+	files := make([]string, 0, len(result.ImportErrors))
+	for f := range result.ImportErrors {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		errs = append(errs, ValidationError{File: filepath.Join(root, f), Message: "import error:\n" + result.ImportErrors[f]})
+	}
+
+	found := make(map[string][]string)
+	for _, d := range result.Dags {
+		found[d.DagID] = append(found[d.DagID], filepath.Clean(d.Fileloc))
+	}
+
+	for _, dag := range sortedKeys(stringSet(expected)) {
+		paths := expected[dag]
+		switch {
+		case len(paths) == 0:
+			errs = append(errs, ValidationError{File: root, Field: dag, Message: "no file found in the dags folder"})
+			continue
+		case len(paths) > 1:
+			errs = append(errs, ValidationError{File: root, Field: dag, Message: fmt.Sprintf("matched several files %v", paths)})
+			continue
+		}
+		locs := found[dag]
+		switch {
+		case len(locs) > 1:
+			errs = append(errs, ValidationError{File: filepath.Join(root, paths[0]), Field: dag, Message: fmt.Sprintf("dag_id defined in several files %v", locs)})
+		case len(locs) == 0:
+			if _, ok := result.ImportErrors[filepath.Clean(paths[0])]; !ok {
+				errs = append(errs, ValidationError{File: filepath.Join(root, paths[0]), Field: dag, Message: "file doesn't define a DAG with this dag_id"})
+			}
+		case locs[0] != filepath.Clean(paths[0]):
+			errs = append(errs, ValidationError{File: filepath.Join(root, paths[0]), Field: dag, Message: fmt.Sprintf("dag_id is defined in %v", locs[0])})
+		}
+	}
+	return errs
+}
+
+func stringSet(m map[string][]string) map[string]bool {
+	set := make(map[string]bool, len(m))
+	for k := range m {
+		set[k] = true
+	}
+	return set
+}
+
+// ValidateDagBag loads the local dags folder with Airflow and checks every
+// DAG in dags against the file FindDagFilesInLocalTree matched for it.
+func (c *ComposerEnv) ValidateDagBag(dags map[string]bool, python, image string) error {
+	expected, err := FindDagFilesInLocalTree(c.LocalDagsDir, dags)
+	if err != nil {
+		return err
+	}
+	for dag := range dags {
+		if _, ok := expected[dag]; !ok {
+			expected[dag] = nil
+		}
+	}
+	result, err := LoadDagBag(c.LocalDagsDir, python, image)
+	if err != nil {
+		return err
+	}
+	if errs := checkDagBag(c.LocalDagsDir, expected, result); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+"""Load a dags folder with Airflow's DagBag and print what was found as JSON.
+
+Run by `dagger validate` with the dags folder as the only argument. Every
+file is processed into a DagBag of its own, so a dag_id defined in several
+files is reported once per file instead of as an import error of the later
+one.
+"""
+import json
+import os
+import sys
+
+os.environ.setdefault("AIRFLOW__CORE__LOAD_EXAMPLES", "False")
+
+from airflow.models import DagBag  # noqa: E402
+from airflow.utils.file import list_py_file_paths  # noqa: E402
+
+folder = os.path.abspath(sys.argv[1])
+
+
+def rel(path):
+    return os.path.relpath(path, folder)
+
+
+dags = []
+import_errors = {}
+for path in list_py_file_paths(folder, include_examples=False):
+    bag = DagBag(dag_folder=folder, include_examples=False, collect_dags=False)
+    for dag in bag.process_file(path, only_if_updated=False):
+        dags.append({"dag_id": dag.dag_id, "fileloc": rel(dag.fileloc)})
+    import_errors.update({rel(f): err for f, err in bag.import_errors.items()})
+
+json.dump({"dags": dags, "import_errors": import_errors}, sys.stdout)
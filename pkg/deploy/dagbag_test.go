@@ -0,0 +1,94 @@
+package deploy
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/inshur/dagger/pkg/store"
+)
+
+func TestCheckDagBag(t *testing.T) {
+	var result DagBagResult
+	err := json.Unmarshal([]byte(`{
+		"dags": [
+			{"dag_id": "finance_daily", "fileloc": "finance_daily.py"},
+			{"dag_id": "weekly_report", "fileloc": "reports/other.py"}
+		],
+		"import_errors": {"broken.py": "Traceback (most recent call last):\nSyntaxError: invalid syntax"}
+	}`), &result)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string][]string{
+		"finance_daily": {"finance_daily.py"},
+		"weekly_report": {"reports/weekly_report.py"},
+		"broken":        {"broken.py"},
+		"missing":       nil,
+	}
+	errs := checkDagBag("dags", expected, &result)
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.File+" "+e.Field)
+	}
+	want := []string{
+		"dags/broken.py ",
+		"dags missing",
+		"dags/reports/weekly_report.py weekly_report",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+// TestLoadDagBagDuplicates runs dagbag.py against a stand-in for Airflow
+// that, like Airflow, refuses a dag_id seen before in the same DagBag.
+func TestLoadDagBagDuplicates(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not installed")
+	}
+	fake, err := filepath.Abs(filepath.Join("testdata", "fakeairflow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, had := os.LookupEnv("PYTHONPATH")
+	os.Setenv("PYTHONPATH", fake)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv("PYTHONPATH", previous)
+		} else {
+			os.Unsetenv("PYTHONPATH")
+		}
+	})
+
+	root := t.TempDir()
+	dags := store.Dir{Root: root}
+	for name, src := range map[string]string{
+		"billing_daily.py":        "from airflow.models import DAG\ndag = DAG('billing_daily')\n",
+		"legacy/billing_daily.py": "from airflow.models import DAG\ndag = DAG('billing_daily')\n",
+		"finance_daily.py":        "from airflow.models import DAG\ndag = DAG('finance_daily')\n",
+	} {
+		if err := dags.Write(name, []byte(src), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := LoadDagBag(root, python, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(result.ImportErrors) != 0 {
+		t.Errorf("expected no import errors, got %v", result.ImportErrors)
+	}
+	expected := map[string][]string{
+		"billing_daily": {"billing_daily.py"},
+		"finance_daily": {"finance_daily.py"},
+	}
+	errs := checkDagBag(root, expected, result)
+	if len(errs) != 1 || errs[0].Field != "billing_daily" || errs[0].Message != "dag_id defined in several files [billing_daily.py legacy/billing_daily.py]" {
+		t.Errorf("expected billing_daily to be reported as duplicated, got %v", errs)
+	}
+}
@@ -0,0 +1,151 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// What to do with a DAG that still has active tasks when the drain timeout
+// passes.
+const (
+	// DrainForce replaces or deletes the DAG anyway.
+	DrainForce = "force"
+	// DrainSkip leaves the DAG as it is until the next sync.
+	DrainSkip = "skip"
+	// DrainFail fails the DAG and with it the sync.
+	DrainFail = "fail"
+)
+
+// defaultDrainPollInterval is used when DrainPollInterval is not set.
+const defaultDrainPollInterval = 15 * time.Second
+
+// ErrDrainSkipped is returned for DAGs left untouched because they didn't
+// drain in time and DrainOnTimeout is DrainSkip.
+var ErrDrainSkipped = errors.New("dag did not drain in time, skipped")
+
+// activeTaskStates are task instance states that mean a task is executing
+// or about to.
+var activeTaskStates = map[string]bool{
+	"running":    true,
+	"queued":     true,
+	"restarting": true,
+}
+
+type dagRun struct {
+	RunID string `json:"run_id"`
+	State string `json:"state"`
+}
+
+type taskInstance struct {
+	TaskID string `json:"task_id"`
+	State  string `json:"state"`
+}
+
+// extractJSON strips gcloud chatter surrounding the JSON document in out.
+func extractJSON(out []byte) []byte {
+	start := bytes.IndexAny(out, "[{")
+	end := bytes.LastIndexAny(out, "]}")
+	if start < 0 || end < start {
+		return nil
+	}
+	return out[start : end+1]
+}
+
+// listRuns returns the DAG runs of dag in state.
+func (c *ComposerEnv) listRuns(dag, state string) ([]dagRun, error) {
+	out, err := c.Run("dags", "list-runs", "-d", dag, "--state", state, "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("error listing %v runs of %v: %s", state, dag, out)
+	}
+	var runs []dagRun
+	if data := extractJSON(out); data != nil {
+		if err := json.Unmarshal(data, &runs); err != nil {
+			return nil, fmt.Errorf("error decoding %v runs of %v: %v", state, dag, err)
+		}
+	}
+	return runs, nil
+}
+
+// activeTasks counts the task instances still executing in the running DAG
+// runs of dag, plus one for every queued DAG run, which would otherwise
+// start against the replaced file. A paused DAG's running runs never finish
+// on their own, so only task instances are waited for.
+func (c *ComposerEnv) activeTasks(dag string) (int, error) {
+	queued, err := c.listRuns(dag, "queued")
+	if err != nil {
+		return 0, err
+	}
+	runs, err := c.listRuns(dag, "running")
+	if err != nil {
+		return 0, err
+	}
+
+	active := len(queued)
+	for _, run := range runs {
+		out, err := c.Run("tasks", "states-for-dag-run", dag, run.RunID, "-o", "json")
+		if err != nil {
+			return 0, fmt.Errorf("error listing tasks of %v run %v: %s", dag, run.RunID, out)
+		}
+		var tasks []taskInstance
+		if data := extractJSON(out); data != nil {
+			if err := json.Unmarshal(data, &tasks); err != nil {
+				return 0, fmt.Errorf("error decoding tasks of %v run %v: %v", dag, run.RunID, err)
+			}
+		}
+		for _, ti := range tasks {
+			if activeTaskStates[ti.State] {
+				active++
+			}
+		}
+	}
+	return active, nil
+}
+
+// drainDag waits, up to DrainTimeout, for the already paused dag to have no
+// executing tasks. It does nothing when DrainTimeout is zero. On timeout it
+// returns nil for DrainForce, ErrDrainSkipped for DrainSkip and an error
+// otherwise.
+func (c *ComposerEnv) drainDag(dag string) error {
+	if c.DrainTimeout <= 0 {
+		return nil
+	}
+	interval := c.DrainPollInterval
+	if interval <= 0 {
+		interval = defaultDrainPollInterval
+	}
+	deadline := time.Now().Add(c.DrainTimeout)
+	for {
+		active, err := c.activeTasks(dag)
+		if err != nil {
+			return err
+		}
+		if active == 0 {
+			c.dagLog(dag).Info("dag drained")
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			break
+		}
+		c.dagLog(dag).Infof("waiting for %d active tasks to finish", active)
+		time.Sleep(interval)
+	}
+
+	switch c.DrainOnTimeout {
+	case DrainForce:
+		c.dagLog(dag).Warnf("dag did not drain within %v, forcing", c.DrainTimeout)
+		return nil
+	case DrainSkip:
+		c.dagLog(dag).Warnf("dag did not drain within %v, skipping", c.DrainTimeout)
+		if c.DagSpecs[dag].State == DagActive {
+			if out, err := c.unpauseDag(dag); err != nil {
+				c.dagLog(dag).WithField("output", string(out)).Error("error unpausing skipped dag")
+			}
+		}
+		return ErrDrainSkipped
+	default:
+		return fmt.Errorf("dag %v did not drain within %v", dag, c.DrainTimeout)
+	}
+}
@@ -0,0 +1,73 @@
+package deploy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const listRunsOutput = `kubeconfig entry generated for europe-west1-composer.
+[{"dag_id": "finance_daily", "run_id": "scheduled__2021-06-01T00:00:00+00:00", "state": "running"}]
+`
+
+func drainRunner(taskState string) *fakeRunner {
+	runner := newFakeRunner()
+	runner.outputs["dags list-runs -d finance_daily --state running -o json"] = listRunsOutput
+	runner.outputs["tasks states-for-dag-run finance_daily scheduled__2021-06-01T00:00:00+00:00 -o json"] =
+		`[{"task_id": "extract", "state": "success"}, {"task_id": "load", "state": "` + taskState + `"}]`
+	return runner
+}
+
+func TestDrainDag(t *testing.T) {
+	c := ComposerEnv{
+		Runner:            drainRunner("scheduled"),
+		DrainTimeout:      time.Millisecond,
+		DrainPollInterval: time.Millisecond,
+	}
+	if err := c.drainDag("finance_daily"); err != nil {
+		t.Errorf("expected dag without executing tasks to drain, got %v", err)
+	}
+
+	queued := drainRunner("scheduled")
+	queued.outputs["dags list-runs -d finance_daily --state queued -o json"] =
+		`[{"dag_id": "finance_daily", "run_id": "manual__2021-06-01T08:00:00+00:00", "state": "queued"}]`
+	c.Runner = queued
+	c.DrainOnTimeout = DrainFail
+	if err := c.drainDag("finance_daily"); err == nil {
+		t.Errorf("expected dag with a queued run not to drain")
+	}
+
+	tests := []struct {
+		onTimeout string
+		check     func(error) bool
+	}{
+		{DrainForce, func(err error) bool { return err == nil }},
+		{DrainSkip, func(err error) bool { return errors.Is(err, ErrDrainSkipped) }},
+		{DrainFail, func(err error) bool { return err != nil && !errors.Is(err, ErrDrainSkipped) }},
+	}
+	for _, tt := range tests {
+		runner := drainRunner("running")
+		c := ComposerEnv{
+			Runner:            runner,
+			DrainTimeout:      3 * time.Millisecond,
+			DrainPollInterval: time.Millisecond,
+			DrainOnTimeout:    tt.onTimeout,
+			DagSpecs:          RunningList{"finance_daily": {ID: "finance_daily", State: DagActive}},
+		}
+		err := c.drainDag("finance_daily")
+		if !tt.check(err) {
+			t.Errorf("%s: unexpected result %v", tt.onTimeout, err)
+		}
+		if unpaused := runner.called("dags unpause finance_daily"); unpaused != (tt.onTimeout == DrainSkip) {
+			t.Errorf("%s: expected skipped active dags only to be unpaused, calls: %v", tt.onTimeout, runner.calls)
+		}
+	}
+}
+
+func TestDrainDisabled(t *testing.T) {
+	runner := newFakeRunner()
+	c := ComposerEnv{Runner: runner}
+	if err := c.drainDag("finance_daily"); err != nil || len(runner.calls) > 0 {
+		t.Errorf("expected no drain without a timeout, got %v with calls %v", err, runner.calls)
+	}
+}
@@ -0,0 +1,178 @@
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/inshur/dagger/pkg/store"
+)
+
+// deploymentsObject is the audit log of every sync, one JSON record a line.
+const deploymentsObject = ".dagger/deployments.jsonl"
+
+// Deployment statuses.
+const (
+	DeploySucceeded = "succeeded"
+	DeployFailed    = "failed"
+)
+
+// PlanSummary counts the changes of a plan.
+type PlanSummary struct {
+	Start     int `json:"start"`
+	Restart   int `json:"restart"`
+	Stop      int `json:"stop"`
+	Pause     int `json:"pause"`
+	Unpause   int `json:"unpause"`
+	Unchanged int `json:"unchanged"`
+}
+
+// Deployment is the audit record of one sync.
+type Deployment struct {
+	ID          string    `json:"id"`
+	Environment string    `json:"environment"`
+	Project     string    `json:"project"`
+	Started     time.Time `json:"started"`
+	Duration    float64   `json:"duration_seconds"`
+	Provenance
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Plan is nil when the sync failed before planning.
+	Plan *PlanSummary `json:"plan,omitempty"`
+	// Dags maps every DAG the sync touched to its outcome.
+	Dags map[string]string `json:"dags,omitempty"`
+	// ImportErrors maps the DAGs that failed to import to their file.
+	ImportErrors map[string]string `json:"import_errors,omitempty"`
+}
+
+// Summary counts the changes of p.
+func (p *Plan) Summary() PlanSummary {
+	return PlanSummary{
+		Start:     len(p.Start) - len(p.Restart),
+		Restart:   len(p.Restart),
+		Stop:      len(p.Stop),
+		Pause:     len(p.Pause),
+		Unpause:   len(p.Unpause),
+		Unchanged: len(p.Unchanged),
+	}
+}
+
+// NewDeployment builds the record of a sync started at started that ended
+// with err, p is nil when it failed before planning.
+func (c *ComposerEnv) NewDeployment(started time.Time, prov Provenance, p *Plan, err error) Deployment {
+	d := Deployment{
+		ID:          c.DeployID,
+		Environment: c.Name,
+		Project:     c.Project,
+		Started:     started.UTC(),
+		Duration:    time.Since(started).Seconds(),
+		Provenance:  prov,
+		Status:      DeploySucceeded,
+	}
+	if err != nil {
+		d.Status = DeployFailed
+		d.Error = err.Error()
+	}
+	if p != nil {
+		summary := p.Summary()
+		d.Plan = &summary
+		d.Dags = p.Results
+		for dag, ie := range p.ImportErrors {
+			if d.ImportErrors == nil {
+				d.ImportErrors = make(map[string]string)
+			}
+			d.ImportErrors[dag] = ie.File
+		}
+	}
+	return d
+}
+
+// recordAttempts bounds the retries of RecordDeployment when other runs
+// append to the log at the same time.
+const recordAttempts = 10
+
+// RecordDeployment appends d to the deployments log in the history store.
+// The log is only written if no other run changed it since it was read, and
+// the append is retried otherwise.
+func (c *ComposerEnv) RecordDeployment(d Deployment) error {
+	line, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; attempt < recordAttempts; attempt++ {
+		var generation int64
+		var existing []byte
+		obj, err := c.history().Stat(deploymentsObject)
+		switch {
+		case err == nil:
+			generation = obj.Generation
+			if existing, err = c.history().Read(deploymentsObject); err != nil && err != store.ErrNotExist {
+				return fmt.Errorf("error reading %v: %v", deploymentsObject, err)
+			}
+		case err != store.ErrNotExist:
+			return fmt.Errorf("error reading %v: %v", deploymentsObject, err)
+		}
+		data := append(existing, append(line, '\n')...)
+		_, err = c.history().WriteIf(deploymentsObject, generation, data, nil)
+		if err == store.ErrPrecondition {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error writing %v: %v", deploymentsObject, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("error writing %v: changed by other runs %d times", deploymentsObject, recordAttempts)
+}
+
+// ReadDeployments returns the recorded deployments, oldest first.
+func (c *ComposerEnv) ReadDeployments() ([]Deployment, error) {
+	data, err := c.history().Read(deploymentsObject)
+	if err == store.ErrNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %v: %v", deploymentsObject, err)
+	}
+	var deployments []Deployment
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var d Deployment
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			return nil, fmt.Errorf("%v:%d: %v", deploymentsObject, line, err)
+		}
+		deployments = append(deployments, d)
+	}
+	return deployments, scanner.Err()
+}
+
+// DeploymentFilter selects deployments, zero fields match everything.
+type DeploymentFilter struct {
+	Dag    string
+	User   string
+	Status string
+	Since  time.Time
+}
+
+// Match reports whether d is selected by f.
+func (f DeploymentFilter) Match(d Deployment) bool {
+	if f.Dag != "" {
+		if _, ok := d.Dags[f.Dag]; !ok {
+			return false
+		}
+	}
+	if f.User != "" && !strings.EqualFold(f.User, d.User) {
+		return false
+	}
+	if f.Status != "" && f.Status != d.Status {
+		return false
+	}
+	return f.Since.IsZero() || !d.Started.Before(f.Since)
+}
@@ -0,0 +1,115 @@
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/inshur/dagger/pkg/store"
+)
+
+func TestDeploymentHistory(t *testing.T) {
+	c := ComposerEnv{Name: "composer-prod", Project: "analytics-prod", History: store.Dir{Root: t.TempDir()}}
+	if deployments, err := c.ReadDeployments(); err != nil || len(deployments) != 0 {
+		t.Fatalf("expected no deployments yet, got %v, %v", deployments, err)
+	}
+
+	p := &Plan{
+		Start:   map[string]string{"finance_daily": "finance_daily.py", "weekly_report": "weekly_report.py"},
+		Stop:    map[string]string{"old_dag": "old_dag.py"},
+		Restart: map[string]string{"weekly_report": "weekly_report.py"},
+	}
+	p.record("finance_daily", "started", nil)
+	p.record("weekly_report", "restarted", errors.New("dag weekly_report was not parsed"))
+	p.record("old_dag", "stopped", nil)
+	p.ImportErrors = map[string]*DagImportError{"weekly_report": {Dag: "weekly_report", File: "weekly_report.py"}}
+
+	started := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	prov := Provenance{Commit: "0123456789abcdef", User: "alice", Version: "v1.2.0"}
+	c.DeployID = NewDeployID(started)
+	if err := c.RecordDeployment(c.NewDeployment(started, prov, p, errors.New("apply error"))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.DeployID = NewDeployID(started.Add(time.Hour))
+	prov.User = "bob"
+	if err := c.RecordDeployment(c.NewDeployment(started.Add(time.Hour), prov, nil, nil)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	deployments, err := c.ReadDeployments()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(deployments) != 2 {
+		t.Fatalf("expected 2 deployments, got %+v", deployments)
+	}
+	first := deployments[0]
+	if first.Status != DeployFailed || first.User != "alice" || first.Environment != "composer-prod" {
+		t.Errorf("unexpected record: %+v", first)
+	}
+	if want := (PlanSummary{Start: 1, Restart: 1, Stop: 1}); first.Plan == nil || *first.Plan != want {
+		t.Errorf("expected plan summary %+v, got %+v", want, first.Plan)
+	}
+	if first.Dags["weekly_report"] != "failed: dag weekly_report was not parsed" {
+		t.Errorf("unexpected dag results: %v", first.Dags)
+	}
+	if first.ImportErrors["weekly_report"] != "weekly_report.py" {
+		t.Errorf("unexpected import errors: %v", first.ImportErrors)
+	}
+
+	var ids []string
+	for _, d := range deployments {
+		if (DeploymentFilter{Dag: "old_dag", Status: DeployFailed}).Match(d) {
+			ids = append(ids, d.ID)
+		}
+		if (DeploymentFilter{Since: started.Add(time.Minute)}).Match(d) {
+			ids = append(ids, d.ID)
+		}
+	}
+	if want := []string{"20210601T120000Z", "20210601T130000Z"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("expected filtered deployments %v, got %v", want, ids)
+	}
+}
+
+// racingStore lets another run append to the log right before the first
+// conditional write, which then fails its precondition.
+type racingStore struct {
+	store.Dir
+	other []byte
+}
+
+func (s *racingStore) WriteIf(name string, generation int64, data []byte, metadata map[string]string) (store.Object, error) {
+	if s.other != nil {
+		other := s.other
+		s.other = nil
+		if err := s.Dir.Write(name, other, nil); err != nil {
+			return store.Object{}, err
+		}
+		return store.Object{}, store.ErrPrecondition
+	}
+	return s.Dir.WriteIf(name, generation, data, metadata)
+}
+
+func TestRecordDeploymentRetriesConcurrentAppend(t *testing.T) {
+	other, _ := json.Marshal(Deployment{ID: "20210601T115959Z"})
+	s := &racingStore{Dir: store.Dir{Root: t.TempDir()}, other: append(other, '\n')}
+	c := ComposerEnv{Name: "composer-prod", History: s}
+	started := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	c.DeployID = NewDeployID(started)
+	if err := c.RecordDeployment(c.NewDeployment(started, Provenance{}, nil, nil)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	deployments, err := c.ReadDeployments()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var ids []string
+	for _, d := range deployments {
+		ids = append(ids, d.ID)
+	}
+	if want := []string{"20210601T115959Z", "20210601T120000Z"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("expected both runs' deployments %v, got %v", want, ids)
+	}
+}
@@ -0,0 +1,139 @@
+package deploy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/inshur/dagger/pkg/store"
+	"github.com/sirupsen/logrus"
+)
+
+// CheckImportErrors fetches the import errors reported by the scheduler and
+// returns those for the files deployed in this run, keyed by DAG. deployed
+// maps DAGs to their path relative to the dags folder.
+func (c *ComposerEnv) CheckImportErrors(deployed map[string]string) (map[string]*DagImportError, error) {
+	found := make(map[string]*DagImportError)
+	if len(deployed) == 0 {
+		return found, nil
+	}
+	importErrs, err := c.listImportErrors()
+	if err != nil {
+		return nil, err
+	}
+	for _, ie := range importErrs {
+		for dag, relPath := range deployed {
+			if matchesDagFile(ie.Filepath, relPath) {
+				found[dag] = &DagImportError{Dag: dag, File: ie.Filepath, Traceback: ie.Error}
+			}
+		}
+	}
+	return found, nil
+}
+
+// collectImportErrors merges the import errors already returned while
+// starting DAGs with those reported after the deploy.
+func (c *ComposerEnv) collectImportErrors(deployed map[string]string, startErrs map[string]error) (map[string]*DagImportError, error) {
+	found, err := c.CheckImportErrors(deployed)
+	if err != nil {
+		return nil, err
+	}
+	for dag, err := range startErrs {
+		var importErr *DagImportError
+		if errors.As(err, &importErr) {
+			found[dag] = importErr
+		}
+	}
+	return found, nil
+}
+
+// ComposerEnv.reportImportErrors logs the traceback of every import error.
+func (c *ComposerEnv) reportImportErrors(importErrs map[string]*DagImportError) {
+	dags := make([]string, 0, len(importErrs))
+	for dag := range importErrs {
+		dags = append(dags, dag)
+	}
+	sort.Strings(dags)
+	for _, dag := range dags {
+		ie := importErrs[dag]
+		c.dagLog(dag).WithFields(logrus.Fields{"file": ie.File, "traceback": ie.Traceback}).Error("import error")
+	}
+}
+
+// replacedFile is a deployed file a restart overwrote, kept so it can be
+// restored when the new file fails to import.
+type replacedFile struct {
+	Object   string
+	Data     []byte
+	Metadata map[string]string
+}
+
+// keepDeployedFile reads the object a restart is about to overwrite, or
+// returns nil when it is already gone.
+func (c *ComposerEnv) keepDeployedFile(object string) (*replacedFile, error) {
+	obj, err := c.objects().Stat(object)
+	if err == store.ErrNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %v: %v", object, err)
+	}
+	data, err := c.objects().Read(object)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %v: %v", object, err)
+	}
+	return &replacedFile{Object: object, Data: data, Metadata: obj.Metadata}, nil
+}
+
+// rollbackImportErrors pauses the DAGs whose deployed file failed to import.
+// Restarted DAGs get the file they replaced back and are unpaused again
+// unless the running list keeps them paused. Files of new DAGs are removed
+// so the scheduler stops parsing them.
+func (c *ComposerEnv) rollbackImportErrors(deployed map[string]string, replaced map[string]*replacedFile, importErrs map[string]*DagImportError) error {
+	var failed []string
+	for dag := range importErrs {
+		relPath := deployed[dag]
+		c.dagLog(dag).WithField(logging.Object, "dags/"+relPath).Warn("rolling back dag that failed to import")
+		if out, err := c.pauseDag(dag); err != nil {
+			c.dagLog(dag).WithField("output", string(out)).Error("error pausing dag")
+		}
+		if prev, ok := replaced[dag]; ok {
+			if err := c.restoreReplacedFile(dag, relPath, prev); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", dag, err))
+			}
+			continue
+		}
+		if err := DeleteFile(c.logContext(), c.bucket(), fmt.Sprintf("dags/%s", relPath)); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", dag, err))
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("couldn't roll back %v", failed)
+	}
+	return nil
+}
+
+// restoreReplacedFile puts back the file a restart of dag overwrote,
+// removing the new one when the DAG had moved. The DAG was never deleted,
+// so it runs its previous version again as soon as it is unpaused.
+func (c *ComposerEnv) restoreReplacedFile(dag, relPath string, prev *replacedFile) error {
+	c.dagLog(dag).WithField(logging.Object, prev.Object).Info("restoring previous dag file")
+	if err := c.objects().Write(prev.Object, prev.Data, prev.Metadata); err != nil {
+		return fmt.Errorf("error restoring %v: %v", prev.Object, err)
+	}
+	if prev.Object != "dags/"+relPath {
+		if err := c.objects().Delete("dags/" + relPath); err != nil {
+			return err
+		}
+	}
+	if c.DagSpecs.Paused(dag) {
+		return nil
+	}
+	if out, err := c.unpauseDag(dag); err != nil {
+		return fmt.Errorf("error unpausing dag: %s", bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package deploy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/inshur/dagger/pkg/gcshasher"
+	"github.com/inshur/dagger/pkg/store"
+)
+
+// Local file states reported by Inspect.
+const (
+	LocalMatches = "matches"
+	LocalDiffers = "differs"
+	LocalMissing = "missing"
+)
+
+// DagInspection describes the deployed file of a DAG and where it came from.
+type DagInspection struct {
+	Dag string
+	// Object is the deployed file, with the provenance in its metadata.
+	Object store.Object
+	// LocalPath is the file of the DAG in the local checkout, if any.
+	LocalPath string
+	// Local compares the local file with the deployed one.
+	Local string
+}
+
+// Inspect finds the deployed file of dag and compares it with the local
+// checkout. Following the dag_id == file name convention, the file is the
+// .py object named after the DAG under dags/.
+func (c *ComposerEnv) Inspect(dag string) (*DagInspection, error) {
+	objs, err := c.objects().List("dags/")
+	if err != nil {
+		return nil, err
+	}
+	var found []store.Object
+	for _, obj := range objs {
+		if path.Base(obj.Name) == dag+".py" {
+			found = append(found, obj)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no deployed file for dag %v", dag)
+	case 1:
+	default:
+		var names []string
+		for _, obj := range found {
+			names = append(names, obj.Name)
+		}
+		return nil, fmt.Errorf("several deployed files for dag %v: %v", dag, names)
+	}
+
+	result := &DagInspection{Dag: dag, Object: found[0], Local: LocalMissing}
+	relPath := strings.TrimPrefix(found[0].Name, "dags/")
+	local := filepath.Join(c.LocalDagsDir, filepath.FromSlash(relPath))
+	if _, err := ioutil.ReadFile(local); err != nil {
+		return result, nil
+	}
+	result.LocalPath = local
+	eq, err := c.localFileEqObject(local, relPath, found[0])
+	if err != nil {
+		return nil, err
+	}
+	result.Local = LocalDiffers
+	if eq {
+		result.Local = LocalMatches
+	}
+	return result, nil
+}
+
+// localFileEqObject compares a local file with a deployed DAG file.
+func (c *ComposerEnv) localFileEqObject(local, relPath string, obj store.Object) (bool, error) {
+	if c.Objects == nil {
+		gcs, err := c.dagObjectURL(relPath)
+		if err != nil {
+			return false, err
+		}
+		return gcshasher.LocalFileEqGCS(local, gcs)
+	}
+	data, err := ioutil.ReadFile(local)
+	if err != nil {
+		return false, err
+	}
+	return store.MD5(data) == obj.MD5, nil
+}
@@ -0,0 +1,45 @@
+package deploy
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/inshur/dagger/pkg/store"
+)
+
+func TestInspect(t *testing.T) {
+	bucket := store.Dir{Root: t.TempDir()}
+	local := t.TempDir()
+	c := ComposerEnv{
+		Objects:      bucket,
+		LocalDagsDir: local,
+		DeployID:     "20210601T120000Z",
+		Provenance:   Provenance{Commit: "0123456789abcdef", Repo: "git@github.com:inshur/dags.git", Version: "v1.2.0"},
+	}
+	meta := withLocalPath(c.provenanceMetadata(), filepath.Join(local, "reports", "weekly_report.py"))
+	if err := bucket.Write("dags/reports/weekly_report.py", []byte("v1"), meta); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	result, err := c.Inspect("weekly_report")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.Local != LocalMissing || result.Object.Metadata[MetadataCommit] != "0123456789abcdef" ||
+		result.Object.Metadata[MetadataDeployID] != "20210601T120000Z" {
+		t.Errorf("unexpected inspection: %+v", result)
+	}
+
+	for content, want := range map[string]string{"v1": LocalMatches, "v2": LocalDiffers} {
+		if err := (store.Dir{Root: local}).Write("reports/weekly_report.py", []byte(content), nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if result, err := c.Inspect("weekly_report"); err != nil || result.Local != want {
+			t.Errorf("expected local file to be %s, got %+v, %v", want, result, err)
+		}
+	}
+
+	if _, err := c.Inspect("finance_daily"); err == nil {
+		t.Errorf("expected an error for a DAG that isn't deployed")
+	}
+}
@@ -0,0 +1,52 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/inshur/dagger/pkg/lease"
+)
+
+// lockObject is the lease keeping two dagger runs from changing an
+// environment at the same time.
+const lockObject = ".dagger/lock"
+
+// LockOwner describes this run for whoever finds the environment locked.
+func LockOwner(p Provenance) string {
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s@%s pid %d", p.User, host, os.Getpid())
+	if p.Commit != "" {
+		owner += " commit " + p.Commit
+	}
+	return owner
+}
+
+// Lock acquires the environment lock in its bucket for owner. It fails with
+// a *lease.HeldError while another run holds it.
+func (c *ComposerEnv) Lock(owner string) (*lease.Lease, error) {
+	l := &lease.Lease{Store: c.objects(), Name: lockObject, Owner: owner, TTL: c.LockTTL}
+	if err := l.Acquire(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Unlock removes the environment lock once its holder let it expire, or
+// whoever holds it with force. It returns the lock that was removed.
+func (c *ComposerEnv) Unlock(force bool) (*lease.Info, error) {
+	info, generation, err := lease.Read(c.objects(), lockObject)
+	if err != nil {
+		return nil, err
+	}
+	if force {
+		return info, lease.Break(c.objects(), lockObject)
+	}
+	if !info.Expired(time.Now()) {
+		return info, &lease.HeldError{Info: *info}
+	}
+	if err := c.objects().DeleteIf(lockObject, generation); err != nil {
+		return info, err
+	}
+	return info, nil
+}
@@ -0,0 +1,41 @@
+package deploy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/inshur/dagger/pkg/lease"
+	"github.com/inshur/dagger/pkg/store"
+)
+
+func TestLockUnlock(t *testing.T) {
+	c := ComposerEnv{Objects: store.Dir{Root: t.TempDir()}, LockTTL: time.Minute}
+	if _, err := c.Unlock(false); err != store.ErrNotExist {
+		t.Errorf("expected unlocking an unlocked environment to fail with ErrNotExist, got %v", err)
+	}
+	l, err := c.Lock("ci-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var held *lease.HeldError
+	if _, err := c.Lock("ci-2"); !errors.As(err, &held) || held.Info.Owner != "ci-1" {
+		t.Fatalf("expected the environment to be locked by ci-1, got %v", err)
+	}
+	if _, err := c.Unlock(false); !errors.As(err, &held) {
+		t.Errorf("expected unlocking a live lock without force to fail, got %v", err)
+	}
+	info, err := c.Unlock(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if info.Owner != "ci-1" {
+		t.Errorf("expected to remove the lock of ci-1, got %+v", info)
+	}
+	if err := l.Renew(); err != lease.ErrLost {
+		t.Errorf("expected ci-1 to lose its lock, got %v", err)
+	}
+	if _, err := c.Lock("ci-2"); err != nil {
+		t.Errorf("expected the environment to be lockable after unlock, got %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package deploy
+
+import (
+	"context"
+
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// log returns the entry the environment logs to, tagged with the fields of
+// the run using it.
+func (c *ComposerEnv) log() *logrus.Entry {
+	if c.Log != nil {
+		return c.Log
+	}
+	return logrus.NewEntry(logging.Log)
+}
+
+// dagLog returns a log entry about dag.
+func (c *ComposerEnv) dagLog(dag string) *logrus.Entry {
+	return c.log().WithField(logging.DagID, dag)
+}
+
+// phaseLog returns a log entry for a phase of the sync.
+func (c *ComposerEnv) phaseLog(phase string) *logrus.Entry {
+	return c.log().WithField(logging.Phase, phase)
+}
+
+// objectLog returns a log entry about an object in the environment bucket.
+func (c *ComposerEnv) objectLog(name string) *logrus.Entry {
+	return c.log().WithField(logging.Object, name)
+}
+
+// logContext returns the environment's context carrying its log entry, for
+// the functions that only get a context.
+func (c *ComposerEnv) logContext() context.Context {
+	return logging.NewContext(c.traceContext(), c.log())
+}
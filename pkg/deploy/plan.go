@@ -0,0 +1,352 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/inshur/dagger/pkg/metrics"
+	"github.com/inshur/dagger/pkg/tracing"
+)
+
+// Plan is what a sync would change in the Composer environment.
+type Plan struct {
+	// Running is the expanded running list the plan was made from.
+	Running RunningList
+	// Deployed are the DAGs currently in the environment.
+	Deployed map[string]bool
+	// Stop maps DAGs to remove to their path relative to the GCS dags folder.
+	Stop map[string]string
+	// Start maps DAGs to deploy to their path relative to the local dags folder.
+	Start map[string]string
+	// Restart maps the deployed DAGs in Start whose file changed to the path
+	// of their deployed file. Their file is replaced in place, keeping their
+	// history.
+	Restart map[string]string
+	// Unchanged are deployed DAGs whose file matches the local one.
+	Unchanged map[string]bool
+	// Pause and Unpause are unchanged DAGs whose pause state differs from
+	// the desired state in the running list.
+	Pause   map[string]bool
+	Unpause map[string]bool
+	// Results maps every DAG Apply touched to its outcome.
+	Results map[string]string
+	// ImportErrors are the deployed DAGs the scheduler failed to import.
+	ImportErrors map[string]*DagImportError
+}
+
+// record sets the outcome of an action on dag, or the error it failed with.
+func (p *Plan) record(dag, outcome string, err error) {
+	if p.Results == nil {
+		p.Results = make(map[string]string)
+	}
+	switch {
+	case errors.Is(err, ErrDrainSkipped):
+		outcome = "skipped: " + err.Error()
+	case err != nil:
+		outcome = "failed: " + err.Error()
+	default:
+		metrics.Dag(outcome)
+	}
+	p.Results[dag] = outcome
+}
+
+// unnestPaths takes the single path of each dag from FindDagFiles results.
+func unnestPaths(pathLists map[string][]string) map[string]string {
+	paths := make(map[string]string)
+	for k, v := range pathLists {
+		if len(v) > 0 {
+			paths[k] = v[0]
+		}
+	}
+	return paths
+}
+
+// Plan compares the running list with the DAGs deployed in the environment
+// and the local DAG files with the deployed ones.
+func (c *ComposerEnv) Plan(filename string) (_ *Plan, err error) {
+	defer metrics.Phase("plan", time.Now())
+	runningList, err := ReadRunningDags(filename, c.Env, c.LocalDagsDir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read running dags list %v: %v", filename, err)
+	}
+	c.DagSpecs = runningList
+	traced, span := c.span("Plan")
+	defer func() { tracing.End(span, err) }()
+	dagsToRun := runningList.IDs()
+	planLog := c.phaseLog("plan")
+	logDagList(planLog.WithField("file", filename), "read DAGs to run", dagsToRun)
+
+	listing, listSpan := traced.span("ListRunningDags")
+	runningDags, pausedDags, err := listing.GetDeployedDags()
+	tracing.End(listSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list dags in composer environment: %v", err)
+	}
+	dagsToStop := DagListDiff(runningDags, dagsToRun)
+	dagsToStart := DagListDiff(dagsToRun, runningDags)
+	dagsSame := DagListIntersect(runningDags, dagsToRun)
+	logDagList(planLog, "DAGs same", dagsSame)
+
+	// find the deployed files of both stopped and unchanged dags in one pull
+	deployed := make(map[string]bool)
+	for k := range dagsToStop {
+		deployed[k] = true
+	}
+	for k := range dagsSame {
+		deployed[k] = true
+	}
+	resolving, resolveSpan := traced.span("ResolveDagFiles")
+	gcsPathLists, err := FindDagFilesInGcsPrefix(c.DagBucketPrefix, deployed)
+	if err != nil {
+		tracing.End(resolveSpan, err)
+		return nil, fmt.Errorf("error finding deployed dags: %v", err)
+	}
+	gcsPaths := unnestPaths(gcsPathLists)
+
+	dagPathsSame := make(map[string]string)
+	for k := range dagsSame {
+		if p, ok := gcsPaths[k]; ok {
+			dagPathsSame[k] = p
+		}
+	}
+	restartDags, err := resolving.getRestartDags(dagPathsSame)
+	if err != nil {
+		tracing.End(resolveSpan, err)
+		return nil, err
+	}
+	for k := range restartDags {
+		dagsToStart[k] = true
+		delete(dagsSame, k)
+	}
+
+	logDagList(planLog, "DAGs to stop", dagsToStop)
+	logDagList(planLog, "DAGs to start", dagsToStart)
+
+	dagPathsToStop := make(map[string]string)
+	for k := range dagsToStop {
+		if p, ok := gcsPaths[k]; ok {
+			dagPathsToStop[k] = p
+		}
+	}
+	dagPathListsToStart, err := FindDagFilesInLocalTree(c.LocalDagsDir, dagsToStart)
+	tracing.End(resolveSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("error finding dags to start: %v", err)
+	}
+
+	// pause state is reconciled separately so pausing keeps the DAG's history
+	pause := make(map[string]bool)
+	unpause := make(map[string]bool)
+	for dag := range dagsSame {
+		want := runningList.Paused(dag)
+		if paused, known := pausedDags[dag]; known && paused == want {
+			continue
+		}
+		if want {
+			pause[dag] = true
+		} else {
+			unpause[dag] = true
+		}
+	}
+
+	return &Plan{
+		Running:   runningList,
+		Deployed:  runningDags,
+		Stop:      dagPathsToStop,
+		Start:     unnestPaths(dagPathListsToStart),
+		Restart:   restartDags,
+		Unchanged: dagsSame,
+		Pause:     pause,
+		Unpause:   unpause,
+	}, nil
+}
+
+// Apply makes the environment match the plan: absent DAGs are deleted, new
+// DAG files deployed, changed ones replaced in place and the pause state of
+// unchanged DAGs reconciled. Deployed files that fail to import fail the
+// sync and are removed again, or restored to their previous version for
+// restarted DAGs, when RollbackOnImportError is set.
+func (c *ComposerEnv) Apply(p *Plan) error {
+	if err := c.ApplySafety(p.Stop, p.Start); err != nil {
+		return err
+	}
+	c.DagSpecs = p.Running
+	if err := c.traceContext().Err(); err != nil {
+		return fmt.Errorf("sync interrupted before changing DAGs: %v", err)
+	}
+	stopStarted := time.Now()
+	stopErrs := c.StopDags(p.Stop)
+	metrics.Phase("stop", stopStarted)
+	if err := c.traceContext().Err(); err != nil {
+		for dag := range p.Stop {
+			p.record(dag, "stopped", stopErrs[dag])
+		}
+		return fmt.Errorf("sync interrupted after stopping DAGs, none started: %v", err)
+	}
+
+	start := make(map[string]string)
+	restart := make(map[string]string)
+	for dag, relPath := range p.Start {
+		if _, ok := p.Restart[dag]; ok {
+			restart[dag] = relPath
+		} else {
+			start[dag] = relPath
+		}
+	}
+	startStarted := time.Now()
+	startErrs := c.StartDags(c.LocalDagsDir, start)
+	restartErrs, replaced := c.restartDags(c.LocalDagsDir, restart, p.Restart)
+	for dag, err := range restartErrs {
+		startErrs[dag] = err
+	}
+	metrics.Phase("start", startStarted)
+
+	// restarts skipped while draining kept their deployed file
+	deployed := make(map[string]string)
+	for dag, relPath := range p.Start {
+		if !errors.Is(startErrs[dag], ErrDrainSkipped) {
+			deployed[dag] = relPath
+		}
+	}
+	var failed []string
+	importErrs, err := c.collectImportErrors(deployed, startErrs)
+	if err != nil {
+		failed = append(failed, err.Error())
+	}
+	if len(importErrs) > 0 {
+		p.ImportErrors = importErrs
+		c.reportImportErrors(importErrs)
+		if c.RollbackOnImportError {
+			if err := c.rollbackImportErrors(deployed, replaced, importErrs); err != nil {
+				failed = append(failed, err.Error())
+			}
+		}
+	}
+	for dag, ie := range importErrs {
+		if _, ok := startErrs[dag]; !ok {
+			startErrs[dag] = ie
+		}
+	}
+	for _, errs := range []map[string]error{stopErrs, startErrs} {
+		for dag, err := range errs {
+			if !errors.Is(err, ErrDrainSkipped) {
+				failed = append(failed, fmt.Sprintf("%s: %v", dag, err))
+			}
+		}
+	}
+	for dag := range p.Stop {
+		p.record(dag, "stopped", stopErrs[dag])
+	}
+	for dag := range start {
+		p.record(dag, "started", startErrs[dag])
+	}
+	for dag := range restart {
+		p.record(dag, "restarted", startErrs[dag])
+	}
+	if err := c.ReconcilePauseStates(p); err != nil {
+		failed = append(failed, err.Error())
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("sync failed for %d DAGs:\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// ReconcilePauseStates pauses and unpauses unchanged DAGs to match their
+// desired state without touching their files.
+func (c *ComposerEnv) ReconcilePauseStates(p *Plan) error {
+	var failed []string
+	for _, dag := range sortedKeys(p.Pause) {
+		c.dagLog(dag).Info("pausing dag")
+		out, err := c.pauseDag(dag)
+		if err != nil {
+			c.dagLog(dag).WithField("output", string(out)).Error("error pausing dag")
+			failed = append(failed, dag)
+			err = fmt.Errorf("error pausing dag: %s", strings.TrimSpace(string(out)))
+		}
+		p.record(dag, "paused", err)
+	}
+	for _, dag := range sortedKeys(p.Unpause) {
+		c.dagLog(dag).Info("unpausing dag")
+		out, err := c.unpauseDag(dag)
+		if err != nil {
+			c.dagLog(dag).WithField("output", string(out)).Error("error unpausing dag")
+			failed = append(failed, dag)
+			err = fmt.Errorf("error unpausing dag: %s", strings.TrimSpace(string(out)))
+		}
+		p.record(dag, "unpaused", err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("couldn't reconcile pause state of %v", failed)
+	}
+	return nil
+}
+
+// sortedKeys returns the DAG IDs of a set in order.
+func sortedKeys(dags map[string]bool) []string {
+	ids := make([]string, 0, len(dags))
+	for id := range dags {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// describe formats a plan line for dag with its path and, for DAGs selected
+// by a pattern, the running list entry that selected it.
+func (p *Plan) describe(dag, path string) string {
+	line := "  " + dag
+	if path != "" {
+		line += "\t" + path
+	}
+	if sel := p.Running[dag].Selector; sel != "" && sel != dag {
+		line += fmt.Sprintf("\t(selected by %s)", sel)
+	}
+	return line
+}
+
+// Print writes a human readable summary of the plan, including which running
+// list entry selected each DAG.
+func (p *Plan) Print(w io.Writer) {
+	var start, stop, restart, unchanged []string
+	pause, unpause := sortedKeys(p.Pause), sortedKeys(p.Unpause)
+	for dag := range p.Start {
+		if _, ok := p.Restart[dag]; !ok {
+			start = append(start, dag)
+		}
+	}
+	for dag := range p.Stop {
+		stop = append(stop, dag)
+	}
+	for dag := range p.Restart {
+		restart = append(restart, dag)
+	}
+	for dag := range p.Unchanged {
+		unchanged = append(unchanged, dag)
+	}
+
+	sections := []struct {
+		title string
+		dags  []string
+		paths map[string]string
+	}{
+		{"DAGs to start", start, p.Start},
+		{"DAGs to restart", restart, p.Start},
+		{"DAGs to stop", stop, p.Stop},
+		{"DAGs to pause", pause, nil},
+		{"DAGs to unpause", unpause, nil},
+		{"DAGs unchanged", unchanged, nil},
+	}
+	for _, s := range sections {
+		sort.Strings(s.dags)
+		fmt.Fprintf(w, "%s (%d):\n", s.title, len(s.dags))
+		for _, dag := range s.dags {
+			fmt.Fprintln(w, p.describe(dag, s.paths[dag]))
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inshur/dagger/pkg/store"
+)
+
+func TestPlanPrint(t *testing.T) {
+	p := &Plan{
+		Running: RunningList{
+			"finance_daily": {ID: "finance_daily", Selector: "finance_*"},
+			"weekly_report": {ID: "weekly_report", Selector: "weekly_report"},
+		},
+		Start:     map[string]string{"finance_daily": "finance_daily.py"},
+		Stop:      map[string]string{"old_dag": "old_dag.py"},
+		Restart:   map[string]string{},
+		Unchanged: map[string]bool{"weekly_report": true},
+		Pause:     map[string]bool{"weekly_report": true},
+	}
+	var buf bytes.Buffer
+	p.Print(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"DAGs to start (1):\n  finance_daily\tfinance_daily.py\t(selected by finance_*)\n",
+		"DAGs to stop (1):\n  old_dag\told_dag.py\n",
+		"DAGs to pause (1):\n  weekly_report\n",
+		"DAGs unchanged (1):\n  weekly_report\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected plan output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+const dagsListOutput = `dag_id             | filepath              | owner   | paused
+===================+=======================+=========+=======
+airflow_monitoring | airflow_monitoring.py | airflow | False
+finance_daily      | finance_daily.py      | finance | True
+weekly_report      | reports/weekly.py     | airflow | False
+`
+
+func TestParseDagPauseStates(t *testing.T) {
+	running, err := parseListDagsOuput([]byte(dagsListOutput))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(running, map[string]bool{"finance_daily": true, "weekly_report": true}) {
+		t.Errorf("unexpected running dags: %v", running)
+	}
+	paused := parseDagPauseStates([]byte(dagsListOutput))
+	if !reflect.DeepEqual(paused, map[string]bool{"finance_daily": true, "weekly_report": false}) {
+		t.Errorf("unexpected pause states: %v", paused)
+	}
+	if _, err := parseListDagsOuput([]byte("Error: no such environment\n")); err == nil {
+		t.Errorf("expected an error for output without separators")
+	}
+	if paused := parseDagPauseStates([]byte("dag_id | filepath\n=======+=====\nfoo | foo.py\n")); len(paused) != 0 {
+		t.Errorf("expected no pause states without a paused column, got %v", paused)
+	}
+}
+
+func TestReconcilePauseStates(t *testing.T) {
+	runner := newFakeRunner()
+	c := ComposerEnv{Runner: runner}
+	p := &Plan{
+		Pause:   map[string]bool{"finance_daily": true},
+		Unpause: map[string]bool{"weekly_report": true},
+	}
+	if err := c.ReconcilePauseStates(p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []string{"dags pause finance_daily", "dags unpause weekly_report"}
+	if !reflect.DeepEqual(runner.calls, want) {
+		t.Errorf("expected %v, got %v", want, runner.calls)
+	}
+	for _, call := range runner.calls {
+		if strings.Contains(call, "delete") {
+			t.Errorf("pausing must not delete dags: %v", runner.calls)
+		}
+	}
+}
+
+func TestRunningListIDsExcludesAbsent(t *testing.T) {
+	list := RunningList{
+		"finance_daily": {ID: "finance_daily", State: DagPaused},
+		"old_export":    {ID: "old_export", State: DagAbsent},
+	}
+	if ids := list.IDs(); !reflect.DeepEqual(ids, map[string]bool{"finance_daily": true}) {
+		t.Errorf("unexpected ids: %v", ids)
+	}
+}
+
+func TestApplyRestartsInPlace(t *testing.T) {
+	local := t.TempDir()
+	bucket := store.Dir{Root: t.TempDir()}
+	if err := (store.Dir{Root: local}).Write("dags/reports/weekly_report.py", []byte("v2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"dags/reports/weekly_report.py": "v1", "dags/moved_export.py": "v1"} {
+		if err := bucket.Write(name, []byte(data), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := (store.Dir{Root: local}).Write("dags/exports/moved_export.py", []byte("v1"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := newFakeRunner()
+	runner.outputs["dags details weekly_report -o json"] = `{"dag_id": "weekly_report", "fileloc": "/home/airflow/gcs/dags/reports/weekly_report.py", "last_parsed_time": "2999-01-01T00:00:00+00:00"}`
+	runner.outputs["dags details moved_export -o json"] = `{"dag_id": "moved_export", "fileloc": "/home/airflow/gcs/dags/exports/moved_export.py", "last_parsed_time": "2999-01-01T00:00:00+00:00"}`
+	c := ComposerEnv{Runner: runner, Objects: bucket, LocalDagsDir: filepath.Join(local, "dags")}
+	p := &Plan{
+		Running: RunningList{
+			"weekly_report": {ID: "weekly_report", State: DagActive},
+			"moved_export":  {ID: "moved_export", State: DagPaused},
+		},
+		Start:   map[string]string{"weekly_report": "reports/weekly_report.py", "moved_export": "exports/moved_export.py"},
+		Restart: map[string]string{"weekly_report": "reports/weekly_report.py", "moved_export": "moved_export.py"},
+	}
+	if err := c.Apply(p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, call := range runner.calls {
+		if strings.HasPrefix(call, "dags delete") {
+			t.Errorf("restarts must not delete dags: %v", runner.calls)
+		}
+	}
+	for _, cmd := range []string{"dags pause weekly_report", "dags unpause weekly_report", "dags pause moved_export"} {
+		if !runner.called(cmd) {
+			t.Errorf("expected %q, calls: %v", cmd, runner.calls)
+		}
+	}
+	if runner.called("dags unpause moved_export") {
+		t.Errorf("moved_export must stay paused")
+	}
+	if got, _ := bucket.Read("dags/reports/weekly_report.py"); string(got) != "v2" {
+		t.Errorf("expected the changed file to be replaced, got %q", got)
+	}
+	if _, err := bucket.Read("dags/moved_export.py"); err == nil {
+		t.Errorf("expected the old file of a moved dag to be removed")
+	}
+	if want := map[string]string{"weekly_report": "restarted", "moved_export": "restarted"}; !reflect.DeepEqual(p.Results, want) {
+		t.Errorf("expected results %v, got %v", want, p.Results)
+	}
+}
+
+func TestApplyRollsBackRestartedImportError(t *testing.T) {
+	local := t.TempDir()
+	bucket := store.Dir{Root: t.TempDir()}
+	if err := (store.Dir{Root: local}).Write("dags/reports/weekly_report.py", []byte("broken"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := bucket.Write("dags/reports/weekly_report.py", []byte("v1"), map[string]string{"dagger-commit": "0123456"}); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := newFakeRunner()
+	runner.outputs["dags list-import-errors -o json"] = `[{"filepath": "/home/airflow/gcs/dags/reports/weekly_report.py", "error": "SyntaxError: invalid syntax"}]`
+	c := ComposerEnv{
+		Runner:                runner,
+		Objects:               bucket,
+		LocalDagsDir:          filepath.Join(local, "dags"),
+		RollbackOnImportError: true,
+		DeployTimeout:         time.Millisecond,
+		DeployPollInterval:    time.Millisecond,
+	}
+	p := &Plan{
+		Running: RunningList{"weekly_report": {ID: "weekly_report", State: DagActive}},
+		Start:   map[string]string{"weekly_report": "reports/weekly_report.py"},
+		Restart: map[string]string{"weekly_report": "reports/weekly_report.py"},
+	}
+	err := c.Apply(p)
+	if err == nil || !strings.Contains(err.Error(), "SyntaxError") {
+		t.Fatalf("expected the import error to fail the sync, got %v", err)
+	}
+	if _, ok := p.ImportErrors["weekly_report"]; !ok {
+		t.Errorf("expected an import error for weekly_report, got %v", p.ImportErrors)
+	}
+	if got, err := bucket.Read("dags/reports/weekly_report.py"); err != nil || string(got) != "v1" {
+		t.Errorf("expected the previous file to be restored, got %q, %v", got, err)
+	}
+	if obj, _ := bucket.Stat("dags/reports/weekly_report.py"); obj.Metadata["dagger-commit"] != "0123456" {
+		t.Errorf("expected the previous metadata to be restored, got %v", obj.Metadata)
+	}
+	if !runner.called("dags unpause weekly_report") {
+		t.Errorf("expected the restored dag to be unpaused, calls: %v", runner.calls)
+	}
+	for _, call := range runner.calls {
+		if strings.HasPrefix(call, "dags delete") {
+			t.Errorf("rolling back a restart must not delete the dag: %v", runner.calls)
+		}
+	}
+}
+
+func TestApplyRollsBackPausedRestartImportError(t *testing.T) {
+	local := t.TempDir()
+	bucket := store.Dir{Root: t.TempDir()}
+	if err := (store.Dir{Root: local}).Write("dags/finance_daily.py", []byte("broken"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := bucket.Write("dags/finance_daily.py", []byte("v1"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := newFakeRunner()
+	// the scheduler only reports the import error once it parsed the new file
+	runner.effects["dags details finance_daily -o json"] = map[string]string{
+		"dags list-import-errors -o json": `[{"filepath": "/home/airflow/gcs/dags/finance_daily.py", "error": "SyntaxError: invalid syntax"}]`,
+	}
+	c := ComposerEnv{
+		Runner:                runner,
+		Objects:               bucket,
+		LocalDagsDir:          filepath.Join(local, "dags"),
+		RollbackOnImportError: true,
+		DeployTimeout:         time.Millisecond,
+		DeployPollInterval:    time.Millisecond,
+	}
+	p := &Plan{
+		Running: RunningList{"finance_daily": {ID: "finance_daily", State: DagPaused}},
+		Start:   map[string]string{"finance_daily": "finance_daily.py"},
+		Restart: map[string]string{"finance_daily": "finance_daily.py"},
+	}
+	err := c.Apply(p)
+	if err == nil || !strings.Contains(err.Error(), "SyntaxError") {
+		t.Fatalf("expected the import error of a paused dag to fail the sync, got %v", err)
+	}
+	if got, err := bucket.Read("dags/finance_daily.py"); err != nil || string(got) != "v1" {
+		t.Errorf("expected the previous file to be restored, got %q, %v", got, err)
+	}
+	if runner.called("dags unpause finance_daily") {
+		t.Errorf("the restored dag must stay paused, calls: %v", runner.calls)
+	}
+}
+
+func TestApplyStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runner := newFakeRunner()
+	c := ComposerEnv{Runner: runner, Context: ctx}
+	p := &Plan{Start: map[string]string{"finance_daily": "finance_daily.py"}}
+	if err := c.Apply(p); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Errorf("expected a cancelled sync to be interrupted, got %v", err)
+	}
+	if len(runner.calls) != 0 {
+		t.Errorf("expected no commands once cancelled, got %v", runner.calls)
+	}
+}
+
+func TestStopDagsRecordsEveryError(t *testing.T) {
+	runner := newFakeRunner()
+	dags := map[string]string{}
+	for _, dag := range []string{"finance_daily", "weekly_report", "old_export", "billing_daily"} {
+		dags[dag] = dag + ".py"
+		runner.errs["dags pause "+dag] = errors.New("exit status 1")
+	}
+	c := ComposerEnv{Runner: runner, Concurrency: 2}
+	if errs := c.StopDags(dags); len(errs) != len(dags) {
+		t.Errorf("expected every failed stop to be returned, got %v", errs)
+	}
+}
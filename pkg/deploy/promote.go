@@ -0,0 +1,105 @@
+package deploy
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/inshur/dagger/pkg/gcshasher"
+	"github.com/inshur/dagger/pkg/logging"
+)
+
+// PromoteResult describes one DAG file promoted between environments.
+type PromoteResult struct {
+	Dag     string
+	RelPath string
+	// Copied is false when the target already had identical content.
+	Copied bool
+}
+
+// bucket returns the environment bucket name from the DAG bucket prefix.
+func (c *ComposerEnv) bucket() string {
+	return strings.TrimSuffix(strings.TrimPrefix(c.DagBucketPrefix, "gs://"), "/dags")
+}
+
+// dagObjectURL returns the gs:// URL of a file relative to the dags folder.
+func (c *ComposerEnv) dagObjectURL(relPath string) (string, error) {
+	gcs, err := url.Parse(c.DagBucketPrefix)
+	if err != nil {
+		return "", fmt.Errorf("error parsing dags prefix %v", err)
+	}
+	gcs.Path = path.Join(gcs.Path, relPath)
+	return gcs.String(), nil
+}
+
+// Promote copies the DAG files deployed in c for dags to the same paths in
+// target, verifying with md5 hashes that target then serves exactly the same
+// content. DAGs that were not running in target yet are unpaused once parsed.
+// No more DAGs are promoted once target's Context is cancelled.
+func (c *ComposerEnv) Promote(target *ComposerEnv, dags map[string]bool) ([]PromoteResult, error) {
+	logDagList(c.log().WithField("target", target.Name), "promoting DAGs", dags)
+	pathLists, err := FindDagFilesInGcsPrefix(c.DagBucketPrefix, dags)
+	if err != nil {
+		return nil, fmt.Errorf("error finding dags in %s: %v", c.Name, err)
+	}
+	var missing []string
+	for dag := range dags {
+		if len(pathLists[dag]) == 0 {
+			missing = append(missing, dag)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("DAGs not deployed in %s: %v", c.Name, missing)
+	}
+
+	running, err := target.GetRunningDags()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]PromoteResult, 0, len(pathLists))
+	for dag, paths := range pathLists {
+		if err := target.traceContext().Err(); err != nil {
+			return results, fmt.Errorf("promotion interrupted: %v", err)
+		}
+		relPath := paths[0]
+		src, err := c.dagObjectURL(relPath)
+		if err != nil {
+			return results, err
+		}
+		dst, err := target.dagObjectURL(relPath)
+		if err != nil {
+			return results, err
+		}
+		result := PromoteResult{Dag: dag, RelPath: relPath}
+		if eq, err := gcshasher.GCSEqGCS(src, dst); err == nil && eq {
+			c.dagLog(dag).WithField(logging.Object, dst).Infof("already matches %s, skipping", src)
+			results = append(results, result)
+			continue
+		}
+
+		object := fmt.Sprintf("dags/%s", relPath)
+		copiedAt := time.Now()
+		if err := CopyFile(c.bucket(), object, target.bucket(), object); err != nil {
+			return results, err
+		}
+		eq, err := gcshasher.GCSEqGCS(src, dst)
+		if err != nil {
+			return results, fmt.Errorf("error verifying promoted %s: %v", dst, err)
+		}
+		if !eq {
+			return results, fmt.Errorf("promoted %s does not match %s", dst, src)
+		}
+		result.Copied = true
+		results = append(results, result)
+
+		if !running[dag] {
+			if err := target.waitForDeploy(dag, relPath, copiedAt); err != nil {
+				return results, fmt.Errorf("error unpausing promoted dag %s: %v", dag, err)
+			}
+		}
+	}
+	return results, nil
+}
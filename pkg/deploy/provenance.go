@@ -0,0 +1,111 @@
+package deploy
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Provenance identifies who deployed what from where.
+type Provenance struct {
+	Commit  string `json:"commit,omitempty"`
+	Repo    string `json:"repo,omitempty"`
+	User    string `json:"user,omitempty"`
+	Version string `json:"version,omitempty"`
+	// RunURL links to the CI run that deployed, where its plan was printed.
+	RunURL string `json:"run_url,omitempty"`
+}
+
+// firstEnv returns the first non empty environment variable of names.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// git runs a git command in dir and returns its trimmed output, or an empty
+// string when dir isn't a git checkout.
+func git(dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// DetectProvenance reads the commit, repository and user of a deploy from
+// CI environment variables, falling back to the git checkout in dir and the
+// local user.
+func DetectProvenance(dir, version string) Provenance {
+	p := Provenance{
+		Commit:  firstEnv("DAGGER_COMMIT", "GITHUB_SHA", "CI_COMMIT_SHA"),
+		Repo:    firstEnv("DAGGER_REPO", "CI_PROJECT_URL"),
+		User:    firstEnv("DAGGER_USER", "GITHUB_ACTOR", "GITLAB_USER_LOGIN", "USER"),
+		Version: version,
+		RunURL:  firstEnv("DAGGER_RUN_URL", "CI_JOB_URL"),
+	}
+	if p.Repo == "" && os.Getenv("GITHUB_REPOSITORY") != "" {
+		p.Repo = firstEnv("GITHUB_SERVER_URL") + "/" + os.Getenv("GITHUB_REPOSITORY")
+		p.Repo = strings.TrimPrefix(p.Repo, "/")
+	}
+	if p.RunURL == "" && os.Getenv("GITHUB_RUN_ID") != "" && strings.HasPrefix(p.Repo, "http") {
+		p.RunURL = p.Repo + "/actions/runs/" + os.Getenv("GITHUB_RUN_ID")
+	}
+	if p.Commit == "" {
+		p.Commit = git(dir, "rev-parse", "HEAD")
+	}
+	if p.Repo == "" {
+		p.Repo = git(dir, "config", "--get", "remote.origin.url")
+	}
+	return p
+}
+
+// Custom metadata keys set on uploaded objects.
+const (
+	MetadataCommit    = "dagger-commit"
+	MetadataRepo      = "dagger-repo"
+	MetadataVersion   = "dagger-version"
+	MetadataDeployID  = "dagger-deploy-id"
+	MetadataLocalPath = "dagger-local-path"
+)
+
+// provenanceMetadata returns the custom metadata identifying the current
+// deploy, leaving out unknown values.
+func (c *ComposerEnv) provenanceMetadata() map[string]string {
+	meta := make(map[string]string)
+	for k, v := range map[string]string{
+		MetadataCommit:   c.Provenance.Commit,
+		MetadataRepo:     c.Provenance.Repo,
+		MetadataVersion:  c.Provenance.Version,
+		MetadataDeployID: c.DeployID,
+	} {
+		if v != "" {
+			meta[k] = v
+		}
+	}
+	return meta
+}
+
+// withLocalPath copies metadata adding the local path an object is uploaded
+// from, relative to the working directory when possible.
+func withLocalPath(metadata map[string]string, localPath string) map[string]string {
+	meta := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		meta[k] = v
+	}
+	wd, wdErr := os.Getwd()
+	abs, absErr := filepath.Abs(localPath)
+	if wdErr == nil && absErr == nil {
+		if rel, err := filepath.Rel(wd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+			localPath = rel
+		}
+	}
+	meta[MetadataLocalPath] = filepath.ToSlash(localPath)
+	return meta
+}
@@ -0,0 +1,200 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/inshur/dagger/pkg/logging"
+)
+
+// Defaults used when DeployTimeout and DeployPollInterval are not set.
+const (
+	defaultDeployTimeout      = 10 * time.Minute
+	defaultDeployPollInterval = 15 * time.Second
+)
+
+// DagImportError is an import error Airflow reported for a deployed file.
+type DagImportError struct {
+	Dag       string
+	File      string
+	Traceback string
+}
+
+func (e *DagImportError) Error() string {
+	return fmt.Sprintf("dag %v failed to import from %v:\n%s", e.Dag, e.File, e.Traceback)
+}
+
+// dagDetails are the fields of `dags details -o json` used to tell whether
+// the scheduler has parsed an uploaded file.
+type dagDetails struct {
+	DagID          string `json:"dag_id"`
+	Fileloc        string `json:"fileloc"`
+	LastParsedTime string `json:"last_parsed_time"`
+}
+
+type importError struct {
+	Filepath string `json:"filepath"`
+	Error    string `json:"error"`
+	// Timestamp is when the scheduler hit the error, when reported.
+	Timestamp string `json:"timestamp"`
+}
+
+// reportedSince tells whether the error was hit after t. known is false when
+// the error carries no timestamp, so it may predate t.
+func (ie importError) reportedSince(t time.Time) (since, known bool) {
+	at, err := time.Parse(time.RFC3339Nano, ie.Timestamp)
+	if err != nil {
+		return false, false
+	}
+	return at.After(t), true
+}
+
+// matchesDagFile reports whether a path in the environment, such as a
+// fileloc, is the file at relPath in the dags folder.
+func matchesDagFile(envPath, relPath string) bool {
+	return strings.HasSuffix(envPath, "/dags/"+relPath)
+}
+
+// dagNotFound reports airflow output saying the DAG isn't known.
+func dagNotFound(out []byte) bool {
+	msg := strings.ToLower(string(out))
+	return strings.Contains(msg, "not found") || strings.Contains(msg, "could not be found")
+}
+
+// getDagDetails returns the scheduler's view of dag, or nil when the DAG is
+// not known yet.
+func (c *ComposerEnv) getDagDetails(dag string) (*dagDetails, error) {
+	out, err := c.Run("dags", "details", dag, "-o", "json")
+	if err != nil {
+		if dagNotFound(out) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting details of %v: %s", dag, out)
+	}
+	data := extractJSON(out)
+	if data == nil {
+		return nil, nil
+	}
+	var list []dagDetails
+	if err := json.Unmarshal(data, &list); err != nil {
+		var single dagDetails
+		if err := json.Unmarshal(data, &single); err != nil {
+			return nil, fmt.Errorf("error decoding details of %v: %v", dag, err)
+		}
+		list = append(list, single)
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return &list[0], nil
+}
+
+// listImportErrors returns the import errors the scheduler currently reports.
+func (c *ComposerEnv) listImportErrors() ([]importError, error) {
+	out, err := c.Run("dags", "list-import-errors", "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("error listing import errors: %s", out)
+	}
+	var errs []importError
+	if data := extractJSON(out); data != nil {
+		if err := json.Unmarshal(data, &errs); err != nil {
+			return nil, fmt.Errorf("error decoding import errors: %v", err)
+		}
+	}
+	return errs, nil
+}
+
+// parsedSince reports whether the scheduler parsed dag from relPath after
+// uploadedAt.
+func (d *dagDetails) parsedSince(relPath string, uploadedAt time.Time) bool {
+	if d == nil || !matchesDagFile(d.Fileloc, relPath) {
+		return false
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, d.LastParsedTime)
+	return err == nil && parsed.After(uploadedAt)
+}
+
+// ComposerEnv.waitForDeploy waits for dag to be parsed from the file uploaded
+// to relPath at uploadedAt, then unpauses it.
+func (c *ComposerEnv) waitForDeploy(dag, relPath string, uploadedAt time.Time) error {
+	if err := c.waitForParse(dag, relPath, uploadedAt); err != nil {
+		return err
+	}
+	c.dagLog(dag).Info("dag parsed, unpausing")
+	out, err := c.unpauseDag(dag)
+	if err != nil {
+		return fmt.Errorf("error unpausing dag %v: %s", dag, out)
+	}
+	return nil
+}
+
+// ComposerEnv.waitForPaused waits for dag to be parsed from the file uploaded
+// to relPath at uploadedAt, then pauses it. The scheduler may create a new
+// DAG unpaused, so one the running list keeps paused is only paused once
+// airflow knows about it.
+func (c *ComposerEnv) waitForPaused(dag, relPath string, uploadedAt time.Time) error {
+	if err := c.waitForParse(dag, relPath, uploadedAt); err != nil {
+		return err
+	}
+	c.dagLog(dag).Info("dag parsed, leaving it paused")
+	out, err := c.pauseDag(dag)
+	if err != nil {
+		return fmt.Errorf("error pausing dag %v: %s", dag, out)
+	}
+	return nil
+}
+
+// ComposerEnv.waitForParse polls the scheduler until dag has been parsed from
+// the file uploaded to relPath at uploadedAt. An import error hit after the
+// upload fails the deploy with its traceback. Errors without a timestamp may
+// come from the previous file, so they only fail the deploy if the file
+// still isn't parsed at the deadline.
+func (c *ComposerEnv) waitForParse(dag, relPath string, uploadedAt time.Time) error {
+	timeout := c.DeployTimeout
+	if timeout <= 0 {
+		timeout = defaultDeployTimeout
+	}
+	interval := c.DeployPollInterval
+	if interval <= 0 {
+		interval = defaultDeployPollInterval
+	}
+	deadline := time.Now().Add(timeout)
+	var unconfirmed *DagImportError
+	for {
+		details, err := c.getDagDetails(dag)
+		if err != nil {
+			return err
+		}
+		if details.parsedSince(relPath, uploadedAt) {
+			return nil
+		}
+		importErrs, err := c.listImportErrors()
+		if err != nil {
+			return err
+		}
+		unconfirmed = nil
+		for _, ie := range importErrs {
+			if !matchesDagFile(ie.Filepath, relPath) {
+				continue
+			}
+			importErr := &DagImportError{Dag: dag, File: ie.Filepath, Traceback: ie.Error}
+			since, known := ie.reportedSince(uploadedAt)
+			if since {
+				return importErr
+			}
+			if !known {
+				unconfirmed = importErr
+			}
+		}
+		if time.Now().Add(interval).After(deadline) {
+			if unconfirmed != nil {
+				return unconfirmed
+			}
+			return fmt.Errorf("dag %v was not parsed from %v within %v", dag, relPath, timeout)
+		}
+		c.dagLog(dag).WithField(logging.Object, "dags/"+relPath).Info("waiting for dag to be parsed")
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,105 @@
+package deploy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const dagDetailsOutput = `[{"dag_id": "finance_daily", "fileloc": "/home/airflow/gcs/dags/finance/finance_daily.py", "is_paused": true, "last_parsed_time": "2021-06-01T12:00:05.123456+00:00"}]`
+
+func TestWaitForDeploy(t *testing.T) {
+	uploadedAt := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	runner := newFakeRunner()
+	runner.outputs["dags details finance_daily -o json"] = dagDetailsOutput
+	c := ComposerEnv{Runner: runner, DeployTimeout: time.Millisecond, DeployPollInterval: time.Millisecond}
+	if err := c.waitForDeploy("finance_daily", "finance/finance_daily.py", uploadedAt); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !runner.called("dags unpause finance_daily") {
+		t.Errorf("expected parsed dag to be unpaused, calls: %v", runner.calls)
+	}
+
+	// an error left by the previous file doesn't fail a parsed deploy
+	runner = newFakeRunner()
+	runner.outputs["dags details finance_daily -o json"] = dagDetailsOutput
+	runner.outputs["dags list-import-errors -o json"] = `[{"filepath": "/home/airflow/gcs/dags/finance/finance_daily.py", "error": "NameError", "timestamp": "2021-06-01T11:58:00+00:00"}]`
+	c.Runner = runner
+	if err := c.waitForDeploy("finance_daily", "finance/finance_daily.py", uploadedAt); err != nil {
+		t.Fatalf("unexpected error for a stale import error: %s", err)
+	}
+	if !runner.called("dags unpause finance_daily") {
+		t.Errorf("expected parsed dag to be unpaused, calls: %v", runner.calls)
+	}
+
+	// parsed before the upload, so the new file hasn't been picked up yet
+	// and only an error hit after the upload counts
+	for _, tc := range []struct {
+		timestamp string
+		want      string
+	}{
+		{`, "timestamp": "2021-06-01T11:58:00+00:00"`, "was not parsed"},
+		{`, "timestamp": "2021-06-01T12:01:30+00:00"`, "NameError"},
+		{"", "NameError"},
+	} {
+		runner = newFakeRunner()
+		runner.outputs["dags details finance_daily -o json"] = dagDetailsOutput
+		runner.outputs["dags list-import-errors -o json"] = `[{"filepath": "/home/airflow/gcs/dags/finance/finance_daily.py", "error": "Traceback (most recent call last):\nNameError: name 'DAG' is not defined"` + tc.timestamp + `}]`
+		c.Runner = runner
+		err := c.waitForDeploy("finance_daily", "finance/finance_daily.py", uploadedAt.Add(time.Minute))
+		var importErr *DagImportError
+		if tc.want == "NameError" && (!errors.As(err, &importErr) || !strings.Contains(importErr.Traceback, "NameError")) {
+			t.Errorf("expected import error with traceback for %q, got %v", tc.timestamp, err)
+		}
+		if tc.want != "NameError" && (errors.As(err, &importErr) || err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("expected a timeout for a stale import error, got %v", err)
+		}
+		if runner.called("dags unpause finance_daily") {
+			t.Errorf("dag that wasn't parsed must not be unpaused")
+		}
+	}
+
+	runner = newFakeRunner()
+	runner.outputs["dags details finance_daily -o json"] = dagDetailsOutput
+	runner.outputs["dags list-import-errors -o json"] = "[]"
+	c.Runner = runner
+	err := c.waitForDeploy("finance_daily", "reports/finance_daily.py", uploadedAt)
+	if err == nil || !strings.Contains(err.Error(), "was not parsed") {
+		t.Errorf("expected timeout for a fileloc from another path, got %v", err)
+	}
+
+	// a DAG the scheduler doesn't know yet is waited for
+	runner = newFakeRunner()
+	runner.outputs["dags details finance_daily -o json"] = "airflow.exceptions.AirflowException: Dag 'finance_daily' could not be found; either it does not exist or it failed to parse."
+	runner.errs["dags details finance_daily -o json"] = errors.New("exit status 1")
+	c.Runner = runner
+	err = c.waitForDeploy("finance_daily", "finance/finance_daily.py", uploadedAt)
+	if err == nil || !strings.Contains(err.Error(), "was not parsed") {
+		t.Errorf("expected timeout for an unknown dag, got %v", err)
+	}
+
+	// a failing CLI is reported instead of waited out
+	runner = newFakeRunner()
+	runner.outputs["dags details finance_daily -o json"] = "ERROR: (gcloud.composer.environments.run) PERMISSION_DENIED"
+	runner.errs["dags details finance_daily -o json"] = errors.New("exit status 1")
+	c.Runner = runner
+	err = c.waitForDeploy("finance_daily", "finance/finance_daily.py", uploadedAt)
+	if err == nil || !strings.Contains(err.Error(), "PERMISSION_DENIED") {
+		t.Errorf("expected the runner error, got %v", err)
+	}
+}
+
+func TestCheckImportErrors(t *testing.T) {
+	runner := newFakeRunner()
+	runner.outputs["dags list-import-errors -o json"] = `[{"filepath": "/home/airflow/gcs/dags/finance/finance_daily.py", "error": "SyntaxError: invalid syntax"}, {"filepath": "/home/airflow/gcs/dags/legacy.py", "error": "ImportError"}]`
+	c := ComposerEnv{Runner: runner}
+	found, err := c.CheckImportErrors(map[string]string{"finance_daily": "finance/finance_daily.py", "weekly_report": "reports/weekly_report.py"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(found) != 1 || !strings.Contains(found["finance_daily"].Traceback, "SyntaxError") {
+		t.Errorf("expected only the deployed finance_daily error, got %+v", found)
+	}
+}
@@ -0,0 +1,48 @@
+package deploy
+
+import (
+	"strings"
+	"sync"
+)
+
+// fakeRunner is a Runner returning canned output keyed by the joined command
+// line, e.g. "connections test api". Unknown commands succeed with no output.
+// The outputs in effects are only returned once their key command has run,
+// e.g. an import error the scheduler reports once asked about the DAG.
+type fakeRunner struct {
+	mu      sync.Mutex
+	outputs map[string]string
+	errs    map[string]error
+	effects map[string]map[string]string
+	calls   []string
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{
+		outputs: make(map[string]string),
+		errs:    make(map[string]error),
+		effects: make(map[string]map[string]string),
+	}
+}
+
+func (r *fakeRunner) Run(subCmd string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{subCmd}, args...), " ")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, cmd)
+	for later, out := range r.effects[cmd] {
+		r.outputs[later] = out
+	}
+	return []byte(r.outputs[cmd]), r.errs[cmd]
+}
+
+func (r *fakeRunner) called(cmd string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.calls {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Desired states of a DAG in the running list. DAGs missing from the list
+// are absent: their file is deleted along with their history.
+const (
+	DagActive = "active"
+	DagPaused = "paused"
+	DagAbsent = "absent"
+)
+
+// DagSpec is one entry of the running DAGs list.
+type DagSpec struct {
+	ID string `yaml:"id"`
+	// State is DagActive, DagPaused or DagAbsent, DagActive when empty.
+	State string `yaml:"state"`
+	// Trigger starts a DAG run once the DAG has been deployed.
+	Trigger bool   `yaml:"trigger"`
+	Owner   string `yaml:"owner"`
+	// Environments restricts the entry to the named environments, it applies
+	// everywhere when empty.
+	Environments []string `yaml:"environments"`
+	// Line is the line of the entry in a txt list, 0 for YAML lists.
+	Line int `yaml:"-"`
+	// Selector is the list entry, an ID or pattern, that selected the DAG.
+	Selector string `yaml:"-"`
+}
+
+// RunningList is the running DAGs list resolved for one environment, keyed
+// by DAG ID.
+type RunningList map[string]DagSpec
+
+// IDs returns the set of DAG IDs that should be deployed, excluding DAGs
+// explicitly listed as absent.
+func (l RunningList) IDs() map[string]bool {
+	ids := make(map[string]bool, len(l))
+	for id, spec := range l {
+		if spec.State != DagAbsent {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// Paused reports whether the DAG should be deployed paused.
+func (l RunningList) Paused(dag string) bool {
+	return l[dag].State == DagPaused
+}
+
+// runningListYAML is the YAML running DAGs list format.
+type runningListYAML struct {
+	Dags []DagSpec `yaml:"dags"`
+}
+
+// isYAMLList reports whether filename uses the YAML running list format.
+func isYAMLList(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// ReadRunningDags reads a running DAGs list in txt or YAML format, picked by
+// the file extension, keeping the entries that apply to env. Glob and tag:
+// selectors are expanded against the DAGs found in dagsRoot. The whole file
+// is validated first and all problems are returned as ValidationErrors.
+func ReadRunningDags(filename, env, dagsRoot string) (RunningList, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var specs []DagSpec
+	var errs ValidationErrors
+	if isYAMLList(filename) {
+		specs, errs = parseRunningDagsYAML(filename, data)
+	} else {
+		specs, errs = parseRunningDagsTxt(filename, data)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	var applicable []DagSpec
+	hasSelectors := false
+	for _, spec := range specs {
+		if !spec.appliesTo(env) {
+			continue
+		}
+		if spec.State == "" {
+			spec.State = DagActive
+		}
+		hasSelectors = hasSelectors || isSelector(spec.ID)
+		applicable = append(applicable, spec)
+	}
+	var local map[string][]LocalDag
+	if hasSelectors && dagsRoot != "" {
+		if local, err = DiscoverLocalDags(dagsRoot); err != nil {
+			return nil, err
+		}
+	}
+	return expandSelectors(applicable, local)
+}
+
+// ValidateRunningDagsFile checks a running DAGs list without resolving it for
+// an environment.
+func ValidateRunningDagsFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return ValidationErrors{{File: filename, Message: fmt.Sprintf("couldn't read file: %v", err)}}
+	}
+	var errs ValidationErrors
+	if isYAMLList(filename) {
+		_, errs = parseRunningDagsYAML(filename, data)
+	} else {
+		_, errs = parseRunningDagsTxt(filename, data)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (s DagSpec) appliesTo(env string) bool {
+	if len(s.Environments) == 0 {
+		return true
+	}
+	for _, e := range s.Environments {
+		if e == env {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps reports whether two entries can apply to the same environment.
+func (s DagSpec) overlaps(o DagSpec) bool {
+	if len(s.Environments) == 0 || len(o.Environments) == 0 {
+		return true
+	}
+	for _, e := range s.Environments {
+		if o.appliesTo(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRunningDagsTxt reads one DAG ID per line, ignoring blank lines,
+// whitespace and # comments.
+func parseRunningDagsTxt(filename string, data []byte) ([]DagSpec, ValidationErrors) {
+	var specs []DagSpec
+	var errs ValidationErrors
+	seen := make(map[string]int)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		id := sc.Text()
+		if i := strings.Index(id, "#"); i >= 0 {
+			id = id[:i]
+		}
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		switch {
+		case !validSelector.MatchString(id):
+			errs = append(errs, ValidationError{File: filename, Line: line, Field: id, Message: "invalid DAG ID or selector"})
+		case seen[id] > 0:
+			errs = append(errs, ValidationError{File: filename, Line: line, Field: id,
+				Message: fmt.Sprintf("duplicate DAG, first listed on line %d", seen[id])})
+		default:
+			seen[id] = line
+			specs = append(specs, DagSpec{ID: id, Line: line})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		errs = append(errs, ValidationError{File: filename, Message: err.Error()})
+	}
+	return specs, errs
+}
+
+// parseRunningDagsYAML reads the YAML list format, rejecting unknown fields.
+func parseRunningDagsYAML(filename string, data []byte) ([]DagSpec, ValidationErrors) {
+	var list runningListYAML
+	if err := yaml.UnmarshalStrict(data, &list); err != nil {
+		return nil, ValidationErrors{{File: filename, Message: err.Error()}}
+	}
+	var errs ValidationErrors
+	add := func(i int, field, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{
+			File:    filename,
+			Field:   fmt.Sprintf("dags[%d].%s", i, field),
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+	for i, spec := range list.Dags {
+		switch {
+		case spec.ID == "":
+			add(i, "id", "is required")
+		case !validSelector.MatchString(spec.ID):
+			add(i, "id", "invalid DAG ID or selector %q", spec.ID)
+		}
+		switch spec.State {
+		case "", DagActive, DagPaused, DagAbsent:
+		default:
+			add(i, "state", "must be %q, %q or %q, got %q", DagActive, DagPaused, DagAbsent, spec.State)
+		}
+		for _, e := range spec.Environments {
+			if strings.TrimSpace(e) == "" {
+				add(i, "environments", "must not contain empty names")
+			}
+		}
+		for j := 0; j < i; j++ {
+			if list.Dags[j].ID == spec.ID && spec.ID != "" && list.Dags[j].overlaps(spec) {
+				add(i, "id", "duplicate DAG %q, first listed at dags[%d]", spec.ID, j)
+				break
+			}
+		}
+	}
+	return list.Dags, errs
+}
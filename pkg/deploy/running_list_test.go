@@ -0,0 +1,75 @@
+package deploy
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRunningDagsTxt(t *testing.T) {
+	_, err := ReadRunningDagsTxt(filepath.Join("testdata", "running_dags_duplicate.txt"))
+	if err == nil {
+		t.Fatalf("expected duplicate report_weekly to be rejected")
+	}
+	errs := err.(ValidationErrors)
+	if len(errs) != 1 || errs[0].Line != 6 || !strings.Contains(errs[0].Message, "line 4") {
+		t.Errorf("unexpected errors: %s", err)
+	}
+
+	specs, errs := parseRunningDagsTxt("running_dags.txt", []byte("billing_daily\n\n# reporting\nreport_weekly   # finance\n  export_hourly  \n"))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+	var ids []string
+	for _, s := range specs {
+		ids = append(ids, s.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"billing_daily", "report_weekly", "export_hourly"}) {
+		t.Errorf("unexpected DAG IDs: %q", ids)
+	}
+}
+
+func TestReadRunningDagsYAML(t *testing.T) {
+	list, err := ReadRunningDags(filepath.Join("testdata", "running_dags.yaml"), "prod", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(list.IDs(), map[string]bool{"billing_daily": true, "report_weekly": true, "export_hourly": true}) {
+		t.Errorf("unexpected DAGs for prod: %v", list.IDs())
+	}
+	if list["billing_daily"].State != DagActive || list["billing_daily"].Owner != "finance" {
+		t.Errorf("unexpected billing_daily spec: %+v", list["billing_daily"])
+	}
+	if !list.Paused("report_weekly") || list.Paused("export_hourly") || !list["export_hourly"].Trigger {
+		t.Errorf("unexpected prod specs: %+v", list)
+	}
+
+	list, err = ReadRunningDags(filepath.Join("testdata", "running_dags.yaml"), "dev", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !list.Paused("export_hourly") || list["export_hourly"].Trigger {
+		t.Errorf("expected dev export_hourly to be paused: %+v", list["export_hourly"])
+	}
+}
+
+func TestValidateRunningDagsFile(t *testing.T) {
+	err := ValidateRunningDagsFile(filepath.Join("testdata", "running_dags_invalid.yaml"))
+	if err == nil {
+		t.Fatalf("expected validation errors")
+	}
+	var fields []string
+	for _, e := range err.(ValidationErrors) {
+		fields = append(fields, e.Field)
+	}
+	want := []string{"dags[0].id", "dags[1].id", "dags[1].state", "dags[3].id"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("expected errors on %v, got %s", want, err)
+	}
+
+	err = ValidateRunningDagsFile(filepath.Join("testdata", "running_dags_unknown.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "paused") {
+		t.Errorf("expected unknown field to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package deploy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/inshur/dagger/pkg/logging"
+)
+
+// tagSelectorPrefix marks a running list entry selecting DAGs by tag.
+const tagSelectorPrefix = "tag:"
+
+var (
+	validSelector = regexp.MustCompile(`^!?([A-Za-z0-9_.\-*?\[\]]+|tag:[A-Za-z0-9_.\- ]+)$`)
+	tagsArgument  = regexp.MustCompile(`\btags\s*=\s*\[([^\]]*)\]`)
+	quotedString  = regexp.MustCompile(`["']([^"']*)["']`)
+)
+
+// LocalDag is a DAG definition found in the local DAGs folder. Its ID is the
+// file name, following the dag_id == file name convention.
+type LocalDag struct {
+	ID   string
+	Path string
+	Tags []string
+}
+
+// isSelector reports whether a running list entry is a pattern rather than a
+// single DAG ID.
+func isSelector(entry string) bool {
+	return strings.HasPrefix(entry, "!") || strings.HasPrefix(entry, tagSelectorPrefix) ||
+		strings.ContainsAny(entry, "*?[")
+}
+
+// DiscoverLocalDags lists the DAG files in dagsRoot. Like Airflow's safe mode
+// only .py files mentioning both "airflow" and "dag" are considered.
+func DiscoverLocalDags(dagsRoot string) (map[string][]LocalDag, error) {
+	dags := make(map[string][]LocalDag)
+	err := filepath.Walk(dagsRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == "__pycache__" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".py" {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		lower := strings.ToLower(string(content))
+		if !strings.Contains(lower, "airflow") || !strings.Contains(lower, "dag") {
+			return nil
+		}
+		relPath, err := filepath.Rel(dagsRoot, path)
+		if err != nil {
+			return err
+		}
+		id := strings.TrimSuffix(info.Name(), ".py")
+		dags[id] = append(dags[id], LocalDag{ID: id, Path: relPath, Tags: parseDagTags(content)})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error discovering dags in %v: %v", dagsRoot, err)
+	}
+	return dags, nil
+}
+
+// parseDagTags extracts the string literals of a `tags=[...]` argument.
+func parseDagTags(content []byte) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, m := range tagsArgument.FindAllSubmatch(content, -1) {
+		for _, q := range quotedString.FindAllSubmatch(m[1], -1) {
+			tag := string(q[1])
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// selectorMatches returns the discovered DAG IDs matching a selector with
+// any leading '!' removed.
+func selectorMatches(selector string, local map[string][]LocalDag) ([]string, error) {
+	var ids []string
+	if strings.HasPrefix(selector, tagSelectorPrefix) {
+		tag := strings.TrimPrefix(selector, tagSelectorPrefix)
+		for id, defs := range local {
+			for _, d := range defs {
+				if hasTag(d.Tags, tag) {
+					ids = append(ids, id)
+					break
+				}
+			}
+		}
+	} else {
+		for id := range local {
+			match, err := filepath.Match(selector, id)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", selector, err)
+			}
+			if match {
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// expandSelectors resolves running list entries in order: DAG IDs and
+// positive patterns add DAGs, '!' patterns remove DAGs selected so far. An
+// explicit DAG ID takes its settings from its own entry, otherwise the first
+// pattern selecting a DAG does. Each resulting spec records its Selector.
+func expandSelectors(specs []DagSpec, local map[string][]LocalDag) (RunningList, error) {
+	list := make(RunningList)
+	explicit := make(map[string]bool)
+	for _, spec := range specs {
+		if !isSelector(spec.ID) {
+			spec.Selector = spec.ID
+			list[spec.ID] = spec
+			explicit[spec.ID] = true
+			continue
+		}
+		if local == nil {
+			return nil, fmt.Errorf("selector %q needs a local dags folder to expand against", spec.ID)
+		}
+		negate := strings.HasPrefix(spec.ID, "!")
+		ids, err := selectorMatches(strings.TrimPrefix(spec.ID, "!"), local)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			logging.Log.Warnf("running list selector %q matched no DAGs", spec.ID)
+		}
+		for _, id := range ids {
+			switch {
+			case negate:
+				delete(list, id)
+				delete(explicit, id)
+			case explicit[id]:
+			default:
+				if _, ok := list[id]; ok {
+					continue
+				}
+				s := spec
+				s.ID, s.Selector = id, spec.ID
+				list[id] = s
+			}
+		}
+	}
+	return list, nil
+}
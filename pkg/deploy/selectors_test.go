@@ -0,0 +1,79 @@
+package deploy
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiscoverLocalDags(t *testing.T) {
+	local, err := DiscoverLocalDags(filepath.Join("testdata", "dags"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := local["helpers"]; ok {
+		t.Errorf("helpers.py has no DAG and should not be discovered")
+	}
+	weekly := local["weekly_report"]
+	if len(weekly) != 1 || weekly[0].Path != filepath.Join("reports", "weekly_report.py") {
+		t.Errorf("unexpected weekly_report: %+v", weekly)
+	}
+	if tags := local["finance_daily"][0].Tags; !reflect.DeepEqual(tags, []string{"finance", "reporting"}) {
+		t.Errorf("unexpected finance_daily tags: %v", tags)
+	}
+}
+
+func TestParseDagTags(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{`DAG("a", tags=["finance", 'reporting'])`, []string{"finance", "reporting"}},
+		{`DAG("a", tags = [ "finance" ])`, []string{"finance"}},
+		{`DAG("a", params={"extra_tags": []}, tags=["finance"])`, []string{"finance"}},
+		{`DAG("a", extra_tags=["finance"])`, nil},
+		{`DAG("a", default_args={"retries": 1})`, nil},
+	}
+	for _, tt := range tests {
+		if got := parseDagTags([]byte(tt.content)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected tags %v, got %v", tt.content, tt.want, got)
+		}
+	}
+}
+
+func TestExpandSelectors(t *testing.T) {
+	local, err := DiscoverLocalDags(filepath.Join("testdata", "dags"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	specs := []DagSpec{
+		{ID: "finance_*", State: DagPaused},
+		{ID: "!finance_legacy_*"},
+		{ID: "tag:reporting", State: DagActive},
+		{ID: "billing_daily", State: DagActive},
+	}
+	list, err := expandSelectors(specs, local)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := map[string]string{
+		"finance_daily": "finance_*",
+		"weekly_report": "tag:reporting",
+		"billing_daily": "billing_daily",
+	}
+	if len(list) != len(want) {
+		t.Errorf("expected %v, got %+v", want, list)
+	}
+	for id, sel := range want {
+		if list[id].Selector != sel {
+			t.Errorf("expected %s selected by %s, got %+v", id, sel, list[id])
+		}
+	}
+	if !list.Paused("finance_daily") {
+		t.Errorf("finance_daily should keep the settings of the first selector")
+	}
+
+	if _, err := expandSelectors(specs, nil); err == nil {
+		t.Errorf("expected an error expanding selectors without a dags folder")
+	}
+}
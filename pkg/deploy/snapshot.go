@@ -0,0 +1,429 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/inshur/dagger/pkg/store"
+)
+
+// historyPrefix is where deploy snapshots are kept in the history store.
+const historyPrefix = ".dagger/history/"
+
+// snapshotPrefixes are the environment bucket folders dagger writes to.
+var snapshotPrefixes = []string{"dags/", "plugins/", "data/"}
+
+// Snapshot records the bucket state and DAG pause states before a deploy.
+// Objects the deploy may overwrite or delete are copied into the snapshot so
+// they can be restored. Objects it leaves alone are only listed, their copy
+// is in the snapshot of the deploy that last changed them.
+type Snapshot struct {
+	ID          string    `json:"id"`
+	Environment string    `json:"environment"`
+	Created     time.Time `json:"created"`
+	// Objects lists every object under dags/, plugins/ and data/.
+	Objects []store.Object `json:"objects"`
+	// Saved are the names of the objects copied into the snapshot: the files
+	// not matching the local checkout and the files of DAGs missing from the
+	// running list.
+	Saved []string `json:"saved"`
+	// Paused maps every deployed DAG to its pause state.
+	Paused map[string]bool `json:"paused"`
+}
+
+// NewDeployID returns the ID of a deploy started at t. Snapshot suffixes it
+// when another deploy started in the same second.
+func NewDeployID(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// objects returns the store of the environment bucket.
+func (c *ComposerEnv) objects() store.Store {
+	if c.Objects != nil {
+		return c.Objects
+	}
+	return store.GCS{Bucket: c.bucket()}
+}
+
+// history returns the store snapshots are kept in, the environment bucket
+// unless configured otherwise.
+func (c *ComposerEnv) history() store.Store {
+	if c.History != nil {
+		return c.History
+	}
+	return c.objects()
+}
+
+// localPath maps a bucket object to the local file it is synced from.
+func (c *ComposerEnv) localPath(name string) string {
+	dirs := map[string]string{"dags/": c.LocalDagsDir, "plugins/": c.LocalPluginsDir, "data/": c.LocalDataDir}
+	for prefix, dir := range dirs {
+		if strings.HasPrefix(name, prefix) && dir != "" {
+			return filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(name, prefix)))
+		}
+	}
+	return ""
+}
+
+func snapshotObject(id, name string) string {
+	return historyPrefix + id + "/objects/" + name
+}
+
+func manifestObject(id string) string {
+	return historyPrefix + id + "/manifest.json"
+}
+
+// claimDeployID reserves DeployID in the history store by creating the
+// snapshot manifest, suffixing the ID while another deploy holds it.
+func (c *ComposerEnv) claimDeployID(snap *Snapshot) error {
+	base := c.DeployID
+	for n := 2; ; n++ {
+		snap.ID = c.DeployID
+		manifest, err := json.MarshalIndent(snap, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = c.history().WriteIf(manifestObject(snap.ID), 0, manifest, nil)
+		if err != store.ErrPrecondition {
+			return err
+		}
+		c.DeployID = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// unchangedBySync reports whether a sync leaves obj alone: it matches the
+// local checkout and, for DAG files of deployed DAGs, the DAG is listed.
+func (c *ComposerEnv) unchangedBySync(obj store.Object, running, listed map[string]bool) bool {
+	local, err := ioutil.ReadFile(c.localPath(obj.Name))
+	if err != nil || store.MD5(local) != obj.MD5 {
+		return false
+	}
+	rel := strings.TrimPrefix(obj.Name, "dags/")
+	if rel == obj.Name {
+		return true
+	}
+	dag := strings.TrimSuffix(path.Base(rel), ".py")
+	return !running[dag] || listed[dag]
+}
+
+// Snapshot records the state of the environment before a deploy under
+// .dagger/history/<DeployID>/, setting DeployID if empty. Set DagSpecs first
+// so DAG files the sync won't touch aren't copied again. Snapshots beyond
+// SnapshotRetention are pruned.
+func (c *ComposerEnv) Snapshot() (*Snapshot, error) {
+	if c.DeployID == "" {
+		c.DeployID = NewDeployID(time.Now())
+	}
+	running, paused, err := c.GetDeployedDags()
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{ID: c.DeployID, Environment: c.Name, Created: time.Now().UTC(), Paused: make(map[string]bool)}
+	for dag := range running {
+		snap.Paused[dag] = paused[dag]
+	}
+	if err := c.claimDeployID(snap); err != nil {
+		return nil, fmt.Errorf("error writing snapshot manifest: %v", err)
+	}
+	// every deployed DAG may be deleted when the running list isn't known
+	listed := c.DagSpecs.IDs()
+	for _, prefix := range snapshotPrefixes {
+		objs, err := c.objects().List(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			snap.Objects = append(snap.Objects, obj)
+			if c.unchangedBySync(obj, running, listed) {
+				continue
+			}
+			data, err := c.objects().Read(obj.Name)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %v: %v", obj.Name, err)
+			}
+			if err := c.history().Write(snapshotObject(snap.ID, obj.Name), data, obj.Metadata); err != nil {
+				return nil, fmt.Errorf("error saving %v: %v", obj.Name, err)
+			}
+			snap.Saved = append(snap.Saved, obj.Name)
+		}
+	}
+	manifest, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := c.history().Write(manifestObject(snap.ID), manifest, nil); err != nil {
+		return nil, fmt.Errorf("error writing snapshot manifest: %v", err)
+	}
+	c.phaseLog("snapshot").Infof("saved snapshot %v: %d objects, %d copied", snap.ID, len(snap.Objects), len(snap.Saved))
+	if err := c.pruneSnapshots(); err != nil {
+		c.phaseLog("snapshot").WithError(err).Warn("error pruning old snapshots")
+	}
+	return snap, nil
+}
+
+// pruneSnapshots deletes all but the latest SnapshotRetention snapshots,
+// keeping every snapshot when it is zero. Copies that a kept snapshot still
+// relies on are moved into the oldest kept snapshot listing them first.
+func (c *ComposerEnv) pruneSnapshots() error {
+	ids, err := c.ListSnapshots()
+	if err != nil || c.SnapshotRetention <= 0 || len(ids) <= c.SnapshotRetention {
+		return err
+	}
+	drop := ids[:len(ids)-c.SnapshotRetention]
+	var kept []*Snapshot
+	for _, id := range ids[len(drop):] {
+		snap, err := c.ReadSnapshot(id)
+		if err != nil {
+			return err
+		}
+		kept = append(kept, snap)
+	}
+	changed := make(map[*Snapshot]bool)
+	for _, id := range drop {
+		old, err := c.ReadSnapshot(id)
+		if err != nil {
+			return err
+		}
+		for _, name := range old.Saved {
+			for _, obj := range old.Objects {
+				if obj.Name != name {
+					continue
+				}
+				into := adoptingSnapshot(kept, obj)
+				if into == nil {
+					continue
+				}
+				data, err := c.history().Read(snapshotObject(id, name))
+				if err != nil {
+					return err
+				}
+				if err := c.history().Write(snapshotObject(into.ID, name), data, obj.Metadata); err != nil {
+					return err
+				}
+				into.Saved = append(into.Saved, name)
+				changed[into] = true
+			}
+		}
+	}
+	for snap := range changed {
+		sort.Strings(snap.Saved)
+		manifest, err := json.MarshalIndent(snap, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := c.history().Write(manifestObject(snap.ID), manifest, nil); err != nil {
+			return err
+		}
+	}
+	for _, id := range drop {
+		objs, err := c.history().List(historyPrefix + id + "/")
+		if err != nil {
+			return err
+		}
+		// the manifest goes last so a half pruned snapshot stays listed
+		for i := len(objs) - 1; i >= 0; i-- {
+			if objs[i].Name == manifestObject(id) {
+				objs = append(objs[:i], objs[i+1:]...)
+			}
+		}
+		for _, obj := range append(objs, store.Object{Name: manifestObject(id)}) {
+			if err := c.history().Delete(obj.Name); err != nil {
+				return err
+			}
+		}
+		c.phaseLog("snapshot").Infof("pruned snapshot %v", id)
+	}
+	return nil
+}
+
+// adoptingSnapshot returns the oldest of kept listing obj without a copy of
+// it, or nil when a kept snapshot already has one or none lists it.
+func adoptingSnapshot(kept []*Snapshot, obj store.Object) *Snapshot {
+	var into *Snapshot
+	for _, snap := range kept {
+		lists, saved := false, false
+		for _, o := range snap.Objects {
+			lists = lists || (o.Name == obj.Name && o.MD5 == obj.MD5)
+		}
+		for _, name := range snap.Saved {
+			saved = saved || name == obj.Name
+		}
+		if lists && saved {
+			return nil
+		}
+		if lists && into == nil {
+			into = snap
+		}
+	}
+	return into
+}
+
+// ListSnapshots returns the IDs of the recorded snapshots, oldest first.
+func (c *ComposerEnv) ListSnapshots() ([]string, error) {
+	objs, err := c.history().List(historyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, obj := range objs {
+		if id := strings.TrimPrefix(obj.Name, historyPrefix); strings.HasSuffix(id, "/manifest.json") {
+			ids = append(ids, strings.TrimSuffix(id, "/manifest.json"))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// ReadSnapshot reads the manifest of a snapshot.
+func (c *ComposerEnv) ReadSnapshot(id string) (*Snapshot, error) {
+	data, err := c.history().Read(manifestObject(id))
+	if err == store.ErrNotExist {
+		return nil, fmt.Errorf("no snapshot %v", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("error reading snapshot %v: %v", id, err)
+	}
+	return &snap, nil
+}
+
+// savedCopy finds a copy of obj in any snapshot, preferring snap. Objects
+// that matched the local checkout when snap was taken were only copied by a
+// later snapshot, once a deploy changed them.
+func (c *ComposerEnv) savedCopy(snap *Snapshot, obj store.Object) ([]byte, error) {
+	ids, err := c.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(ids, func(i, j int) bool { return ids[i] == snap.ID })
+	for _, id := range ids {
+		other := snap
+		if id != snap.ID {
+			if other, err = c.ReadSnapshot(id); err != nil {
+				return nil, err
+			}
+		}
+		for _, name := range other.Saved {
+			if name != obj.Name {
+				continue
+			}
+			for _, o := range other.Objects {
+				if o.Name == obj.Name && o.MD5 == obj.MD5 {
+					return c.history().Read(snapshotObject(id, obj.Name))
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("no saved copy of %v", obj.Name)
+}
+
+// Rollback restores the bucket to the state recorded by snapshot id: changed
+// and deleted objects are restored, objects added since are deleted, and
+// DAG pause states are reapplied.
+func (c *ComposerEnv) Rollback(id string) error {
+	snap, err := c.ReadSnapshot(id)
+	if err != nil {
+		return err
+	}
+	running, _, err := c.GetDeployedDags()
+	if err != nil {
+		return err
+	}
+	current := make(map[string]store.Object)
+	for _, prefix := range snapshotPrefixes {
+		objs, err := c.objects().List(prefix)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			current[obj.Name] = obj
+		}
+	}
+
+	var failed []string
+	restored := make(map[string]string)
+	restoredAt := time.Now()
+	wanted := make(map[string]bool)
+	for _, obj := range snap.Objects {
+		wanted[obj.Name] = true
+		if cur, ok := current[obj.Name]; ok && cur.MD5 == obj.MD5 {
+			continue
+		}
+		data, err := c.savedCopy(snap, obj)
+		if err == nil {
+			err = c.objects().Write(obj.Name, data, obj.Metadata)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", obj.Name, err))
+			continue
+		}
+		c.objectLog(obj.Name).Info("restored")
+		if rel := strings.TrimPrefix(obj.Name, "dags/"); rel != obj.Name {
+			restored[strings.TrimSuffix(path.Base(rel), ".py")] = rel
+		}
+	}
+	var deletedDags []string
+	for name := range current {
+		if wanted[name] {
+			continue
+		}
+		if err := c.objects().Delete(name); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		c.objectLog(name).Info("deleted")
+		if rel := strings.TrimPrefix(name, "dags/"); rel != name {
+			if dag := strings.TrimSuffix(path.Base(rel), ".py"); running[dag] {
+				if _, ok := snap.Paused[dag]; !ok {
+					deletedDags = append(deletedDags, dag)
+				}
+			}
+		}
+	}
+
+	for _, dag := range deletedDags {
+		if out, err := c.deleteDag(dag); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: error deleting dag: %s", dag, out))
+		}
+	}
+	for _, dag := range sortedKeys(snap.Paused) {
+		if err := c.restorePauseState(dag, snap.Paused[dag], restored, restoredAt); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", dag, err))
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("rollback to %v incomplete:\n%s", id, strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// restorePauseState reapplies a DAG's pause state. Restored DAG files are
+// parsed as new, paused DAGs, so active ones are unpaused once parsed.
+func (c *ComposerEnv) restorePauseState(dag string, paused bool, restored map[string]string, restoredAt time.Time) error {
+	rel, wasRestored := restored[dag]
+	if paused {
+		// a restored DAG may not be parsed yet and is paused at creation
+		if out, err := c.pauseDag(dag); err != nil && !wasRestored {
+			return fmt.Errorf("error pausing dag: %s", bytes.TrimSpace(out))
+		}
+		return nil
+	}
+	if wasRestored {
+		return c.waitForDeploy(dag, rel, restoredAt)
+	}
+	if out, err := c.unpauseDag(dag); err != nil {
+		return fmt.Errorf("error unpausing dag: %s", bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package deploy
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/inshur/dagger/pkg/store"
+)
+
+func TestSnapshotRollback(t *testing.T) {
+	bucket := store.Dir{Root: t.TempDir()}
+	local := t.TempDir()
+	write := func(s store.Store, name, data string) {
+		if err := s.Write(name, []byte(data), nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	write(bucket, "dags/finance_daily.py", "v1")
+	write(bucket, "dags/reports/weekly_report.py", "weekly")
+	write(bucket, "plugins/hooks.py", "hooks v1")
+	write(bucket, "data/static.csv", "a,b")
+	localDir := store.Dir{Root: local}
+	write(localDir, "plugins/hooks.py", "hooks v2")
+	write(localDir, "data/static.csv", "a,b")
+
+	runner := newFakeRunner()
+	runner.outputs["dags list"] = `dag_id        | filepath                  | owner   | paused
+==============+===========================+=========+=======
+finance_daily | finance_daily.py          | finance | True
+weekly_report | reports/weekly_report.py  | airflow | False
+`
+	runner.outputs["dags details weekly_report -o json"] = `{"dag_id": "weekly_report", "fileloc": "/home/airflow/gcs/dags/reports/weekly_report.py", "last_parsed_time": "2999-01-01T00:00:00+00:00"}`
+	c := ComposerEnv{
+		Runner:          runner,
+		Objects:         bucket,
+		LocalPluginsDir: filepath.Join(local, "plugins"),
+		LocalDataDir:    filepath.Join(local, "data"),
+		DeployID:        NewDeployID(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)),
+	}
+	snap, err := c.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if snap.ID != "20210601T120000Z" {
+		t.Errorf("unexpected snapshot ID %v", snap.ID)
+	}
+	if want := []string{"dags/finance_daily.py", "dags/reports/weekly_report.py", "plugins/hooks.py"}; !reflect.DeepEqual(snap.Saved, want) {
+		t.Errorf("expected %v to be saved, got %v", want, snap.Saved)
+	}
+
+	// the deploy
+	write(bucket, "dags/finance_daily.py", "v2")
+	write(bucket, "dags/new_dag.py", "new")
+	write(bucket, "plugins/hooks.py", "hooks v2")
+	if err := bucket.Delete("dags/reports/weekly_report.py"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := c.Rollback(snap.ID); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got := func(name string) string {
+		data, _ := bucket.Read(name)
+		return string(data)
+	}
+	for name, want := range map[string]string{
+		"dags/finance_daily.py":         "v1",
+		"dags/reports/weekly_report.py": "weekly",
+		"plugins/hooks.py":              "hooks v1",
+		"data/static.csv":               "a,b",
+	} {
+		if got(name) != want {
+			t.Errorf("expected %v to be %q, got %q", name, want, got(name))
+		}
+	}
+	if _, err := bucket.Read("dags/new_dag.py"); err != store.ErrNotExist {
+		t.Errorf("expected dags/new_dag.py to be deleted")
+	}
+	for _, cmd := range []string{"dags pause finance_daily", "dags unpause weekly_report"} {
+		if !runner.called(cmd) {
+			t.Errorf("expected %q, calls: %v", cmd, runner.calls)
+		}
+	}
+
+	if ids, err := c.ListSnapshots(); err != nil || !reflect.DeepEqual(ids, []string{snap.ID}) {
+		t.Errorf("unexpected snapshots %v: %v", ids, err)
+	}
+}
+
+func TestSnapshotCopiesOnlyChanges(t *testing.T) {
+	bucket := store.Dir{Root: t.TempDir()}
+	history := store.Dir{Root: t.TempDir()}
+	local := t.TempDir()
+	for _, s := range []store.Store{bucket, store.Dir{Root: local}} {
+		for name, data := range map[string]string{"dags/finance_daily.py": "v1", "dags/old_export.py": "old"} {
+			if err := s.Write(name, []byte(data), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	runner := newFakeRunner()
+	runner.outputs["dags list"] = `dag_id        | filepath          | owner   | paused
+==============+===================+=========+=======
+finance_daily | finance_daily.py  | finance | False
+old_export    | old_export.py     | airflow | False
+`
+	c := ComposerEnv{
+		Runner:            runner,
+		Objects:           bucket,
+		History:           history,
+		LocalDagsDir:      filepath.Join(local, "dags"),
+		DagSpecs:          RunningList{"finance_daily": {ID: "finance_daily", State: DagActive}},
+		SnapshotRetention: 2,
+	}
+	var ids []string
+	for i := 0; i < 3; i++ {
+		c.DeployID = NewDeployID(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+		if i == 2 {
+			c.DeployID = NewDeployID(time.Date(2021, 6, 1, 13, 0, 0, 0, time.UTC))
+		}
+		snap, err := c.Snapshot()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ids = append(ids, snap.ID)
+		// only the file of the unlisted DAG the sync deletes is copied
+		if want := []string{"dags/old_export.py"}; i == 0 && !reflect.DeepEqual(snap.Saved, want) {
+			t.Errorf("expected %v to be saved, got %v", want, snap.Saved)
+		}
+		if i == 0 {
+			if err := bucket.Delete("dags/old_export.py"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if want := []string{"20210601T120000Z", "20210601T120000Z-2", "20210601T130000Z"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("expected same second deploys to get distinct IDs %v, got %v", want, ids)
+	}
+	kept, err := c.ListSnapshots()
+	if err != nil || !reflect.DeepEqual(kept, ids[1:]) {
+		t.Errorf("expected snapshots %v to be kept, got %v, %v", ids[1:], kept, err)
+	}
+	if _, err := history.Read(snapshotObject(ids[0], "dags/old_export.py")); err != store.ErrNotExist {
+		t.Errorf("expected the pruned snapshot's copies to be deleted, got %v", err)
+	}
+}
+
+func TestPruneKeepsNeededCopies(t *testing.T) {
+	bucket := store.Dir{Root: t.TempDir()}
+	local := store.Dir{Root: t.TempDir()}
+	write := func(s store.Store, name, data string) {
+		if err := s.Write(name, []byte(data), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(bucket, "plugins/hooks.py", "v1")
+	write(local, "plugins/hooks.py", "v2")
+	runner := newFakeRunner()
+	runner.outputs["dags list"] = "dag_id | filepath | owner | paused\n=======+==========+=======+=======\n"
+	c := ComposerEnv{
+		Runner:            runner,
+		Objects:           bucket,
+		LocalPluginsDir:   filepath.Join(local.Root, "plugins"),
+		DeployID:          "20210601T120000Z",
+		SnapshotRetention: 1,
+	}
+	if _, err := c.Snapshot(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the change is reverted locally, so the next snapshot relies on the
+	// copy of the first one
+	write(local, "plugins/hooks.py", "v1")
+	c.DeployID = "20210601T130000Z"
+	snap, err := c.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ids, _ := c.ListSnapshots(); !reflect.DeepEqual(ids, []string{snap.ID}) {
+		t.Fatalf("expected only %v to be kept, got %v", snap.ID, ids)
+	}
+	write(bucket, "plugins/hooks.py", "v3")
+	if err := c.Rollback(snap.ID); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, _ := bucket.Read("plugins/hooks.py"); string(got) != "v1" {
+		t.Errorf("expected the copy of the pruned snapshot to be restored, got %q", got)
+	}
+}
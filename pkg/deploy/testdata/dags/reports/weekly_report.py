@@ -0,0 +1,13 @@
+from datetime import datetime
+
+from airflow import DAG
+from airflow.operators.dummy import DummyOperator
+
+with DAG(
+    "weekly_report",
+    start_date=datetime(2021, 1, 1),
+    schedule_interval="@weekly",
+    catchup=False,
+    tags=["reporting"],
+) as dag:
+    DummyOperator(task_id="noop")
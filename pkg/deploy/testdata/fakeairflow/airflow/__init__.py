@@ -0,0 +1 @@
+"""A stand-in for the parts of Airflow dagbag.py uses, for tests."""
@@ -0,0 +1,32 @@
+import runpy
+import traceback
+
+
+class DAG:
+    def __init__(self, dag_id):
+        self.dag_id = dag_id
+        self.fileloc = None
+
+
+class DagBag:
+    def __init__(self, dag_folder=None, include_examples=True, collect_dags=True):
+        if collect_dags:
+            raise NotImplementedError("the stand-in only processes single files")
+        self.dags = {}
+        self.import_errors = {}
+
+    def process_file(self, filepath, only_if_updated=True):
+        try:
+            module = runpy.run_path(filepath)
+        except Exception:
+            self.import_errors[filepath] = traceback.format_exc()
+            return []
+        found = []
+        for value in module.values():
+            if isinstance(value, DAG):
+                if value.dag_id in self.dags:
+                    raise RuntimeError("duplicate dag_id " + value.dag_id)
+                value.fileloc = filepath
+                self.dags[value.dag_id] = value
+                found.append(value)
+        return found
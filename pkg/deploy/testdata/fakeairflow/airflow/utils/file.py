@@ -0,0 +1,8 @@
+import os
+
+
+def list_py_file_paths(directory, include_examples=None):
+    paths = []
+    for root, _, files in os.walk(directory):
+        paths.extend(os.path.join(root, f) for f in files if f.endswith(".py"))
+    return sorted(paths)
@@ -0,0 +1,28 @@
+package deploy
+
+import (
+	"context"
+
+	"github.com/inshur/dagger/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceContext returns the context the environment's spans are started
+// under.
+func (c *ComposerEnv) traceContext() context.Context {
+	if c.Context != nil {
+		return c.Context
+	}
+	return context.Background()
+}
+
+// span starts a span under the environment's context. It returns a copy of
+// the environment whose calls are traced as children of the span, so that
+// concurrent stops and starts each get their own parent.
+func (c *ComposerEnv) span(name string, attrs ...attribute.KeyValue) (*ComposerEnv, trace.Span) {
+	ctx, span := tracing.Tracer().Start(c.traceContext(), name, trace.WithAttributes(attrs...))
+	traced := *c
+	traced.Context = ctx
+	return &traced, span
+}
@@ -0,0 +1,56 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/inshur/dagger/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRunSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	ctx, root := tracing.Tracer().Start(context.Background(), "sync")
+	runner := newFakeRunner()
+	c := &ComposerEnv{Runner: runner, Context: ctx}
+	traced, span := c.span("stopDag", tracing.DagID.String("finance_daily"))
+	if _, err := traced.pauseDag("finance_daily"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	span.End()
+	root.End()
+	if c.Context != ctx {
+		t.Errorf("expected span to leave the environment's context alone")
+	}
+
+	ended := recorder.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(ended))
+	}
+	pause, stop := ended[0], ended[1]
+	if pause.Name() != "dags pause" {
+		t.Errorf("expected the runner span to name the subcommand, got %q", pause.Name())
+	}
+	if pause.Parent().SpanID() != stop.SpanContext().SpanID() {
+		t.Errorf("expected the runner span to be a child of stopDag")
+	}
+	if stop.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Errorf("expected stopDag to be a child of sync")
+	}
+	for _, a := range pause.Attributes() {
+		if a.Key == tracing.Command && a.Value.AsString() != "dags pause" {
+			t.Errorf("expected the command attribute to leave out the DAG ID, got %q", a.Value.AsString())
+		}
+	}
+	for _, a := range stop.Attributes() {
+		if a.Key == tracing.DagID && a.Value.AsString() != "finance_daily" {
+			t.Errorf("expected dag_id finance_daily, got %q", a.Value.AsString())
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// knownConnTypes are the Airflow connection types accepted in a connections
+// file, on top of those configured with conn_types for providers. URI
+// schemes are compared after replacing '-' with '_'.
+var knownConnTypes = map[string]bool{
+	"aws":                   true,
+	"azure_data_lake":       true,
+	"cassandra":             true,
+	"databricks":            true,
+	"docker":                true,
+	"elasticsearch":         true,
+	"email":                 true,
+	"fs":                    true,
+	"ftp":                   true,
+	"generic":               true,
+	"google_cloud_platform": true,
+	"gcpcloudsql":           true,
+	"grpc":                  true,
+	"hive_cli":              true,
+	"hive_metastore":        true,
+	"hiveserver2":           true,
+	"http":                  true,
+	"https":                 true,
+	"imap":                  true,
+	"jdbc":                  true,
+	"kubernetes":            true,
+	"mongo":                 true,
+	"mssql":                 true,
+	"mysql":                 true,
+	"odbc":                  true,
+	"oracle":                true,
+	"postgres":              true,
+	"presto":                true,
+	"redis":                 true,
+	"s3":                    true,
+	"samba":                 true,
+	"sftp":                  true,
+	"slack":                 true,
+	"smtp":                  true,
+	"snowflake":             true,
+	"spark":                 true,
+	"sqlite":                true,
+	"ssh":                   true,
+	"trino":                 true,
+	"vertica":               true,
+	"wasb":                  true,
+}
+
+// ValidationError is a single problem found in a config file.
+type ValidationError struct {
+	File    string
+	Line    int
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	pos := e.File
+	if e.Line > 0 {
+		pos = fmt.Sprintf("%s:%d", e.File, e.Line)
+	}
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", pos, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", pos, e.Field, e.Message)
+}
+
+// ValidationErrors collects every problem found in one or more config files.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// lineAt returns the 1-based line number of offset in data.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// nextValueOffset skips whitespace and separators following offset so the
+// returned position points at the start of the next JSON value.
+func nextValueOffset(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) {
+		switch data[offset] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
+
+// jsonField is an object member together with the line its key is on.
+type jsonField struct {
+	Key   string
+	Line  int
+	Value json.RawMessage
+}
+
+// decodeObjectFields reads one JSON object from dec keeping the order and
+// position of its members, which json.Unmarshal into a map would lose.
+func decodeObjectFields(dec *json.Decoder, data []byte) ([]jsonField, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return nil, fmt.Errorf("expected an object")
+	}
+	var fields []jsonField
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		line := lineAt(data, dec.InputOffset())
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		fields = append(fields, jsonField{Key: key, Line: line, Value: value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// decodeErrorLine extracts a line number from encoding/json errors.
+func decodeErrorLine(data []byte, err error) int {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return lineAt(data, syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		return lineAt(data, typeErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return lineAt(data, int64(len(data)))
+	}
+	return 1
+}
+
+// jsonString decodes raw as a string, reporting whether it was one.
+func jsonString(raw json.RawMessage) (string, bool) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+func isJSONNull(raw json.RawMessage) bool {
+	return string(bytes.TrimSpace(raw)) == "null"
+}
+
+// extraObject returns extra as a compact JSON object. extra may be an inline
+// object or a string holding encoded JSON, as produced by Airflow exports.
+func extraObject(extra json.RawMessage) (string, error) {
+	if len(extra) == 0 || isJSONNull(extra) {
+		return "", nil
+	}
+	if s, ok := jsonString(extra); ok {
+		if strings.TrimSpace(s) == "" {
+			return "", nil
+		}
+		extra = json.RawMessage(s)
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(extra, &obj); err != nil {
+		return "", fmt.Errorf("must be a JSON object: %v", err)
+	}
+	out, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// ValidateConnectionsFile checks an Airflow connections file against the
+// schema ImportConnections expects. It returns ValidationErrors describing
+// every problem found, or nil when the file is valid. connTypes are accepted
+// on top of the known connection types.
+func ValidateConnectionsFile(filename string, connTypes ...string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return ValidationErrors{{File: filename, Line: 0, Message: fmt.Sprintf("couldn't read file: %v", err)}}
+	}
+	errs := validateConnections(filename, data, connTypes)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateConnections(filename string, data []byte, connTypes []string) ValidationErrors {
+	known := make(map[string]bool, len(knownConnTypes)+len(connTypes))
+	for t := range knownConnTypes {
+		known[t] = true
+	}
+	for _, t := range connTypes {
+		known[strings.ReplaceAll(t, "-", "_")] = true
+	}
+	var errs ValidationErrors
+	add := func(line int, field, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{File: filename, Line: line, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		add(decodeErrorLine(data, err), "", "invalid JSON: %v", err)
+		return errs
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		add(1, "", "expected a JSON array of connections")
+		return errs
+	}
+
+	names := make(map[string]int)
+	for i := 0; dec.More(); i++ {
+		start := lineAt(data, nextValueOffset(data, dec.InputOffset()))
+		fields, err := decodeObjectFields(dec, data)
+		if err != nil {
+			add(decodeErrorLine(data, err), fmt.Sprintf("[%d]", i), "invalid connection: %v", err)
+			return errs
+		}
+		errs = append(errs, validateConnection(filename, i, start, fields, names, known)...)
+	}
+	if _, err := dec.Token(); err != nil {
+		add(decodeErrorLine(data, err), "", "invalid JSON: %v", err)
+	}
+	return errs
+}
+
+var connectionFields = map[string]bool{
+	"name": true, "uri": true, "type": true, "schema": true, "port": true,
+	"password": true, "login": true, "host": true, "extra": true, "test": true,
+}
+
+func validateConnection(filename string, idx, start int, fields []jsonField, names map[string]int, known map[string]bool) ValidationErrors {
+	var errs ValidationErrors
+	add := func(line int, field, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{
+			File:    filename,
+			Line:    line,
+			Field:   fmt.Sprintf("[%d].%s", idx, field),
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	values := make(map[string]string)
+	lines := make(map[string]int)
+	for _, f := range fields {
+		lines[f.Key] = f.Line
+		if !connectionFields[f.Key] {
+			add(f.Line, f.Key, "unknown field")
+			continue
+		}
+		if f.Key == "extra" {
+			if _, err := extraObject(f.Value); err != nil {
+				add(f.Line, f.Key, "%v", err)
+			}
+			continue
+		}
+		if isJSONNull(f.Value) {
+			continue
+		}
+		s, ok := jsonString(f.Value)
+		if !ok {
+			add(f.Line, f.Key, "must be a string")
+			continue
+		}
+		values[f.Key] = s
+	}
+
+	name := values["name"]
+	switch {
+	case name == "":
+		add(start, "name", "is required")
+	case names[name] > 0:
+		add(lines["name"], "name", "duplicate connection %q, first defined on line %d", name, names[name])
+	default:
+		names[name] = lines["name"]
+	}
+
+	connType := values["type"]
+	if connType != "" && !known[connType] {
+		add(lines["type"], "type", "unknown connection type %q, add it to conn_types if a provider defines it", connType)
+	}
+	if connType == "" && values["uri"] == "" {
+		add(start, "type", "one of type or uri is required")
+	}
+
+	switch values["test"] {
+	case "", ConnTestRequired, ConnTestOptional, ConnTestSkip:
+	default:
+		add(lines["test"], "test", "must be one of %q, %q or %q", ConnTestRequired, ConnTestOptional, ConnTestSkip)
+	}
+
+	if p := values["port"]; p != "" {
+		port, err := strconv.Atoi(p)
+		if err != nil || port < 1 || port > 65535 {
+			add(lines["port"], "port", "%q is not a valid port", p)
+		}
+	}
+
+	if raw := values["uri"]; raw != "" {
+		validateConnectionURI(raw, values, lines, known, add)
+	}
+	return errs
+}
+
+// validateConnectionURI checks that uri parses and agrees with any discrete
+// fields that were also set, since Airflow only honours one of them.
+func validateConnectionURI(raw string, values map[string]string, lines map[string]int, known map[string]bool,
+	add func(line int, field, format string, args ...interface{})) {
+	uri, err := url.Parse(raw)
+	if err != nil {
+		add(lines["uri"], "uri", "couldn't parse URI: %v", err)
+		return
+	}
+	if uri.Scheme == "" {
+		add(lines["uri"], "uri", "URI %q has no scheme", raw)
+		return
+	}
+	scheme := strings.ReplaceAll(uri.Scheme, "-", "_")
+	if !known[scheme] {
+		add(lines["uri"], "uri", "unknown connection type %q in URI scheme, add it to conn_types if a provider defines it", uri.Scheme)
+	}
+
+	password, _ := uri.User.Password()
+	fromURI := map[string]string{
+		"type":     scheme,
+		"host":     uri.Hostname(),
+		"port":     uri.Port(),
+		"login":    uri.User.Username(),
+		"password": password,
+		"schema":   strings.TrimPrefix(uri.Path, "/"),
+	}
+	keys := make([]string, 0, len(fromURI))
+	for k := range fromURI {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, set := values[k]
+		if !set || v == "" {
+			continue
+		}
+		if v != fromURI[k] {
+			shown := fromURI[k]
+			if k == "password" {
+				shown = "***"
+			}
+			add(lines[k], k, "does not match uri (uri has %q)", shown)
+		}
+	}
+}
+
+// ValidateVariablesFile checks an Airflow variables file is a JSON object
+// with unique, non-empty keys. It returns ValidationErrors describing every
+// problem found, or nil when the file is valid.
+func ValidateVariablesFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return ValidationErrors{{File: filename, Line: 0, Message: fmt.Sprintf("couldn't read file: %v", err)}}
+	}
+	errs := validateVariables(filename, data)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateVariables(filename string, data []byte) ValidationErrors {
+	var errs ValidationErrors
+	add := func(line int, field, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{File: filename, Line: line, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if len(bytes.TrimSpace(data)) == 0 || bytes.TrimSpace(data)[0] != '{' {
+		add(1, "", "expected a JSON object of variables")
+		return errs
+	}
+	fields, err := decodeObjectFields(dec, data)
+	if err != nil {
+		add(decodeErrorLine(data, err), "", "invalid JSON: %v", err)
+		return errs
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		add(lineAt(data, dec.InputOffset()), "", "unexpected data after variables object")
+	}
+
+	seen := make(map[string]int)
+	for _, f := range fields {
+		if strings.TrimSpace(f.Key) == "" {
+			add(f.Line, f.Key, "variable name must not be empty")
+			continue
+		}
+		if first, ok := seen[f.Key]; ok {
+			add(f.Line, f.Key, "duplicate variable, first defined on line %d", first)
+			continue
+		}
+		seen[f.Key] = f.Line
+	}
+	return errs
+}
+
+// Validate checks the variables, connections and running DAGs files
+// configured for the environment, returning all problems found across them.
+func (c *ComposerEnv) Validate() error {
+	var errs ValidationErrors
+	for _, f := range c.variablesFiles() {
+		if err := ValidateVariablesFile(f); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+	}
+	if c.ConnectionsFile != "" {
+		if err := ValidateConnectionsFile(c.ConnectionsFile, c.ConnTypes...); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+	}
+	if c.RunningDagsFile != "" {
+		if err := ValidateRunningDagsFile(c.RunningDagsFile); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package deploy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateConnectionsFile(t *testing.T) {
+	if err := ValidateConnectionsFile(filepath.Join("testdata", "connections_valid.json")); err != nil {
+		t.Errorf("expected valid connections file, got: %s", err)
+	}
+
+	err := ValidateConnectionsFile(filepath.Join("testdata", "connections_invalid.json"))
+	if err == nil {
+		t.Fatalf("expected validation errors for connections_invalid.json")
+	}
+	got := make(map[string]int)
+	for _, e := range err.(ValidationErrors) {
+		got[e.Field] = e.Line
+	}
+	want := map[string]int{
+		"[0].name":  2,
+		"[0].host":  4,
+		"[1].type":  8,
+		"[1].port":  9,
+		"[2].name":  12,
+		"[2].extra": 14,
+		"[2].prot":  15,
+		"[2].test":  16,
+	}
+	for field, line := range want {
+		if got[field] != line {
+			t.Errorf("expected error on %s at line %d, got line %d (all errors: %s)", field, line, got[field], err)
+		}
+	}
+}
+
+func TestValidateConnectionsProviderTypes(t *testing.T) {
+	data := []byte(`[{"name": "lake", "type": "delta_lake"}, {"name": "queue", "uri": "my-queue://broker:5672"}]`)
+	if errs := validateConnections("connections.json", data, nil); len(errs) != 2 {
+		t.Errorf("expected unknown connection types to be rejected, got: %v", errs)
+	}
+	if errs := validateConnections("connections.json", data, []string{"delta_lake", "my-queue"}); len(errs) > 0 {
+		t.Errorf("expected configured connection types to be accepted, got: %s", errs)
+	}
+}
+
+func TestValidateVariablesFile(t *testing.T) {
+	if err := ValidateVariablesFile(filepath.Join("testdata", "variables_valid.json")); err != nil {
+		t.Errorf("expected valid variables file, got: %s", err)
+	}
+
+	err := ValidateVariablesFile(filepath.Join("testdata", "variables_invalid.json"))
+	if err == nil {
+		t.Fatalf("expected validation errors for variables_invalid.json")
+	}
+	errs := err.(ValidationErrors)
+	if len(errs) != 2 || errs[0].Line != 3 || errs[1].Line != 4 || errs[1].Field != "env" {
+		t.Errorf("unexpected errors for variables_invalid.json: %s", err)
+	}
+
+	err = ValidateVariablesFile(filepath.Join("testdata", "variables_malformed.json"))
+	if err == nil {
+		t.Fatalf("expected validation errors for variables_malformed.json")
+	}
+	if errs := err.(ValidationErrors); errs[0].Line != 3 {
+		t.Errorf("expected syntax error on line 3, got: %s", err)
+	}
+}
+
+func TestConnectionAddArgs(t *testing.T) {
+	conn := Connection{
+		Name:  "api",
+		Type:  "http",
+		Host:  "api.example.com",
+		Extra: []byte(`"{\"timeout\": 30}"`),
+	}
+	args, err := conn.addArgs()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []string{"--conn-type", "http", "--conn-host", "api.example.com", "--conn-extra", `{"timeout":30}`, "api"}
+	if len(args) != len(want) {
+		t.Fatalf("expected %v, got %v", want, args)
+	}
+	for i := range want {
+		if args[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, args)
+			break
+		}
+	}
+}
+
+func TestMergeVariablesFiles(t *testing.T) {
+	overlay := filepath.Join(t.TempDir(), "variables.prod.json")
+	if err := ioutil.WriteFile(overlay, []byte(`{"env": "prod", "region": "eu"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "merged.json")
+	if err := MergeVariablesFiles(out, filepath.Join("testdata", "variables_valid.json"), overlay); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var merged map[string]interface{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		t.Fatal(err)
+	}
+	if merged["env"] != "prod" || merged["region"] != "eu" || merged["retries"] != float64(3) {
+		t.Errorf("unexpected merged variables: %v", merged)
+	}
+}
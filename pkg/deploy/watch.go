@@ -0,0 +1,217 @@
+package deploy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/inshur/dagger/pkg/store"
+)
+
+// LocalAirflow runs Airflow CLI commands with a local installation instead
+// of through gcloud, for `dagger watch` against a local Airflow.
+type LocalAirflow struct {
+	// Binary is the airflow executable, "airflow" by default.
+	Binary string
+}
+
+func (l LocalAirflow) Run(subCmd string, args ...string) ([]byte, error) {
+	binary := l.Binary
+	if binary == "" {
+		binary = "airflow"
+	}
+	logging.Log.Debugf("running %s %s %s", binary, subCmd, strings.Join(args, " "))
+	return exec.Command(binary, append([]string{subCmd}, args...)...).CombinedOutput()
+}
+
+// FileChange is a changed local file and the bucket object it is synced to.
+type FileChange struct {
+	Local   string
+	Object  string
+	Deleted bool
+	// Dag is set for DAG definition files, following the dag_id == file
+	// name convention.
+	Dag string
+}
+
+// MapChanges maps changed local paths to the objects they are synced to.
+// Paths outside the dags, plugins and data folders are dropped. Python files
+// in the dags folder define the DAG named after them when it is in the
+// running list and the file isn't ignored by an .airflowignore.
+func (c *ComposerEnv) MapChanges(paths []string) ([]FileChange, error) {
+	roots := []struct{ dir, prefix string }{
+		{c.LocalDagsDir, "dags"}, {c.LocalPluginsDir, "plugins"}, {c.LocalDataDir, "data"},
+	}
+	var changes []FileChange
+	candidates := make(map[string]bool)
+	for _, p := range paths {
+		for _, root := range roots {
+			if root.dir == "" {
+				continue
+			}
+			rel, err := filepath.Rel(root.dir, p)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			change := FileChange{Local: p, Object: root.prefix + "/" + filepath.ToSlash(rel)}
+			if _, err := os.Stat(p); os.IsNotExist(err) {
+				change.Deleted = true
+			}
+			if root.prefix == "dags" && filepath.Ext(p) == ".py" {
+				candidates[strings.TrimSuffix(filepath.Base(p), ".py")] = true
+			}
+			changes = append(changes, change)
+			break
+		}
+	}
+	if len(candidates) == 0 {
+		return changes, nil
+	}
+	found, err := FindDagFilesInLocalTree(c.LocalDagsDir, candidates)
+	if err != nil {
+		return nil, err
+	}
+	for i, change := range changes {
+		rel := strings.TrimPrefix(change.Object, "dags/")
+		if rel == change.Object {
+			continue
+		}
+		dag := strings.TrimSuffix(filepath.Base(rel), ".py")
+		if _, listed := c.DagSpecs[dag]; !listed {
+			continue
+		}
+		if change.Deleted {
+			changes[i].Dag = dag
+			continue
+		}
+		for _, p := range found[dag] {
+			if filepath.ToSlash(p) == rel {
+				changes[i].Dag = dag
+			}
+		}
+	}
+	return changes, nil
+}
+
+// PushChanges writes changed files to the environment bucket and restarts
+// only the DAGs whose definition file changed: they are paused while their
+// file is replaced, then unpaused once parsed unless the running list keeps
+// them paused. Files whose content matches the deployed object are skipped.
+func (c *ComposerEnv) PushChanges(changes []FileChange) error {
+	deployed := make(map[string]string)
+	for _, prefix := range snapshotPrefixes {
+		objs, err := c.objects().List(prefix)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			deployed[obj.Name] = obj.MD5
+		}
+	}
+
+	var failed []string
+	for _, change := range c.expandDeleted(changes, deployed) {
+		if err := c.pushChange(change, deployed); err != nil {
+			c.objectLog(change.Object).WithError(err).Error("error pushing change")
+			failed = append(failed, fmt.Sprintf("%s: %v", change.Object, err))
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("couldn't push %d changes:\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// ComposerEnv.expandDeleted replaces a deleted folder with the deployed
+// objects under it, as removing a folder is reported for the folder only.
+func (c *ComposerEnv) expandDeleted(changes []FileChange, deployed map[string]string) []FileChange {
+	var expanded []FileChange
+	for _, change := range changes {
+		if _, ok := deployed[change.Object]; ok || !change.Deleted {
+			expanded = append(expanded, change)
+			continue
+		}
+		var objects []string
+		for obj := range deployed {
+			if strings.HasPrefix(obj, change.Object+"/") {
+				objects = append(objects, obj)
+			}
+		}
+		sort.Strings(objects)
+		for _, obj := range objects {
+			file := FileChange{
+				Local:   filepath.Join(change.Local, filepath.FromSlash(strings.TrimPrefix(obj, change.Object+"/"))),
+				Object:  obj,
+				Deleted: true,
+			}
+			if strings.HasPrefix(obj, "dags/") && path.Ext(obj) == ".py" {
+				dag := strings.TrimSuffix(path.Base(obj), ".py")
+				if _, listed := c.DagSpecs[dag]; listed {
+					file.Dag = dag
+				}
+			}
+			expanded = append(expanded, file)
+		}
+	}
+	return expanded
+}
+
+func (c *ComposerEnv) pushChange(change FileChange, deployed map[string]string) error {
+	if change.Deleted {
+		if _, ok := deployed[change.Object]; !ok {
+			return nil
+		}
+		if change.Dag != "" {
+			c.dagLog(change.Dag).Info("pausing dag")
+			if out, err := c.pauseDag(change.Dag); err != nil {
+				return fmt.Errorf("error pausing dag %v: %s", change.Dag, out)
+			}
+		}
+		c.objectLog(change.Object).Info("deleting")
+		if err := c.objects().Delete(change.Object); err != nil {
+			return err
+		}
+		// the folder and its files may both be reported
+		delete(deployed, change.Object)
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(change.Local)
+	if err != nil {
+		return err
+	}
+	if md5, ok := deployed[change.Object]; ok && md5 == store.MD5(data) {
+		return nil
+	}
+	_, existed := deployed[change.Object]
+	if change.Dag != "" && existed {
+		c.dagLog(change.Dag).Info("pausing dag")
+		if out, err := c.pauseDag(change.Dag); err != nil {
+			return fmt.Errorf("error pausing dag %v: %s", change.Dag, out)
+		}
+	}
+	uploadedAt := time.Now()
+	c.objectLog(change.Object).Info("uploading")
+	if err := c.objects().Write(change.Object, data, withLocalPath(c.provenanceMetadata(), change.Local)); err != nil {
+		return err
+	}
+	if change.Dag == "" {
+		return nil
+	}
+	if c.DagSpecs.Paused(change.Dag) {
+		if existed {
+			c.dagLog(change.Dag).Info("leaving dag paused")
+			return nil
+		}
+		return c.waitForPaused(change.Dag, strings.TrimPrefix(change.Object, "dags/"), uploadedAt)
+	}
+	return c.waitForDeploy(change.Dag, strings.TrimPrefix(change.Object, "dags/"), uploadedAt)
+}
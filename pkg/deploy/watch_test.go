@@ -0,0 +1,158 @@
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/inshur/dagger/pkg/store"
+)
+
+func TestWatchPushChanges(t *testing.T) {
+	local := t.TempDir()
+	bucket := store.Dir{Root: t.TempDir()}
+	write := func(s store.Store, name, data string) {
+		if err := s.Write(name, []byte(data), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	localDir := store.Dir{Root: local}
+	write(localDir, "dags/finance_daily.py", "v2")
+	write(localDir, "dags/reports/weekly_report.py", "same")
+	write(localDir, "dags/helpers.py", "helpers")
+	write(localDir, "plugins/hooks.py", "hooks")
+	write(bucket, "dags/finance_daily.py", "v1")
+	write(bucket, "dags/reports/weekly_report.py", "same")
+	write(bucket, "dags/old_export.py", "old")
+
+	runner := newFakeRunner()
+	runner.outputs["dags details finance_daily -o json"] = `{"dag_id": "finance_daily", "fileloc": "/home/airflow/gcs/dags/finance_daily.py", "last_parsed_time": "2999-01-01T00:00:00+00:00"}`
+	c := ComposerEnv{
+		Runner:          runner,
+		Objects:         bucket,
+		LocalDagsDir:    filepath.Join(local, "dags"),
+		LocalPluginsDir: filepath.Join(local, "plugins"),
+		DagSpecs: RunningList{
+			"finance_daily": {ID: "finance_daily", State: DagActive},
+			"weekly_report": {ID: "weekly_report", State: DagActive},
+			"old_export":    {ID: "old_export", State: DagActive},
+		},
+	}
+	paths := []string{
+		filepath.Join(local, "dags", "finance_daily.py"),
+		filepath.Join(local, "dags", "reports", "weekly_report.py"),
+		filepath.Join(local, "dags", "helpers.py"),
+		filepath.Join(local, "dags", "old_export.py"),
+		filepath.Join(local, "plugins", "hooks.py"),
+		filepath.Join(local, "README.md"),
+	}
+	changes, err := c.MapChanges(paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var dags []string
+	for _, change := range changes {
+		dags = append(dags, change.Object+" "+change.Dag)
+	}
+	want := []string{
+		"dags/finance_daily.py finance_daily",
+		"dags/reports/weekly_report.py weekly_report",
+		"dags/helpers.py ",
+		"dags/old_export.py old_export",
+		"plugins/hooks.py ",
+	}
+	if !reflect.DeepEqual(dags, want) {
+		t.Errorf("expected changes %q, got %q", want, dags)
+	}
+
+	if err := c.PushChanges(changes); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for name, want := range map[string]string{"dags/finance_daily.py": "v2", "dags/helpers.py": "helpers", "plugins/hooks.py": "hooks"} {
+		if got, _ := bucket.Read(name); string(got) != want {
+			t.Errorf("expected %v to be %q, got %q", name, want, got)
+		}
+	}
+	if _, err := bucket.Read("dags/old_export.py"); !os.IsNotExist(err) && err != store.ErrNotExist {
+		t.Errorf("expected deleted dag file to be removed, got %v", err)
+	}
+	for _, cmd := range []string{"dags pause finance_daily", "dags unpause finance_daily", "dags pause old_export"} {
+		if !runner.called(cmd) {
+			t.Errorf("expected %q, calls: %v", cmd, runner.calls)
+		}
+	}
+	if runner.called("dags pause weekly_report") {
+		t.Errorf("unchanged weekly_report must not be restarted")
+	}
+}
+
+func TestWatchPushNewPausedDag(t *testing.T) {
+	local := t.TempDir()
+	bucket := store.Dir{Root: t.TempDir()}
+	if err := (store.Dir{Root: local}).Write("dags/finance_daily.py", []byte("v1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	runner := newFakeRunner()
+	runner.outputs["dags details finance_daily -o json"] = `{"dag_id": "finance_daily", "fileloc": "/home/airflow/gcs/dags/finance_daily.py", "last_parsed_time": "2999-01-01T00:00:00+00:00"}`
+	c := ComposerEnv{
+		Runner:       runner,
+		Objects:      bucket,
+		LocalDagsDir: filepath.Join(local, "dags"),
+		DagSpecs:     RunningList{"finance_daily": {ID: "finance_daily", State: DagPaused}},
+	}
+	changes, err := c.MapChanges([]string{filepath.Join(local, "dags", "finance_daily.py")})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.PushChanges(changes); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !runner.called("dags pause finance_daily") || runner.called("dags unpause finance_daily") {
+		t.Errorf("expected the new dag to be paused once parsed, calls: %v", runner.calls)
+	}
+}
+
+func TestWatchPushDeletedFolder(t *testing.T) {
+	local := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(local, "dags"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	bucket := store.Dir{Root: t.TempDir()}
+	for _, name := range []string{"dags/reports/weekly_report.py", "dags/reports/sql/weekly.sql", "dags/finance_daily.py"} {
+		if err := bucket.Write(name, []byte("v1"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runner := newFakeRunner()
+	c := ComposerEnv{
+		Runner:       runner,
+		Objects:      bucket,
+		LocalDagsDir: filepath.Join(local, "dags"),
+		DagSpecs:     RunningList{"weekly_report": {ID: "weekly_report", State: DagActive}},
+	}
+	changes, err := c.MapChanges([]string{
+		filepath.Join(local, "dags", "reports"),
+		filepath.Join(local, "dags", "reports", "weekly_report.py"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.PushChanges(changes); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	objs, err := bucket.List("dags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, obj := range objs {
+		names = append(names, obj.Name)
+	}
+	if want := []string{"dags/finance_daily.py"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected only %v left, got %v", want, names)
+	}
+	if !runner.called("dags pause weekly_report") {
+		t.Errorf("expected the dag in the deleted folder to be paused, calls: %v", runner.calls)
+	}
+}
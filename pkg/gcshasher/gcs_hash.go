@@ -6,8 +6,9 @@ import (
 	"context"
 	"crypto/md5"
 	"fmt"
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/sirupsen/logrus"
 	"io"
-	"log"
 	"net/url"
 	"os"
 )
@@ -31,7 +32,7 @@ func parseGcsPath(gcsPath string) (bucket string, path string, err error) {
 func gcsMD5(gcsPath string) ([]byte, error) {
 	bktName, path, err := parseGcsPath(gcsPath)
 	if err != nil {
-		log.Fatalf("%s", err)
+		return nil, err
 	}
 
 	ctx := context.Background()
@@ -76,5 +77,22 @@ func LocalFileEqGCS(localPath, gcsPath string) (bool, error) {
 		return false, err
 	}
 
-	return bytes.Compare(loc, gcs) == 0, nil
+	eq := bytes.Compare(loc, gcs) == 0
+	logging.ForObject(gcsPath).WithFields(logrus.Fields{"local": localPath, "equal": eq}).Debug("compared md5 hashes")
+	return eq, nil
+}
+
+// GCSEqGCS check equality of two GCS objects using md5 hash
+func GCSEqGCS(aPath, bPath string) (bool, error) {
+	a, err := gcsMD5(aPath)
+	if err != nil {
+		return false, err
+	}
+	b, err := gcsMD5(bPath)
+	if err != nil {
+		return false, err
+	}
+	eq := bytes.Compare(a, b) == 0
+	logging.ForObject(bPath).WithFields(logrus.Fields{"source": aPath, "equal": eq}).Debug("compared md5 hashes")
+	return eq, nil
 }
@@ -54,6 +54,10 @@ func TestLocalFileEqGCS(t *testing.T) {
 	if eq {
 		t.Errorf("hashes were equal for local test_diff.txt vs gcs test.txt")
 	}
+	eq, err = GCSEqGCS("gs://"+*testBkt+"/testdata/test.txt", "gs://"+*testBkt+"/testdata/test.txt")
+	if !eq {
+		t.Errorf("hashes were not equal for gcs test.txt vs itself: %v", err)
+	}
 	if err := obj.Delete(ctx); err != nil {
 		t.Logf("couldn't clean up test object: %s", err)
 	}
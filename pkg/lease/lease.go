@@ -0,0 +1,232 @@
+// Package lease implements a lock held through an object in a store. The
+// object is created only if it doesn't exist, renewed while its holder is
+// alive and can be taken over once its holder stopped renewing it.
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/inshur/dagger/pkg/logging"
+	"github.com/inshur/dagger/pkg/store"
+)
+
+// DefaultTTL is how long a Lease with an unset TTL lasts without renewal.
+const DefaultTTL = 2 * time.Minute
+
+// ErrLost is returned when renewing or releasing a lease that was taken over
+// or removed.
+var ErrLost = errors.New("lease lost")
+
+// Info is the content of a lease object.
+type Info struct {
+	Owner     string    `json:"owner"`
+	Acquired  time.Time `json:"acquired"`
+	Heartbeat time.Time `json:"heartbeat"`
+	Expires   time.Time `json:"expires"`
+}
+
+// Expired reports whether the holder stopped renewing the lease by now.
+func (i *Info) Expired(now time.Time) bool {
+	return !now.Before(i.Expires)
+}
+
+// HeldError is returned when acquiring a lease somebody else holds.
+type HeldError struct {
+	Info Info
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("locked by %s since %s, expires %s unless renewed",
+		e.Info.Owner, e.Info.Acquired.Format(time.RFC3339), e.Info.Expires.Format(time.RFC3339))
+}
+
+// Lease is a lock on the object Name in Store held by Owner. It lasts TTL
+// after every renewal.
+type Lease struct {
+	Store store.Store
+	Name  string
+	Owner string
+	TTL   time.Duration
+
+	mu         sync.Mutex
+	info       Info
+	generation int64
+	lost       error
+	released   bool
+}
+
+func (l *Lease) ttl() time.Duration {
+	if l.TTL <= 0 {
+		return DefaultTTL
+	}
+	return l.TTL
+}
+
+// Read returns the lease object called name and its generation.
+func Read(s store.Store, name string) (*Info, int64, error) {
+	obj, err := s.Stat(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := s.Read(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, 0, fmt.Errorf("error decoding lock %v: %v", name, err)
+	}
+	return &info, obj.Generation, nil
+}
+
+// write stores info over generation and keeps track of the new generation.
+func (l *Lease) write(generation int64, info Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	obj, err := l.Store.WriteIf(l.Name, generation, data, map[string]string{"dagger-lock-owner": info.Owner})
+	if err != nil {
+		return err
+	}
+	l.info, l.generation = info, obj.Generation
+	return nil
+}
+
+// Acquire takes the lease if nobody holds it or its holder let it expire.
+// It fails with a *HeldError while somebody else holds it.
+func (l *Lease) Acquire() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	info := Info{Owner: l.Owner, Acquired: now, Heartbeat: now, Expires: now.Add(l.ttl())}
+	err := l.write(0, info)
+	if err != store.ErrPrecondition {
+		return err
+	}
+	held, generation, err := Read(l.Store, l.Name)
+	if err == store.ErrNotExist {
+		// released in the meantime
+		return l.write(0, info)
+	}
+	if err != nil {
+		return err
+	}
+	if !held.Expired(now) {
+		return &HeldError{Info: *held}
+	}
+	logging.ForObject(l.Name).Warnf("taking over lock %v, expired at %v", held.Owner, held.Expires.Format(time.RFC3339))
+	if err := l.write(generation, info); err == store.ErrPrecondition {
+		// somebody else took it over first
+		if held, _, err := Read(l.Store, l.Name); err == nil {
+			return &HeldError{Info: *held}
+		}
+		return fmt.Errorf("lock %v was taken over by somebody else", l.Name)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Renew extends the lease by TTL. It fails with ErrLost when the lease was
+// taken over or removed since it was acquired.
+func (l *Lease) Renew() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.lost != nil {
+		return l.lost
+	}
+	if l.released {
+		return ErrLost
+	}
+	now := time.Now()
+	info := l.info
+	info.Heartbeat, info.Expires = now, now.Add(l.ttl())
+	err := l.write(l.generation, info)
+	if err == store.ErrPrecondition {
+		l.lost = ErrLost
+		return ErrLost
+	}
+	return err
+}
+
+// Keep renews the lease every third of its TTL until ctx is done. When the
+// lease is lost, onLost is called and Keep returns. Failed renewals are
+// retried until the lease expires.
+func (l *Lease) Keep(ctx context.Context, onLost func(error)) {
+	ticker := time.NewTicker(l.ttl() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := l.Renew()
+		if err == nil {
+			continue
+		}
+		l.mu.Lock()
+		released := l.released
+		l.mu.Unlock()
+		if released {
+			return
+		}
+		if !errors.Is(err, ErrLost) {
+			l.mu.Lock()
+			if !l.info.Expired(time.Now()) {
+				l.mu.Unlock()
+				logging.ForObject(l.Name).WithError(err).Warn("error renewing lock, retrying")
+				continue
+			}
+			l.lost = fmt.Errorf("%w: couldn't renew it before it expired: %v", ErrLost, err)
+			err = l.lost
+			l.mu.Unlock()
+		}
+		onLost(err)
+		return
+	}
+}
+
+// Err returns why the lease was lost, or nil while it is held.
+func (l *Lease) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Release removes the lease object unless the lease was lost.
+func (l *Lease) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.lost != nil {
+		return l.lost
+	}
+	if l.released {
+		return nil
+	}
+	l.released = true
+	if err := l.Store.DeleteIf(l.Name, l.generation); err == store.ErrPrecondition {
+		return ErrLost
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Break removes the lease object called name, whoever holds it. The holder
+// finds out when it next renews the lease.
+func Break(s store.Store, name string) error {
+	if _, err := s.Stat(name); err != nil {
+		return err
+	}
+	return s.Delete(name)
+}
@@ -0,0 +1,91 @@
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/inshur/dagger/pkg/store"
+)
+
+func TestAcquire(t *testing.T) {
+	s := store.Dir{Root: t.TempDir()}
+	first := &Lease{Store: s, Name: ".dagger/lock", Owner: "ci-1", TTL: time.Minute}
+	second := &Lease{Store: s, Name: ".dagger/lock", Owner: "ci-2", TTL: time.Minute}
+	if err := first.Acquire(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var held *HeldError
+	if err := second.Acquire(); !errors.As(err, &held) || held.Info.Owner != "ci-1" {
+		t.Fatalf("expected the lock to be held by ci-1, got %v", err)
+	}
+	if err := first.Renew(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := first.Release(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := second.Acquire(); err != nil {
+		t.Fatalf("expected a released lock to be acquired, got %s", err)
+	}
+	info, _, err := Read(s, ".dagger/lock")
+	if err != nil || info.Owner != "ci-2" {
+		t.Errorf("expected ci-2 to hold the lock, got %+v, %v", info, err)
+	}
+}
+
+func TestAcquireExpired(t *testing.T) {
+	s := store.Dir{Root: t.TempDir()}
+	stale := &Lease{Store: s, Name: ".dagger/lock", Owner: "ci-1", TTL: time.Minute}
+	if err := stale.Acquire(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// ci-1 stopped renewing an hour ago
+	expired, _ := json.Marshal(Info{Owner: "ci-1", Expires: time.Now().Add(-time.Hour)})
+	if err := s.Write(".dagger/lock", expired, nil); err != nil {
+		t.Fatal(err)
+	}
+	next := &Lease{Store: s, Name: ".dagger/lock", Owner: "ci-2", TTL: time.Minute}
+	if err := next.Acquire(); err != nil {
+		t.Fatalf("expected an expired lock to be taken over, got %s", err)
+	}
+	if err := stale.Renew(); err != ErrLost {
+		t.Errorf("expected the previous holder to lose the lock, got %v", err)
+	}
+	if err := stale.Release(); err != ErrLost {
+		t.Errorf("expected releasing a lost lock to fail, got %v", err)
+	}
+	if info, _, err := Read(s, ".dagger/lock"); err != nil || info.Owner != "ci-2" {
+		t.Errorf("expected ci-2 to still hold the lock, got %+v, %v", info, err)
+	}
+}
+
+func TestKeepNoticesBreak(t *testing.T) {
+	s := store.Dir{Root: t.TempDir()}
+	l := &Lease{Store: s, Name: ".dagger/lock", Owner: "ci-1", TTL: 30 * time.Millisecond}
+	if err := l.Acquire(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	lost := make(chan error, 1)
+	go l.Keep(context.Background(), func(err error) { lost <- err })
+	time.Sleep(25 * time.Millisecond)
+	if err := l.Err(); err != nil {
+		t.Fatalf("expected the lock to be renewed, got %s", err)
+	}
+	if err := Break(s, ".dagger/lock"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case err := <-lost:
+		if !errors.Is(err, ErrLost) {
+			t.Errorf("expected ErrLost, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the holder to notice the lock was broken")
+	}
+	if err := Break(s, ".dagger/lock"); err != store.ErrNotExist {
+		t.Errorf("expected breaking a missing lock to fail, got %v", err)
+	}
+}
@@ -0,0 +1,352 @@
+// Package lint checks the local DAGs folder for common mistakes without
+// needing Airflow installed. Python files are scanned, not executed, so the
+// rules only understand DAGs declared with literal arguments.
+package lint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Level is how seriously a rule's findings are taken.
+type Level string
+
+const (
+	LevelError   Level = "error"
+	LevelWarning Level = "warning"
+	LevelOff     Level = "off"
+)
+
+// Rule IDs, as used in config and suppression comments.
+const (
+	RuleDagIDFilename    = "dag-id-filename"
+	RuleStartDate        = "start-date"
+	RuleCatchup          = "catchup"
+	RuleHeavyImport      = "heavy-import"
+	RuleDynamicStartDate = "dynamic-start-date"
+	RuleOneDagPerFile    = "one-dag-per-file"
+)
+
+// Rule describes a check and its default level.
+type Rule struct {
+	ID          string
+	Description string
+	Level       Level
+}
+
+// Rules lists every check in the order they are run.
+var Rules = []Rule{
+	{RuleDagIDFilename, "dag_id must match the file name, dagger relies on it to find DAG files", LevelError},
+	{RuleOneDagPerFile, "a file must define a single DAG", LevelError},
+	{RuleStartDate, "DAGs must set a start_date", LevelError},
+	{RuleDynamicStartDate, "start_date must not be computed from the current time", LevelError},
+	{RuleCatchup, "DAGs must set catchup explicitly", LevelWarning},
+	{RuleHeavyImport, "heavy modules must not be imported at the top level of a DAG file", LevelWarning},
+}
+
+// DefaultHeavyImports are the modules reported by the heavy-import rule when
+// the config doesn't list its own.
+var DefaultHeavyImports = []string{"pandas", "numpy", "scipy", "sklearn", "tensorflow", "torch", "pyspark", "matplotlib"}
+
+// Config adjusts the rules.
+type Config struct {
+	// Levels overrides the level of rules by ID.
+	Levels map[string]Level
+	// HeavyImports are the top level modules reported by the heavy-import rule.
+	HeavyImports []string
+}
+
+// ParseLevels parses "rule=level" entries.
+func ParseLevels(entries []string) (map[string]Level, error) {
+	levels := make(map[string]Level)
+	for _, e := range entries {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("lint rule %q must be rule=level", e)
+		}
+		id, level := strings.TrimSpace(parts[0]), Level(strings.TrimSpace(parts[1]))
+		if _, ok := ruleByID(id); !ok {
+			return nil, fmt.Errorf("unknown lint rule %q", id)
+		}
+		if level != LevelError && level != LevelWarning && level != LevelOff {
+			return nil, fmt.Errorf("lint rule %s: level must be error, warning or off, got %q", id, level)
+		}
+		levels[id] = level
+	}
+	return levels, nil
+}
+
+func ruleByID(id string) (Rule, bool) {
+	for _, r := range Rules {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+func (cfg Config) level(id string) Level {
+	if l, ok := cfg.Levels[id]; ok {
+		return l
+	}
+	r, _ := ruleByID(id)
+	return r.Level
+}
+
+// Finding is a rule violation. File is relative to the linted folder.
+type Finding struct {
+	Rule    string `json:"rule"`
+	Level   Level  `json:"level"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s [%s]", f.File, f.Line, f.Level, f.Message, f.Rule)
+}
+
+// HasErrors reports whether any finding is at error level.
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Level == LevelError {
+			return true
+		}
+	}
+	return false
+}
+
+// Lint checks every DAG file in dagsRoot.
+func Lint(dagsRoot string, cfg Config) ([]Finding, error) {
+	var findings []Finding
+	err := filepath.Walk(dagsRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == "__pycache__" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".py" {
+			return nil
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dagsRoot, path)
+		if err != nil {
+			return err
+		}
+		findings = append(findings, LintFile(filepath.ToSlash(relPath), src, cfg)...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error linting %v: %v", dagsRoot, err)
+	}
+	return findings, nil
+}
+
+var (
+	dagCall       = regexp.MustCompile(`(?:^|[^\w.])(?:\w+\.)*DAG\s*\(`)
+	dagDecorator  = regexp.MustCompile(`(?m)^[ \t]*@(?:\w+\.)*dag\b[ \t]*(\()?`)
+	functionName  = regexp.MustCompile(`\bdef\s+(\w+)`)
+	topImport     = regexp.MustCompile(`(?m)^(?:import\s+([\w., \t]+)|from\s+([\w.]+)\s+import\b)`)
+	dictStartDate = regexp.MustCompile(`(["'])start_date["']\s*:|\bstart_date\s*=[^=]`)
+	nowCall       = regexp.MustCompile(`(?:^|[^\w])(?:now|utcnow|today)\s*\(`)
+	suppression   = regexp.MustCompile(`#\s*dagger:\s*ignore(-file)?(?:=([\w,\- ]+))?`)
+)
+
+// dagDef is a DAG declared with DAG(...) or the @dag decorator.
+type dagDef struct {
+	line int
+	// id is the literal dag_id, empty when it can't be read statically.
+	id   string
+	args []argument
+	// end is the offset after the declaration's arguments.
+	end int
+}
+
+func (d dagDef) arg(name string) (argument, bool) {
+	for _, a := range d.args {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return argument{}, false
+}
+
+func findDags(s *source) []dagDef {
+	var defs []dagDef
+	for _, m := range dagCall.FindAllStringIndex(s.masked, -1) {
+		open := m[1] - 1
+		d := dagDef{line: s.line(open), args: s.arguments(open), end: s.closing(open)}
+		d.id = literalID(d.args)
+		defs = append(defs, d)
+	}
+	for _, m := range dagDecorator.FindAllStringSubmatchIndex(s.masked, -1) {
+		d := dagDef{line: s.line(m[0]), end: m[1]}
+		if m[2] >= 0 {
+			d.args = s.arguments(m[2])
+			d.end = s.closing(m[2])
+			d.id = literalID(d.args)
+		}
+		if _, ok := d.arg("dag_id"); !ok && (len(d.args) == 0 || d.args[0].Name != "") && d.end >= 0 {
+			// the decorated function's name is the dag_id
+			if fn := functionName.FindStringSubmatch(s.masked[d.end:]); fn != nil {
+				d.id = fn[1]
+			}
+		}
+		defs = append(defs, d)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].line < defs[j].line })
+	return defs
+}
+
+func literalID(args []argument) string {
+	for _, a := range args {
+		if a.Name == "dag_id" {
+			id, _ := literal(a.Value)
+			return id
+		}
+	}
+	if len(args) > 0 && args[0].Name == "" {
+		id, _ := literal(args[0].Value)
+		return id
+	}
+	return ""
+}
+
+// findStartDates returns the start_date expressions set anywhere in the file,
+// as DAG arguments or in default_args dicts, by line.
+func findStartDates(s *source) map[int]string {
+	dates := make(map[int]string)
+	for _, m := range dictStartDate.FindAllStringSubmatchIndex(s.text, -1) {
+		// skip matches inside strings and comments
+		if s.masked[m[0]] != s.text[m[0]] {
+			continue
+		}
+		start := m[1]
+		if m[2] < 0 {
+			start-- // the character after "=" was matched
+		}
+		dates[s.line(m[0])] = s.text[start:s.expressionEnd(start)]
+	}
+	return dates
+}
+
+// LintFile checks one DAG file. Files without a DAG declaration are ignored.
+func LintFile(relPath string, src []byte, cfg Config) []Finding {
+	s := newSource(src)
+	defs := findDags(s)
+	if len(defs) == 0 {
+		return nil
+	}
+	var findings []Finding
+	report := func(rule string, line int, format string, args ...interface{}) {
+		findings = append(findings, Finding{Rule: rule, File: relPath, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	stem := strings.TrimSuffix(filepath.Base(relPath), ".py")
+	startDates := findStartDates(s)
+	for i, d := range defs {
+		if i > 0 {
+			report(RuleOneDagPerFile, d.line, "file defines %d DAGs, only one per file is supported", len(defs))
+		}
+		if d.id != "" && d.id != stem {
+			report(RuleDagIDFilename, d.line, "dag_id %q doesn't match the file name %q", d.id, stem)
+		}
+		if _, ok := d.arg("catchup"); !ok {
+			report(RuleCatchup, d.line, "catchup is not set")
+		}
+		if _, ok := d.arg("start_date"); !ok {
+			if _, ok := d.arg("default_args"); !ok || len(startDates) == 0 {
+				report(RuleStartDate, d.line, "start_date is not set")
+			}
+		}
+	}
+	for line, expr := range startDates {
+		if nowCall.MatchString(expr) {
+			report(RuleDynamicStartDate, line, "start_date %s changes on every parse", strings.TrimSpace(expr))
+		}
+	}
+
+	heavy := cfg.HeavyImports
+	if heavy == nil {
+		heavy = DefaultHeavyImports
+	}
+	for _, m := range topImport.FindAllStringSubmatchIndex(s.masked, -1) {
+		var modules []string
+		if m[2] >= 0 {
+			for _, mod := range strings.Split(s.masked[m[2]:m[3]], ",") {
+				modules = append(modules, strings.Fields(mod)...)
+			}
+		} else {
+			modules = []string{s.masked[m[4]:m[5]]}
+		}
+		for _, mod := range modules {
+			root := strings.SplitN(mod, ".", 2)[0]
+			for _, h := range heavy {
+				if root == h {
+					report(RuleHeavyImport, s.line(m[0]), "top level import of %s slows down DAG parsing, import it inside the task", mod)
+				}
+			}
+		}
+	}
+
+	return filter(s, findings, cfg)
+}
+
+// filter applies rule levels and `# dagger: ignore[=rule,...]` comments on
+// the reported line or `# dagger: ignore-file[=rule,...]` anywhere.
+func filter(s *source, findings []Finding, cfg Config) []Finding {
+	lines := strings.Split(s.text, "\n")
+	fileIgnores := make(map[string]bool)
+	ignores := func(m []string) map[string]bool {
+		rules := map[string]bool{}
+		if m[2] == "" {
+			rules["*"] = true
+		}
+		for _, r := range strings.Split(m[2], ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				rules[r] = true
+			}
+		}
+		return rules
+	}
+	for _, l := range lines {
+		if m := suppression.FindStringSubmatch(l); m != nil && m[1] != "" {
+			for r := range ignores(m) {
+				fileIgnores[r] = true
+			}
+		}
+	}
+
+	var kept []Finding
+	for _, f := range findings {
+		f.Level = cfg.level(f.Rule)
+		if f.Level == LevelOff || fileIgnores["*"] || fileIgnores[f.Rule] {
+			continue
+		}
+		if m := suppression.FindStringSubmatch(lines[f.Line-1]); m != nil && m[1] == "" {
+			if r := ignores(m); r["*"] || r[f.Rule] {
+				continue
+			}
+		}
+		kept = append(kept, f)
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		if kept[i].Line != kept[j].Line {
+			return kept[i].Line < kept[j].Line
+		}
+		return kept[i].Rule < kept[j].Rule
+	})
+	return kept
+}
@@ -0,0 +1,74 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	findings, err := Lint(filepath.Join("testdata", "dags"), Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.String())
+	}
+	want := []string{
+		`export_hourly.py:3: warning: top level import of pandas slows down DAG parsing, import it inside the task [heavy-import]`,
+		`export_hourly.py:7: warning: catchup is not set [catchup]`,
+		`export_hourly.py:7: error: dag_id "hourly_export" doesn't match the file name "export_hourly" [dag-id-filename]`,
+		`export_hourly.py:7: error: start_date datetime.now() changes on every parse [dynamic-start-date]`,
+		`export_hourly.py:9: error: file defines 2 DAGs, only one per file is supported [one-dag-per-file]`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected findings:\n%q\nwant:\n%q", got, want)
+	}
+
+	levels, err := ParseLevels([]string{"one-dag-per-file=off", "dynamic-start-date=warning"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	findings, err = Lint(filepath.Join("testdata", "dags"), Config{Levels: levels, HeavyImports: []string{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(findings) != 3 || !HasErrors(findings) {
+		t.Errorf("expected the configured levels to apply, got %v", findings)
+	}
+
+	if _, err := ParseLevels([]string{"tabs=off"}); err == nil {
+		t.Errorf("expected an unknown rule to be rejected")
+	}
+}
+
+func TestLintStartDate(t *testing.T) {
+	src := []byte("from airflow import DAG\n\ndag = DAG('no_start', schedule_interval=None, catchup=False)\n")
+	findings := LintFile("no_start.py", src, Config{})
+	if len(findings) != 1 || findings[0].Rule != RuleStartDate || findings[0].Line != 3 {
+		t.Errorf("expected a missing start_date, got %v", findings)
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	findings := []Finding{{Rule: RuleCatchup, Level: LevelWarning, File: "reports/weekly.py", Line: 4, Message: "catchup is not set"}}
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatSARIF, findings); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("invalid SARIF output: %s", err)
+	}
+	result := log.Runs[0].Results[0]
+	if log.Version != "2.1.0" || len(log.Runs[0].Tool.Driver.Rules) != len(Rules) ||
+		result.Locations[0].PhysicalLocation.ArtifactLocation.URI != "reports/weekly.py" {
+		t.Errorf("unexpected SARIF log: %s", buf.String())
+	}
+	if err := Write(&buf, "xml", findings); err == nil {
+		t.Errorf("expected an unknown format to be rejected")
+	}
+}
@@ -0,0 +1,149 @@
+package lint
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// source is a Python file together with a masked copy of the same length in
+// which comments and the contents of string literals are blanked out, so
+// code can be matched without tripping over text in strings or comments.
+type source struct {
+	text   string
+	masked string
+}
+
+func newSource(src []byte) *source {
+	return &source{text: string(src), masked: mask(src)}
+}
+
+// mask blanks out comments and string contents, keeping quotes and newlines
+// so offsets and line numbers are unchanged.
+func mask(src []byte) string {
+	out := make([]byte, len(src))
+	copy(out, src)
+	for i := 0; i < len(src); {
+		switch c := src[i]; {
+		case c == '#':
+			for ; i < len(src) && src[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '"' || c == '\'':
+			quote := []byte{c}
+			if bytes.HasPrefix(src[i:], []byte{c, c, c}) {
+				quote = []byte{c, c, c}
+			}
+			i += len(quote)
+			for i < len(src) && !bytes.HasPrefix(src[i:], quote) {
+				if len(quote) == 1 && src[i] == '\n' {
+					break
+				}
+				if src[i] == '\\' && i+1 < len(src) {
+					out[i] = ' '
+					i++
+				}
+				if src[i] != '\n' {
+					out[i] = ' '
+				}
+				i++
+			}
+			i += len(quote)
+		default:
+			i++
+		}
+	}
+	return string(out)
+}
+
+// line returns the 1-based line number of offset.
+func (s *source) line(offset int) int {
+	return strings.Count(s.text[:offset], "\n") + 1
+}
+
+// closing returns the offset of the bracket closing the one at open, or -1.
+func (s *source) closing(open int) int {
+	depth := 0
+	for i := open; i < len(s.masked); i++ {
+		switch s.masked[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// expressionEnd returns the offset where the expression starting at start
+// ends: the first comma or closing bracket outside of nested brackets.
+func (s *source) expressionEnd(start int) int {
+	depth := 0
+	for i := start; i < len(s.masked); i++ {
+		switch s.masked[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(s.masked)
+}
+
+// argument is one argument of a call, Name is empty for positional ones.
+type argument struct {
+	Name   string
+	Value  string
+	Offset int
+}
+
+var keywordArgument = regexp.MustCompile(`^\s*(\w+)\s*=[^=]`)
+
+// arguments splits the arguments of the call whose parenthesis opens at open.
+func (s *source) arguments(open int) []argument {
+	end := s.closing(open)
+	if end < 0 {
+		return nil
+	}
+	var args []argument
+	for start := open + 1; start < end; {
+		stop := s.expressionEnd(start)
+		if stop > end {
+			stop = end
+		}
+		arg := argument{Value: strings.TrimSpace(s.text[start:stop]), Offset: start}
+		if m := keywordArgument.FindStringSubmatchIndex(s.masked[start:stop]); m != nil {
+			arg.Name = s.masked[start+m[2] : start+m[3]]
+			arg.Value = strings.TrimSpace(s.text[start+m[3]+1 : stop])
+		}
+		if arg.Value != "" || arg.Name != "" {
+			args = append(args, arg)
+		}
+		start = stop + 1
+	}
+	return args
+}
+
+var stringLiteral = regexp.MustCompile(`^[rRuU]?("([^"\\]*)"|'([^'\\]*)')$`)
+
+// literal returns the value of a plain string literal expression.
+func literal(expr string) (string, bool) {
+	m := stringLiteral.FindStringSubmatch(strings.TrimSpace(expr))
+	if m == nil {
+		return "", false
+	}
+	if strings.HasPrefix(m[1], `"`) {
+		return m[2], true
+	}
+	return m[3], true
+}
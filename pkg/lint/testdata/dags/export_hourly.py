@@ -0,0 +1,9 @@
+from datetime import datetime
+
+import pandas as pd
+from airflow import DAG
+
+# DAG("commented_out")
+dag = DAG(dag_id="hourly_export", start_date=datetime.now(), schedule_interval="@hourly")
+
+other = DAG("export_hourly_backfill", start_date=datetime(2021, 1, 1), catchup=True)  # dagger: ignore=dag-id-filename
@@ -0,0 +1,18 @@
+from datetime import datetime
+
+from airflow import DAG
+from airflow.operators.bash import BashOperator
+
+default_args = {
+    "owner": "finance",
+    "start_date": datetime(2021, 1, 1),
+}
+
+with DAG(
+    "finance_daily",
+    default_args=default_args,
+    schedule_interval="@daily",
+    catchup=False,
+    tags=["finance"],
+) as dag:
+    BashOperator(task_id="extract", bash_command="echo 'DAG(' # not a call")
@@ -0,0 +1,12 @@
+# dagger: ignore-file=heavy-import
+import numpy
+import pendulum
+from airflow.decorators import dag
+
+
+@dag(schedule_interval="@weekly", start_date=pendulum.datetime(2021, 1, 1), catchup=False)
+def weekly_report():
+    pass
+
+
+weekly_report()
@@ -0,0 +1,104 @@
+// Package logging is dagger's structured logger. The lines of a run carry
+// its fields, so a log pipeline can index deploys by run and environment and
+// alert on failures.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Fields used consistently across dagger's log lines.
+const (
+	RunID  = "run_id"
+	Env    = "env"
+	DagID  = "dag_id"
+	Phase  = "phase"
+	Object = "object"
+)
+
+// Log is the logger every package writes to, text on stderr by default.
+var Log = logrus.New()
+
+func init() {
+	Log.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+}
+
+// Configure sets the output format, text or json, and the minimum level of
+// the logger. The standard library logger is routed through it so that
+// every line shares the format.
+func Configure(format, level string) error {
+	switch format {
+	case "text":
+		Log.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case "json":
+		Log.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("log format must be text or json, got %q", format)
+	}
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	Log.SetLevel(lvl)
+	log.SetFlags(0)
+	log.SetOutput(stdWriter{})
+	return nil
+}
+
+// stdWriter logs the lines of the standard library logger at info level.
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	Log.Info(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// Run returns an entry tagging the lines of a run with its ID and
+// environment. Empty values are left out. Runs each log through their own
+// entry, so overlapping runs don't label each other's lines.
+func Run(runID, env string) *logrus.Entry {
+	fields := logrus.Fields{}
+	if runID != "" {
+		fields[RunID] = runID
+	}
+	if env != "" {
+		fields[Env] = env
+	}
+	return Log.WithFields(fields)
+}
+
+type entryKey struct{}
+
+// NewContext returns a copy of ctx carrying entry, for functions that only
+// get a context.
+func NewContext(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// FromContext returns the entry carried by ctx, or one without fields.
+func FromContext(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(entryKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(Log)
+}
+
+// Dag returns a log entry about dag.
+func Dag(dag string) *logrus.Entry {
+	return Log.WithField(DagID, dag)
+}
+
+// InPhase returns a log entry for a phase of the sync.
+func InPhase(phase string) *logrus.Entry {
+	return Log.WithField(Phase, phase)
+}
+
+// ForObject returns a log entry about an object in the environment bucket.
+func ForObject(name string) *logrus.Entry {
+	return Log.WithField(Object, name)
+}
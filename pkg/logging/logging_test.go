@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJSONFields(t *testing.T) {
+	var buf bytes.Buffer
+	Log.SetOutput(&buf)
+	defer Log.SetOutput(os.Stderr)
+	defer log.SetOutput(os.Stderr)
+	if err := Configure("json", "info"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer Configure("text", "info")
+
+	Run("20210601T120000Z", "composer-dev").WithField(DagID, "finance_daily").WithField(Phase, "start").Info("triggering dag")
+	log.Printf("cycle %d succeeded", 1)
+	Log.Debug("not logged at info level")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", lines)
+	}
+	var entry map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("expected a json line, got %q: %s", lines[0], err)
+	}
+	for k, want := range map[string]string{
+		RunID: "20210601T120000Z", Env: "composer-dev", DagID: "finance_daily",
+		Phase: "start", "msg": "triggering dag", "level": "info",
+	} {
+		if entry[k] != want {
+			t.Errorf("expected %s=%q, got %q", k, want, entry[k])
+		}
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil || entry["msg"] != "cycle 1 succeeded" || entry["level"] != "info" {
+		t.Errorf("expected the standard logger to be routed, got %q", lines[1])
+	}
+}
+
+func TestConfigureRejectsUnknown(t *testing.T) {
+	if err := Configure("xml", "info"); err == nil {
+		t.Errorf("expected an unknown format to fail")
+	}
+	if err := Configure("text", "loud"); err == nil {
+		t.Errorf("expected an unknown level to fail")
+	}
+}
+
+func TestRunsKeepTheirOwnFields(t *testing.T) {
+	var buf bytes.Buffer
+	Log.SetOutput(&buf)
+	defer Log.SetOutput(os.Stderr)
+	if err := Configure("json", "info"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer Configure("text", "info")
+
+	dev := Run("20210601T120000Z", "composer-dev")
+	prod := Run("20210601T120001Z", "composer-prod")
+	ctx := NewContext(context.Background(), dev)
+	prod.Info("syncing")
+	FromContext(ctx).Info("syncing")
+	FromContext(context.Background()).Info("idle")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", lines)
+	}
+	for i, want := range []string{"composer-prod", "composer-dev", ""} {
+		var entry map[string]string
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil || entry[Env] != want {
+			t.Errorf("expected env %q on line %d, got %q", want, i, lines[i])
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Package metrics exposes what dagger does to Prometheus, so long running
+// modes like sync --loop, agent and serve can be monitored.
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every dagger metric along with the Go runtime and process
+// collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	cycles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dagger_sync_cycles_total",
+		Help: "Syncs run, by result (success or failure).",
+	}, []string{"result"})
+	phaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dagger_sync_phase_duration_seconds",
+		Help:    "Duration of the phases of a sync: configure, plugins, data, plan, stop and start.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"phase"})
+	dags = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dagger_dags_total",
+		Help: "DAGs changed by syncs, by action (started, stopped, restarted, paused or unpaused).",
+	}, []string{"action"})
+	uploadedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dagger_uploaded_bytes_total",
+		Help: "Bytes uploaded to the environment bucket.",
+	})
+	callDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dagger_runner_call_duration_seconds",
+		Help:    "Latency of gcloud and Airflow CLI calls, by subcommand.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 12),
+	}, []string{"subcommand"})
+	callErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dagger_runner_call_errors_total",
+		Help: "Failed gcloud and Airflow CLI calls, by subcommand.",
+	}, []string{"subcommand"})
+	lastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dagger_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful sync.",
+	})
+)
+
+var (
+	mu            sync.Mutex
+	lastSuccessAt time.Time
+	started       = time.Now()
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		cycles, phaseDuration, dags, uploadedBytes, callDuration, callErrors, lastSuccess,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dagger_seconds_since_last_success",
+			Help: "Seconds since the last successful sync, or since dagger started when none succeeded yet.",
+		}, SinceLastSuccess),
+	)
+	for _, result := range []string{"success", "failure"} {
+		cycles.WithLabelValues(result)
+	}
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Cycle counts a finished sync and, when it succeeded, marks now as the
+// last successful one.
+func Cycle(err error) {
+	if err != nil {
+		cycles.WithLabelValues("failure").Inc()
+		return
+	}
+	cycles.WithLabelValues("success").Inc()
+	now := time.Now()
+	mu.Lock()
+	lastSuccessAt = now
+	mu.Unlock()
+	lastSuccess.Set(float64(now.Unix()))
+}
+
+// SinceLastSuccess returns the seconds since the last successful sync, or
+// since the process started if there was none.
+func SinceLastSuccess() float64 {
+	mu.Lock()
+	defer mu.Unlock()
+	if lastSuccessAt.IsZero() {
+		return time.Since(started).Seconds()
+	}
+	return time.Since(lastSuccessAt).Seconds()
+}
+
+// Phase records how long a sync phase took since start.
+func Phase(phase string, start time.Time) {
+	phaseDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+}
+
+// Dag counts a DAG changed by a sync.
+func Dag(action string) {
+	dags.WithLabelValues(action).Inc()
+}
+
+// Uploaded counts bytes uploaded to the bucket.
+func Uploaded(n int64) {
+	uploadedBytes.Add(float64(n))
+}
+
+// Subcommand names a CLI call by its command and first positional argument,
+// like "dags pause", keeping DAG IDs and flags out of the label.
+func Subcommand(cmd string, args ...string) string {
+	if len(args) > 0 && args[0] != "" && !strings.HasPrefix(args[0], "-") {
+		return cmd + " " + args[0]
+	}
+	return cmd
+}
+
+// Call records the latency of a CLI call started at start and whether it
+// failed.
+func Call(subcommand string, start time.Time, err error) {
+	callDuration.WithLabelValues(subcommand).Observe(time.Since(start).Seconds())
+	if err != nil {
+		callErrors.WithLabelValues(subcommand).Inc()
+	}
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubcommand(t *testing.T) {
+	for _, tc := range []struct {
+		cmd  string
+		args []string
+		want string
+	}{
+		{"dags", []string{"pause", "finance_daily"}, "dags pause"},
+		{"dags", []string{"list", "-o", "json"}, "dags list"},
+		{"version", nil, "version"},
+		{"variables", []string{"--", "import"}, "variables"},
+	} {
+		if got := Subcommand(tc.cmd, tc.args...); got != tc.want {
+			t.Errorf("Subcommand(%q, %q) = %q, want %q", tc.cmd, tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	Cycle(errors.New("apply error"))
+	Cycle(nil)
+	Phase("plan", time.Now().Add(-time.Second))
+	Dag("restarted")
+	Uploaded(1024)
+	Call("dags pause", time.Now(), errors.New("exit status 1"))
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	out := string(body)
+	for _, want := range []string{
+		`dagger_sync_cycles_total{result="failure"} 1`,
+		`dagger_sync_cycles_total{result="success"} 1`,
+		`dagger_sync_phase_duration_seconds_count{phase="plan"} 1`,
+		`dagger_dags_total{action="restarted"} 1`,
+		`dagger_uploaded_bytes_total 1024`,
+		`dagger_runner_call_duration_seconds_count{subcommand="dags pause"} 1`,
+		`dagger_runner_call_errors_total{subcommand="dags pause"} 1`,
+		`dagger_seconds_since_last_success`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+	if since := SinceLastSuccess(); since < 0 || since > 5 {
+		t.Errorf("expected the last success to be just now, got %vs ago", since)
+	}
+}
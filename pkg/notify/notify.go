@@ -0,0 +1,347 @@
+// Package notify posts the summary of a sync to chat and webhook sinks:
+// generic JSON webhooks, Slack incoming webhooks and email over SMTP.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/inshur/dagger/pkg/deploy"
+)
+
+// Sink types.
+const (
+	TypeWebhook = "webhook"
+	TypeSlack   = "slack"
+	TypeEmail   = "email"
+)
+
+// When a sink is notified.
+const (
+	OnAlways  = "always"
+	OnFailure = "failure"
+	OnSuccess = "success"
+)
+
+// Summary is what a notification says about a sync. It is the JSON payload
+// of webhook sinks and the data of sink templates.
+type Summary struct {
+	ID          string    `json:"id"`
+	Env         string    `json:"env,omitempty"`
+	Environment string    `json:"environment"`
+	Project     string    `json:"project,omitempty"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+	Duration    float64   `json:"duration_seconds"`
+	Commit      string    `json:"commit,omitempty"`
+	Repo        string    `json:"repo,omitempty"`
+	User        string    `json:"user,omitempty"`
+	// Link points to the run that printed the plan, like a CI job.
+	Link      string   `json:"link,omitempty"`
+	Started   []string `json:"started"`
+	Stopped   []string `json:"stopped"`
+	Restarted []string `json:"restarted"`
+	// Failed maps the DAGs that failed to their error.
+	Failed map[string]string `json:"failed,omitempty"`
+	// ImportErrors maps the DAGs that failed to import to their file.
+	ImportErrors map[string]string `json:"import_errors,omitempty"`
+}
+
+// NewSummary summarizes a deployment record. env is the named environment
+// of the config file the sync ran for, if any.
+func NewSummary(d deploy.Deployment, env string) Summary {
+	s := Summary{
+		ID:           d.ID,
+		Env:          env,
+		Environment:  d.Environment,
+		Project:      d.Project,
+		Status:       d.Status,
+		Error:        d.Error,
+		Time:         d.Started,
+		Duration:     d.Duration,
+		Commit:       d.Commit,
+		Repo:         d.Repo,
+		User:         d.User,
+		Link:         d.RunURL,
+		Started:      []string{},
+		Stopped:      []string{},
+		Restarted:    []string{},
+		ImportErrors: d.ImportErrors,
+	}
+	for dag, outcome := range d.Dags {
+		switch {
+		case outcome == "started":
+			s.Started = append(s.Started, dag)
+		case outcome == "stopped":
+			s.Stopped = append(s.Stopped, dag)
+		case outcome == "restarted":
+			s.Restarted = append(s.Restarted, dag)
+		case strings.HasPrefix(outcome, "failed: "):
+			if s.Failed == nil {
+				s.Failed = make(map[string]string)
+			}
+			s.Failed[dag] = strings.TrimPrefix(outcome, "failed: ")
+		}
+	}
+	for _, dags := range [][]string{s.Started, s.Stopped, s.Restarted} {
+		sort.Strings(dags)
+	}
+	return s
+}
+
+// failed reports whether the sync failed.
+func (s Summary) failed() bool {
+	return s.Status != deploy.DeploySucceeded
+}
+
+// DefaultTemplate is the message of Slack and email sinks without a
+// template of their own.
+const DefaultTemplate = `{{if eq .Status "succeeded"}}Deployed{{else}}Failed to deploy{{end}} {{.ID}} to {{.Environment}}{{with .Commit}} at {{.}}{{end}}{{with .User}} by {{.}}{{end}}
+{{with .Error}}Error: {{.}}
+{{end}}{{with .Started}}Started: {{join . ", "}}
+{{end}}{{with .Restarted}}Restarted: {{join . ", "}}
+{{end}}{{with .Stopped}}Stopped: {{join . ", "}}
+{{end}}{{with .Failed}}Failed: {{join (keys .) ", "}}
+{{end}}{{with .ImportErrors}}Import errors:{{range $dag, $file := .}}
+  {{$dag}} ({{$file}}){{end}}
+{{end}}{{with .Link}}Plan: {{.}}
+{{end}}`
+
+// defaultSubject is the subject of emails.
+const defaultSubject = `[dagger] {{.Environment}}: deploy {{.ID}} {{.Status}}`
+
+var funcs = template.FuncMap{
+	"join": strings.Join,
+	// json quotes a value for templates of JSON bodies
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"keys": func(m map[string]string) []string {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys
+	},
+}
+
+// Sink is a destination of sync summaries.
+type Sink struct {
+	// Type is TypeWebhook, TypeSlack or TypeEmail.
+	Type string
+	// URL receives the payloads of webhook and Slack sinks.
+	URL string
+	// On is OnAlways, OnFailure or OnSuccess, always when empty.
+	On string
+	// Environments only notifies syncs of these environments, matched
+	// against both the named environment and the Composer environment.
+	// Every environment is notified when empty.
+	Environments []string
+	// Template is a text/template of the message, rendered with a Summary.
+	// Webhook sinks post the Summary as JSON when it is empty, and the
+	// rendered template as their JSON body otherwise.
+	Template string
+	// Subject is a text/template of the subject of emails.
+	Subject string
+	// SMTP is the host:port of the mail server of email sinks.
+	SMTP     string
+	From     string
+	To       []string
+	Username string
+	Password string
+	// Timeout bounds each delivery, 10s when zero.
+	Timeout time.Duration
+}
+
+// Validate checks that s has what its type needs and that its templates
+// parse.
+func (s Sink) Validate() error {
+	switch s.Type {
+	case TypeWebhook, TypeSlack:
+		if s.URL == "" {
+			return fmt.Errorf("%s sink needs a url", s.Type)
+		}
+	case TypeEmail:
+		if s.SMTP == "" || s.From == "" || len(s.To) == 0 {
+			return fmt.Errorf("email sink needs smtp, from and to")
+		}
+		if _, _, err := net.SplitHostPort(s.SMTP); err != nil {
+			return fmt.Errorf("email sink smtp must be host:port: %v", err)
+		}
+	default:
+		return fmt.Errorf("sink type must be webhook, slack or email, got %q", s.Type)
+	}
+	switch s.On {
+	case "", OnAlways, OnFailure, OnSuccess:
+	default:
+		return fmt.Errorf("%s sink on must be always, failure or success, got %q", s.Type, s.On)
+	}
+	for _, text := range []string{s.Template, s.Subject} {
+		if _, err := template.New(s.Type).Funcs(funcs).Parse(text); err != nil {
+			return fmt.Errorf("%s sink template: %v", s.Type, err)
+		}
+	}
+	return nil
+}
+
+// Wants reports whether s is notified of the sync summarized by sum.
+func (s Sink) Wants(sum Summary) bool {
+	switch s.On {
+	case OnFailure:
+		if !sum.failed() {
+			return false
+		}
+	case OnSuccess:
+		if sum.failed() {
+			return false
+		}
+	}
+	if len(s.Environments) == 0 {
+		return true
+	}
+	for _, env := range s.Environments {
+		if env == sum.Env || env == sum.Environment {
+			return true
+		}
+	}
+	return false
+}
+
+// render executes text, or fallback when text is empty, with sum.
+func render(text, fallback string, sum Summary) (string, error) {
+	if text == "" {
+		text = fallback
+	}
+	t, err := template.New("notify").Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, sum); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Send delivers sum to s, whether or not s wants it.
+func (s Sink) Send(sum Summary) error {
+	switch s.Type {
+	case TypeWebhook:
+		var body []byte
+		if s.Template == "" {
+			b, err := json.Marshal(sum)
+			if err != nil {
+				return err
+			}
+			body = b
+		} else {
+			text, err := render(s.Template, "", sum)
+			if err != nil {
+				return err
+			}
+			body = []byte(text)
+		}
+		return s.post(body)
+	case TypeSlack:
+		text, err := render(s.Template, DefaultTemplate, sum)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(map[string]string{"text": text})
+		if err != nil {
+			return err
+		}
+		return s.post(body)
+	case TypeEmail:
+		return s.mail(sum)
+	}
+	return fmt.Errorf("unknown sink type %q", s.Type)
+}
+
+func (s Sink) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return 10 * time.Second
+}
+
+// post sends a JSON body to the sink's URL.
+func (s Sink) post(body []byte) error {
+	client := &http.Client{Timeout: s.timeout()}
+	resp, err := client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s sink returned %s: %s", s.Type, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// mail sends the rendered summary as a plain text email.
+func (s Sink) mail(sum Summary) error {
+	subject, err := render(s.Subject, defaultSubject, sum)
+	if err != nil {
+		return err
+	}
+	body, err := render(s.Template, DefaultTemplate, sum)
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	// a line break in the subject would start a header of its own
+	subject = strings.Join(strings.Fields(subject), " ")
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.SMTP)
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.SMTP, auth, s.From, s.To, msg.Bytes())
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(s.timeout()):
+		return fmt.Errorf("email sink timed out after %s", s.timeout())
+	}
+}
+
+// Notify sends sum to every sink that wants it, returning the errors of
+// the sinks that couldn't be notified.
+func Notify(sinks []Sink, sum Summary) []error {
+	var errs []error
+	for _, s := range sinks {
+		if !s.Wants(sum) {
+			continue
+		}
+		if err := s.Send(sum); err != nil {
+			errs = append(errs, fmt.Errorf("error notifying %s sink: %v", s.Type, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,292 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inshur/dagger/pkg/deploy"
+)
+
+func failedDeployment() deploy.Deployment {
+	return deploy.Deployment{
+		ID:          "20210601T120000Z",
+		Environment: "composer-prod",
+		Started:     time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		Provenance: deploy.Provenance{
+			Commit: "4f2c1e9",
+			User:   "jdoe",
+			RunURL: "https://github.com/inshur/dags/actions/runs/42",
+		},
+		Status: deploy.DeployFailed,
+		Error:  "apply error: sync failed for 1 DAGs",
+		Dags: map[string]string{
+			"finance_daily":  "started",
+			"billing_hourly": "restarted",
+			"legacy_export":  "stopped",
+			"audit_export":   "failed: dag audit_export failed to import from audit.py",
+			"ml_features":    "skipped: tasks still running",
+		},
+		ImportErrors: map[string]string{"audit_export": "audit.py"},
+	}
+}
+
+func TestNewSummary(t *testing.T) {
+	s := NewSummary(failedDeployment(), "prod")
+	if !reflect.DeepEqual(s.Started, []string{"finance_daily"}) ||
+		!reflect.DeepEqual(s.Restarted, []string{"billing_hourly"}) ||
+		!reflect.DeepEqual(s.Stopped, []string{"legacy_export"}) {
+		t.Errorf("unexpected DAG lists: %+v", s)
+	}
+	if len(s.Failed) != 1 || s.Failed["audit_export"] != "dag audit_export failed to import from audit.py" {
+		t.Errorf("unexpected failed DAGs: %v", s.Failed)
+	}
+	if s.Env != "prod" || s.Link != "https://github.com/inshur/dags/actions/runs/42" {
+		t.Errorf("unexpected env or link: %+v", s)
+	}
+}
+
+func TestWants(t *testing.T) {
+	failed := NewSummary(failedDeployment(), "prod")
+	succeeded := failed
+	succeeded.Status = deploy.DeploySucceeded
+	for _, tc := range []struct {
+		sink Sink
+		sum  Summary
+		want bool
+	}{
+		{Sink{}, succeeded, true},
+		{Sink{On: OnFailure}, succeeded, false},
+		{Sink{On: OnFailure}, failed, true},
+		{Sink{On: OnSuccess}, failed, false},
+		{Sink{Environments: []string{"prod"}}, failed, true},
+		{Sink{Environments: []string{"composer-prod"}}, failed, true},
+		{Sink{Environments: []string{"staging"}}, failed, false},
+	} {
+		if got := tc.sink.Wants(tc.sum); got != tc.want {
+			t.Errorf("%+v wants %s sync: got %v, want %v", tc.sink, tc.sum.Status, got, tc.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, s := range []Sink{
+		{Type: "teams", URL: "http://example.com"},
+		{Type: TypeSlack},
+		{Type: TypeEmail, SMTP: "smtp.example.com", From: "a@example.com", To: []string{"b@example.com"}},
+		{Type: TypeWebhook, URL: "http://example.com", On: "sometimes"},
+		{Type: TypeWebhook, URL: "http://example.com", Template: "{{.ID"},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", s)
+		}
+	}
+}
+
+// recorder is an HTTP stand-in recording the bodies posted to it.
+func recorder(t *testing.T, status int) (*httptest.Server, chan []byte) {
+	bodies := make(chan []byte, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, bodies
+}
+
+func TestWebhook(t *testing.T) {
+	srv, bodies := recorder(t, http.StatusOK)
+	sum := NewSummary(failedDeployment(), "prod")
+	if err := (Sink{Type: TypeWebhook, URL: srv.URL}).Send(sum); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var got Summary
+	if err := json.Unmarshal(<-bodies, &got); err != nil {
+		t.Fatalf("expected a JSON summary: %s", err)
+	}
+	if !reflect.DeepEqual(got, sum) {
+		t.Errorf("expected %+v, got %+v", sum, got)
+	}
+
+	templated := Sink{Type: TypeWebhook, URL: srv.URL, Template: `{"deploy": "{{.ID}}", "failed": {{len .Failed}}}`}
+	if err := templated.Send(sum); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if body := string(<-bodies); body != `{"deploy": "20210601T120000Z", "failed": 1}` {
+		t.Errorf("unexpected templated body %s", body)
+	}
+
+	// errors hold quotes and line breaks, json keeps the body valid
+	sum.Failed["audit_export"] = "dag audit_export failed to import:\nNameError: name \"DAG\" is not defined"
+	templated.Template = `{"deploy": {{json .ID}}, "status": {{json .Status}}, "error": {{json .Error}}, "failed": {{json .Failed}}}`
+	if err := templated.Send(sum); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var body struct {
+		Deploy, Status, Error string
+		Failed                map[string]string
+	}
+	if err := json.Unmarshal(<-bodies, &body); err != nil {
+		t.Fatalf("expected a JSON body: %s", err)
+	}
+	if body.Status != "failed" || body.Error != sum.Error || body.Failed["audit_export"] != sum.Failed["audit_export"] {
+		t.Errorf("unexpected templated body %+v", body)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	srv, bodies := recorder(t, http.StatusOK)
+	if err := (Sink{Type: TypeSlack, URL: srv.URL}).Send(NewSummary(failedDeployment(), "prod")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var payload struct{ Text string }
+	if err := json.Unmarshal(<-bodies, &payload); err != nil {
+		t.Fatalf("expected a slack payload: %s", err)
+	}
+	for _, want := range []string{
+		"Failed to deploy 20210601T120000Z to composer-prod at 4f2c1e9 by jdoe",
+		"Started: finance_daily",
+		"Restarted: billing_hourly",
+		"Stopped: legacy_export",
+		"Failed: audit_export",
+		"audit_export (audit.py)",
+		"Plan: https://github.com/inshur/dags/actions/runs/42",
+	} {
+		if !strings.Contains(payload.Text, want) {
+			t.Errorf("expected %q in message:\n%s", want, payload.Text)
+		}
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	srv, _ := recorder(t, http.StatusNotFound)
+	err := (Sink{Type: TypeSlack, URL: srv.URL}).Send(NewSummary(failedDeployment(), ""))
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a 404 error, got %v", err)
+	}
+}
+
+// smtpServer is an SMTP stand-in accepting one message, which it sends on
+// the returned channel.
+func smtpServer(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var data []string
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if inData {
+				if line == "." {
+					inData = false
+					messages <- strings.Join(data, "\n")
+					reply("250 OK")
+					continue
+				}
+				data = append(data, line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+				reply("250 OK")
+			case "DATA":
+				inData = true
+				reply("354 go ahead")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), messages
+}
+
+func TestEmail(t *testing.T) {
+	addr, messages := smtpServer(t)
+	sink := Sink{
+		Type: TypeEmail,
+		SMTP: addr,
+		From: "dagger@example.com",
+		To:   []string{"data-eng@example.com"},
+	}
+	if err := sink.Validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := sink.Send(NewSummary(failedDeployment(), "prod")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	msg := <-messages
+	for _, want := range []string{
+		"To: data-eng@example.com",
+		"Subject: [dagger] composer-prod: deploy 20210601T120000Z failed",
+		"Failed: audit_export",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("expected %q in email:\n%s", want, msg)
+		}
+	}
+}
+
+func TestEmailSubjectHeaderInjection(t *testing.T) {
+	addr, messages := smtpServer(t)
+	sink := Sink{
+		Type:    TypeEmail,
+		SMTP:    addr,
+		From:    "dagger@example.com",
+		To:      []string{"data-eng@example.com"},
+		Subject: "{{.Error}}",
+	}
+	sum := NewSummary(failedDeployment(), "prod")
+	sum.Error = "boom\r\nBcc: attacker@example.com"
+	if err := sink.Send(sum); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	headers := strings.SplitN(<-messages, "\n\n", 2)[0]
+	if !strings.Contains(headers, "Subject: boom Bcc: attacker@example.com") || strings.Contains(headers, "\nBcc:") {
+		t.Errorf("expected line breaks to be removed from the subject:\n%s", headers)
+	}
+}
+
+func TestNotifyFilters(t *testing.T) {
+	srv, bodies := recorder(t, http.StatusOK)
+	sinks := []Sink{
+		{Type: TypeWebhook, URL: srv.URL, On: OnSuccess},
+		{Type: TypeWebhook, URL: srv.URL, Environments: []string{"staging"}},
+		{Type: TypeSlack, URL: srv.URL, On: OnFailure, Environments: []string{"prod"}},
+	}
+	if errs := Notify(sinks, NewSummary(failedDeployment(), "prod")); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("expected only the slack sink to be notified, got %d posts", len(bodies))
+	}
+	if body := string(<-bodies); !strings.Contains(body, `"text"`) {
+		t.Errorf("expected the slack payload, got %s", body)
+	}
+}
@@ -0,0 +1,93 @@
+// Package reconcile runs a sync repeatedly, backing off after failures
+// without ever giving up.
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"github.com/inshur/dagger/pkg/logging"
+)
+
+// Defaults for a Loop with unset durations.
+const (
+	DefaultInterval = time.Hour
+	DefaultBackoff  = 30 * time.Second
+)
+
+// Loop runs Sync every Interval until its context is cancelled. After a
+// failed cycle the next one starts after Backoff, doubling with every
+// consecutive failure up to Interval.
+type Loop struct {
+	Interval time.Duration
+	Backoff  time.Duration
+	// Sync runs one cycle and returns a one line summary of what it did.
+	Sync func(ctx context.Context) (string, error)
+	// Logf prints the cycle summaries, at info level by default.
+	Logf func(format string, args ...interface{})
+	// Errorf prints failed cycles, Logf when it is set and at error level
+	// otherwise.
+	Errorf func(format string, args ...interface{})
+}
+
+// Delay returns how long to wait before the next cycle after failures
+// consecutive failed cycles.
+func (l *Loop) Delay(failures int) time.Duration {
+	interval := l.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if failures == 0 {
+		return interval
+	}
+	delay := l.Backoff
+	if delay <= 0 {
+		delay = DefaultBackoff
+	}
+	for i := 1; i < failures && delay < interval; i++ {
+		delay *= 2
+	}
+	if delay > interval {
+		return interval
+	}
+	return delay
+}
+
+// Run runs cycles until ctx is done. A cycle in progress is finished first.
+func (l *Loop) Run(ctx context.Context) {
+	logf, errorf := l.Logf, l.Errorf
+	if logf == nil {
+		logf = logging.Log.Infof
+		if errorf == nil {
+			errorf = logging.Log.Errorf
+		}
+	}
+	if errorf == nil {
+		errorf = logf
+	}
+	failures := 0
+	for cycle := 1; ; cycle++ {
+		started := time.Now()
+		summary, err := l.Sync(ctx)
+		took := time.Since(started).Round(time.Millisecond)
+		if err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+		delay := l.Delay(failures)
+		if err != nil {
+			errorf("cycle %d failed after %v (%d in a row): %v; retrying in %v", cycle, took, failures, err, delay)
+		} else {
+			logf("cycle %d succeeded in %v: %s; next sync in %v", cycle, took, summary, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	l := Loop{Interval: 5 * time.Minute, Backoff: time.Minute}
+	want := []time.Duration{5 * time.Minute, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for failures, w := range want {
+		if got := l.Delay(failures); got != w {
+			t.Errorf("after %d failures expected %v, got %v", failures, w, got)
+		}
+	}
+	if got := (&Loop{}).Delay(0); got != DefaultInterval {
+		t.Errorf("expected the default interval, got %v", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var logs []string
+	cycles := 0
+	l := Loop{
+		Interval: time.Millisecond,
+		Backoff:  time.Millisecond,
+		Sync: func(context.Context) (string, error) {
+			cycles++
+			switch cycles {
+			case 1, 2:
+				return "", errors.New("list_dags failed")
+			case 4:
+				cancel()
+			}
+			return fmt.Sprintf("+%d", cycles), nil
+		},
+		Logf: func(format string, args ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, args...))
+		},
+	}
+	l.Run(ctx)
+	if cycles != 4 {
+		t.Fatalf("expected the loop to keep going after failures until cancelled, ran %d cycles", cycles)
+	}
+	if !strings.Contains(logs[1], "cycle 2 failed") || !strings.Contains(logs[1], "2 in a row") ||
+		!strings.Contains(logs[2], "cycle 3 succeeded") || !strings.Contains(logs[2], "+3") {
+		t.Errorf("unexpected cycle summaries: %q", logs)
+	}
+}
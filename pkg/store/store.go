@@ -0,0 +1,416 @@
+// Package store reads and writes the objects dagger keeps alongside a
+// Composer environment, either in its Cloud Storage bucket or in a local
+// folder.
+package store
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// ErrNotExist is returned when reading an object that doesn't exist.
+var ErrNotExist = errors.New("object doesn't exist")
+
+// ErrPrecondition is returned by conditional writes and deletes when the
+// object isn't in the expected state.
+var ErrPrecondition = errors.New("object changed")
+
+// Object describes a stored object. MD5 is hex encoded.
+type Object struct {
+	Name       string            `json:"name"`
+	Generation int64             `json:"generation,omitempty"`
+	MD5        string            `json:"md5"`
+	Size       int64             `json:"size"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// Store is a flat namespace of objects with slash separated names.
+type Store interface {
+	// List returns the objects whose name starts with prefix, sorted by name.
+	List(prefix string) ([]Object, error)
+	Read(name string) ([]byte, error)
+	Write(name string, data []byte, metadata map[string]string) error
+	Delete(name string) error
+	// Stat returns the object called name, or ErrNotExist.
+	Stat(name string) (Object, error)
+	// WriteIf writes name only if its generation is generation, or if it
+	// doesn't exist when generation is 0, and returns the written object.
+	// It fails with ErrPrecondition otherwise.
+	WriteIf(name string, generation int64, data []byte, metadata map[string]string) (Object, error)
+	// DeleteIf deletes name only if its generation is generation, failing
+	// with ErrPrecondition otherwise.
+	DeleteIf(name string, generation int64) error
+}
+
+// MD5 returns the hex encoded md5 of data, as reported in Object.MD5.
+func MD5(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// GCS stores objects in a Cloud Storage bucket.
+type GCS struct {
+	Bucket string
+}
+
+func (s GCS) client() (*storage.Client, context.Context, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+	return client, ctx, cancel, nil
+}
+
+func gcsObject(attrs *storage.ObjectAttrs) Object {
+	return Object{
+		Name:       attrs.Name,
+		Generation: attrs.Generation,
+		MD5:        hex.EncodeToString(attrs.MD5),
+		Size:       attrs.Size,
+		Metadata:   attrs.Metadata,
+	}
+}
+
+// preconditionFailed reports whether err is a failed precondition, or a
+// missing object for an operation conditional on its generation.
+func preconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == 412 || apiErr.Code == 404
+	}
+	return err == storage.ErrObjectNotExist
+}
+
+func (s GCS) List(prefix string) ([]Object, error) {
+	client, ctx, cancel, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	defer client.Close()
+
+	var objects []Object
+	it := client.Bucket(s.Bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Bucket(%q).Objects: %v", s.Bucket, err)
+		}
+		if strings.HasSuffix(attrs.Name, "/") {
+			continue
+		}
+		objects = append(objects, gcsObject(attrs))
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+func (s GCS) Read(name string) ([]byte, error) {
+	client, ctx, cancel, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	defer client.Close()
+
+	rc, err := client.Bucket(s.Bucket).Object(name).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Object(%q).NewReader: %v", name, err)
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func (s GCS) Write(name string, data []byte, metadata map[string]string) error {
+	client, ctx, cancel, err := s.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer client.Close()
+
+	wc := client.Bucket(s.Bucket).Object(name).NewWriter(ctx)
+	wc.Metadata = metadata
+	if _, err := wc.Write(data); err != nil {
+		return fmt.Errorf("Object(%q).Write: %v", name, err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("Writer.Close: %v", err)
+	}
+	return nil
+}
+
+func (s GCS) Delete(name string) error {
+	client, ctx, cancel, err := s.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer client.Close()
+
+	if err := client.Bucket(s.Bucket).Object(name).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+		return fmt.Errorf("Object(%q).Delete: %v", name, err)
+	}
+	return nil
+}
+
+func (s GCS) Stat(name string) (Object, error) {
+	client, ctx, cancel, err := s.client()
+	if err != nil {
+		return Object{}, err
+	}
+	defer cancel()
+	defer client.Close()
+
+	attrs, err := client.Bucket(s.Bucket).Object(name).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return Object{}, ErrNotExist
+	}
+	if err != nil {
+		return Object{}, fmt.Errorf("Object(%q).Attrs: %v", name, err)
+	}
+	return gcsObject(attrs), nil
+}
+
+// conditions are the preconditions making an operation apply to generation
+// only, or only to a missing object for 0.
+func conditions(generation int64) storage.Conditions {
+	if generation == 0 {
+		return storage.Conditions{DoesNotExist: true}
+	}
+	return storage.Conditions{GenerationMatch: generation}
+}
+
+func (s GCS) WriteIf(name string, generation int64, data []byte, metadata map[string]string) (Object, error) {
+	client, ctx, cancel, err := s.client()
+	if err != nil {
+		return Object{}, err
+	}
+	defer cancel()
+	defer client.Close()
+
+	wc := client.Bucket(s.Bucket).Object(name).If(conditions(generation)).NewWriter(ctx)
+	wc.Metadata = metadata
+	if _, err := wc.Write(data); err != nil {
+		return Object{}, fmt.Errorf("Object(%q).Write: %v", name, err)
+	}
+	if err := wc.Close(); err != nil {
+		if preconditionFailed(err) {
+			return Object{}, ErrPrecondition
+		}
+		return Object{}, fmt.Errorf("Writer.Close: %v", err)
+	}
+	return gcsObject(wc.Attrs()), nil
+}
+
+func (s GCS) DeleteIf(name string, generation int64) error {
+	client, ctx, cancel, err := s.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer client.Close()
+
+	if err := client.Bucket(s.Bucket).Object(name).If(conditions(generation)).Delete(ctx); err != nil {
+		if preconditionFailed(err) {
+			return ErrPrecondition
+		}
+		return fmt.Errorf("Object(%q).Delete: %v", name, err)
+	}
+	return nil
+}
+
+// Dir stores objects as files under a local folder. Metadata is kept in a
+// sidecar file next to each object and the generation of an object is the
+// modification time of its file. Conditional writes are only atomic between
+// processes when creating an object.
+type Dir struct {
+	Root string
+}
+
+// dirMu serializes conditional writes to Dir stores.
+var dirMu sync.Mutex
+
+const metadataSuffix = ".metadata.json"
+
+func (s Dir) path(name string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(name))
+}
+
+func (s Dir) List(prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.Walk(s.Root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == s.Root {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, metadataSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		obj := Object{Name: name, Generation: info.ModTime().UnixNano(), MD5: MD5(data), Size: info.Size()}
+		if meta, err := ioutil.ReadFile(path + metadataSuffix); err == nil {
+			if err := json.Unmarshal(meta, &obj.Metadata); err != nil {
+				return fmt.Errorf("error reading metadata of %v: %v", name, err)
+			}
+		}
+		objects = append(objects, obj)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %v: %v", s.Root, err)
+	}
+	return objects, nil
+}
+
+func (s Dir) Read(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return data, err
+}
+
+func (s Dir) Write(name string, data []byte, metadata map[string]string) error {
+	path := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating directory: %v", err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	if len(metadata) == 0 {
+		if err := os.Remove(path + metadataSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	meta, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+metadataSuffix, meta, 0644)
+}
+
+func (s Dir) Delete(name string) error {
+	path := s.path(name)
+	for _, p := range []string{path, path + metadataSuffix} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Dir) Stat(name string) (Object, error) {
+	path := s.path(name)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return Object{}, ErrNotExist
+	}
+	if err != nil {
+		return Object{}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Object{}, err
+	}
+	obj := Object{Name: name, Generation: info.ModTime().UnixNano(), MD5: MD5(data), Size: info.Size()}
+	if meta, err := ioutil.ReadFile(path + metadataSuffix); err == nil {
+		if err := json.Unmarshal(meta, &obj.Metadata); err != nil {
+			return Object{}, fmt.Errorf("error reading metadata of %v: %v", name, err)
+		}
+	}
+	return obj, nil
+}
+
+func (s Dir) WriteIf(name string, generation int64, data []byte, metadata map[string]string) (Object, error) {
+	dirMu.Lock()
+	defer dirMu.Unlock()
+
+	path := s.path(name)
+	var next time.Time
+	if generation == 0 {
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return Object{}, fmt.Errorf("error creating directory: %v", err)
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			return Object{}, ErrPrecondition
+		}
+		if err != nil {
+			return Object{}, err
+		}
+		f.Close()
+	} else {
+		cur, err := s.Stat(name)
+		if err == ErrNotExist || err == nil && cur.Generation != generation {
+			return Object{}, ErrPrecondition
+		}
+		if err != nil {
+			return Object{}, err
+		}
+		// the clock may not have moved since the last write
+		next = time.Unix(0, generation+1)
+	}
+	if err := s.Write(name, data, metadata); err != nil {
+		return Object{}, err
+	}
+	if now := time.Now(); now.After(next) {
+		next = now
+	}
+	if err := os.Chtimes(path, next, next); err != nil {
+		return Object{}, err
+	}
+	return s.Stat(name)
+}
+
+func (s Dir) DeleteIf(name string, generation int64) error {
+	dirMu.Lock()
+	defer dirMu.Unlock()
+
+	cur, err := s.Stat(name)
+	if err == ErrNotExist || err == nil && cur.Generation != generation {
+		return ErrPrecondition
+	}
+	if err != nil {
+		return err
+	}
+	return s.Delete(name)
+}
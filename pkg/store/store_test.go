@@ -0,0 +1,68 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	s := Dir{Root: t.TempDir()}
+	meta := map[string]string{"dagger-deploy-id": "20210601T120000Z"}
+	if err := s.Write("dags/reports/weekly.py", []byte("weekly"), meta); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := s.Write("plugins/hooks.py", []byte("hooks"), nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	objs, err := s.List("dags/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	stat, err := s.Stat("dags/reports/weekly.py")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []Object{{Name: "dags/reports/weekly.py", Generation: stat.Generation, MD5: MD5([]byte("weekly")), Size: 6, Metadata: meta}}
+	if !reflect.DeepEqual(objs, want) {
+		t.Errorf("expected %+v, got %+v", want, objs)
+	}
+	if err := s.Delete("dags/reports/weekly.py"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := s.Read("dags/reports/weekly.py"); err != ErrNotExist {
+		t.Errorf("expected a deleted object not to exist, got %v", err)
+	}
+	if objs, err := (Dir{Root: "missing"}).List(""); err != nil || len(objs) != 0 {
+		t.Errorf("expected a missing folder to be empty, got %v, %v", objs, err)
+	}
+}
+
+func TestDirConditional(t *testing.T) {
+	s := Dir{Root: t.TempDir()}
+	created, err := s.WriteIf(".dagger/lock", 0, []byte("a"), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := s.WriteIf(".dagger/lock", 0, []byte("b"), nil); err != ErrPrecondition {
+		t.Errorf("expected creating an existing object to fail, got %v", err)
+	}
+	updated, err := s.WriteIf(".dagger/lock", created.Generation, []byte("c"), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if updated.Generation <= created.Generation {
+		t.Errorf("expected generation to grow from %d, got %d", created.Generation, updated.Generation)
+	}
+	if _, err := s.WriteIf(".dagger/lock", created.Generation, []byte("d"), nil); err != ErrPrecondition {
+		t.Errorf("expected writing a stale generation to fail, got %v", err)
+	}
+	if err := s.DeleteIf(".dagger/lock", created.Generation); err != ErrPrecondition {
+		t.Errorf("expected deleting a stale generation to fail, got %v", err)
+	}
+	if err := s.DeleteIf(".dagger/lock", updated.Generation); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := s.Stat(".dagger/lock"); err != ErrNotExist {
+		t.Errorf("expected the object to be deleted, got %v", err)
+	}
+}
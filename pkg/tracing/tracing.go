@@ -0,0 +1,139 @@
+// Package tracing exports OpenTelemetry spans of dagger runs, either to an
+// OTLP collector or to a local file.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters accepted by Setup.
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+	ExporterFile = "file"
+)
+
+// Attribute keys shared by dagger's spans.
+const (
+	DeployID    = attribute.Key("dagger.deploy_id")
+	Environment = attribute.Key("dagger.environment")
+	DagID       = attribute.Key("dagger.dag_id")
+	Object      = attribute.Key("dagger.object")
+	Bytes       = attribute.Key("dagger.bytes")
+	Retries     = attribute.Key("dagger.retries")
+	Command     = attribute.Key("dagger.command")
+)
+
+const instrumentation = "github.com/inshur/dagger"
+
+// Tracer returns dagger's tracer, which does nothing until Setup installs an
+// exporter.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// Config selects where spans are exported.
+type Config struct {
+	// Exporter is ExporterNone, ExporterOTLP or ExporterFile.
+	Exporter string
+	// Endpoint is the OTLP/HTTP collector, like http://localhost:4318. The
+	// OTEL_EXPORTER_OTLP_* variables are used when empty.
+	Endpoint string
+	// File receives the spans as JSON for ExporterFile.
+	File string
+	// Version is recorded as the service version.
+	Version string
+}
+
+// Setup installs the exporter selected by cfg. The returned shutdown flushes
+// the spans still buffered and must be called before exiting.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			u, err := url.Parse(cfg.Endpoint)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("trace endpoint must be a URL like http://localhost:4318, got %q", cfg.Endpoint)
+			}
+			opts = append(opts, otlptracehttp.WithEndpoint(u.Host))
+			if u.Scheme == "http" {
+				opts = append(opts, otlptracehttp.WithInsecure())
+			}
+			if u.Path != "" && u.Path != "/" {
+				opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+			}
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("error creating OTLP exporter: %v", err)
+		}
+		exporter = exp
+	case ExporterFile:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("the file exporter needs a trace file")
+		}
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		exporter = fileExporter{exp, f}
+	default:
+		return nil, fmt.Errorf("trace exporter must be none, otlp or file, got %q", cfg.Exporter)
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String("dagger"),
+		semconv.ServiceVersionKey.String(cfg.Version),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// fileExporter closes the trace file once the exporter is shut down.
+type fileExporter struct {
+	sdktrace.SpanExporter
+	f *os.File
+}
+
+func (e fileExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if cerr := e.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// End ends span, recording err as its status when set.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// exportedSpan is the part of the file exporter's JSON checked here.
+type exportedSpan struct {
+	Name   string
+	Parent struct {
+		SpanID string
+	}
+	SpanContext struct {
+		SpanID string
+	}
+	Attributes []struct {
+		Key   string
+		Value struct {
+			Value interface{}
+		}
+	}
+	Status struct {
+		Code        string
+		Description string
+	}
+}
+
+func (s exportedSpan) attr(key string) interface{} {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.Value
+		}
+	}
+	return nil
+}
+
+func TestFileExporter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterFile, File: file, Version: "v1.2.3"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ctx, parent := Tracer().Start(context.Background(), "sync")
+	_, child := Tracer().Start(ctx, "Upload")
+	child.SetAttributes(Object.String("dags/finance/daily.py"), Bytes.Int64(1024))
+	End(child, errors.New("Writer.Close: 403"))
+	End(parent, nil)
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	spans := make(map[string]exportedSpan)
+	for dec := json.NewDecoder(f); ; {
+		var s exportedSpan
+		if err := dec.Decode(&s); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("expected JSON spans: %s", err)
+		}
+		spans[s.Name] = s
+	}
+	upload, sync := spans["Upload"], spans["sync"]
+	if upload.Name == "" || sync.Name == "" {
+		t.Fatalf("expected Upload and sync spans, got %v", spans)
+	}
+	if upload.Parent.SpanID != sync.SpanContext.SpanID {
+		t.Errorf("expected Upload to be a child of sync")
+	}
+	if got := upload.attr(string(Object)); got != "dags/finance/daily.py" {
+		t.Errorf("expected object attribute, got %v", got)
+	}
+	if got := upload.attr(string(Bytes)); got != float64(1024) {
+		t.Errorf("expected bytes attribute, got %v", got)
+	}
+	if upload.Status.Code != "Error" || upload.Status.Description != "Writer.Close: 403" {
+		t.Errorf("expected error status, got %+v", upload.Status)
+	}
+	if sync.Status.Code == "Error" {
+		t.Errorf("expected sync to succeed, got %+v", sync.Status)
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	for _, cfg := range []Config{
+		{Exporter: "zipkin"},
+		{Exporter: ExporterFile},
+		{Exporter: ExporterOTLP, Endpoint: "localhost:4318"},
+	} {
+		if _, err := Setup(context.Background(), cfg); err == nil {
+			t.Errorf("expected %+v to fail", cfg)
+		}
+	}
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterNone})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Package watch reports changes to local folders, debounced so that a burst
+// of writes, like an editor saving or a git checkout, is reported once.
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultDebounce is how long a Watcher waits for changes to settle.
+const DefaultDebounce = 500 * time.Millisecond
+
+// Watcher watches Roots recursively and calls OnChange with the changed
+// files once no change happened for Debounce.
+type Watcher struct {
+	Roots    []string
+	Debounce time.Duration
+	OnChange func(paths []string)
+	// OnError is called for watch errors, which don't stop the watcher.
+	OnError func(err error)
+}
+
+// ignored reports paths that never need pushing: bytecode caches, VCS
+// metadata and editor temporary files.
+func ignored(path string) bool {
+	base := filepath.Base(path)
+	return base == "__pycache__" || base == ".git" || strings.HasSuffix(base, ".pyc") ||
+		strings.HasSuffix(base, "~") || strings.HasSuffix(base, ".swp") ||
+		strings.HasPrefix(base, ".#") || base == "4913"
+}
+
+// addTree watches dir and every folder below it.
+func addTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if ignored(path) {
+			return filepath.SkipDir
+		}
+		return w.Add(path)
+	})
+}
+
+// resetTimer restarts t for d. A timer that fired but wasn't received from
+// is drained first, so the old expiry isn't reported as a new one.
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// Run watches until ctx is done.
+func (w *Watcher) Run(ctx context.Context) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+	for _, root := range w.Roots {
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+		if err := addTree(fw, root); err != nil {
+			return err
+		}
+	}
+	debounce := w.Debounce
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+
+	changed := make(map[string]bool)
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-fw.Events:
+			if ignored(event.Name) {
+				continue
+			}
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					// files created with the folder are reported by the walk
+					addTree(fw, event.Name)
+					filepath.Walk(event.Name, func(path string, info os.FileInfo, err error) error {
+						if err == nil && !info.IsDir() && !ignored(path) {
+							changed[path] = true
+						}
+						return nil
+					})
+					resetTimer(timer, debounce)
+					continue
+				}
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			changed[event.Name] = true
+			resetTimer(timer, debounce)
+		case err := <-fw.Errors:
+			if w.OnError != nil {
+				w.OnError(err)
+			}
+		case <-timer.C:
+			paths := make([]string, 0, len(changed))
+			for p := range changed {
+				paths = append(paths, p)
+			}
+			sort.Strings(paths)
+			changed = make(map[string]bool)
+			w.OnChange(paths)
+		}
+	}
+}
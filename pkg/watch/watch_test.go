@@ -0,0 +1,75 @@
+package watch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "reports"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	batches := make(chan []string, 10)
+	w := Watcher{Roots: []string{root}, Debounce: 100 * time.Millisecond, OnChange: func(paths []string) { batches <- paths }}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	write := func(rel, content string) {
+		if err := ioutil.WriteFile(filepath.Join(root, rel), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a burst of writes to the same files is reported once
+	for i := 0; i < 5; i++ {
+		write("finance_daily.py", "v")
+		write(filepath.Join("reports", "weekly_report.py"), "v")
+		write("finance_daily.py~", "backup")
+	}
+	select {
+	case got := <-batches:
+		want := []string{filepath.Join(root, "finance_daily.py"), filepath.Join(root, "reports", "weekly_report.py")}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported")
+	}
+	select {
+	case got := <-batches:
+		t.Errorf("expected a single batch, got another: %v", got)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// files in new folders are picked up
+	if err := os.MkdirAll(filepath.Join(root, "billing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join("billing", "billing_daily.py"), "v")
+	select {
+	case got := <-batches:
+		if len(got) != 1 || got[0] != filepath.Join(root, "billing", "billing_daily.py") {
+			t.Errorf("unexpected change in new folder: %v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported for a new folder")
+	}
+}
+
+func TestResetTimerDrainsExpiry(t *testing.T) {
+	timer := time.NewTimer(time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	resetTimer(timer, time.Hour)
+	select {
+	case <-timer.C:
+		t.Error("the expiry before the reset was reported")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
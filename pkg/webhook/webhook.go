@@ -0,0 +1,248 @@
+// Package webhook receives GitHub and GitLab push events and queues a sync
+// of the pushed commit, coalescing bursts of pushes into one sync.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/inshur/dagger/pkg/logging"
+)
+
+// maxPayload bounds the size of accepted webhook payloads.
+const maxPayload = 5 << 20
+
+// Push is the part of a push event needed to sync it.
+type Push struct {
+	Ref string
+	SHA string
+}
+
+// errUnauthorized is returned for payloads failing signature verification.
+var errUnauthorized = errors.New("invalid signature")
+
+// pushPayload covers both GitHub and GitLab push payloads.
+type pushPayload struct {
+	Ref         string `json:"ref"`
+	After       string `json:"after"`
+	CheckoutSHA string `json:"checkout_sha"`
+}
+
+// verifyGitHub checks the X-Hub-Signature-256 HMAC of body.
+func verifyGitHub(header, secret string, body []byte) error {
+	sig := strings.TrimPrefix(header, "sha256=")
+	got, err := hex.DecodeString(sig)
+	if err != nil || sig == header {
+		return errUnauthorized
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errUnauthorized
+	}
+	return nil
+}
+
+// ParsePush verifies and decodes a push event. It returns nil without error
+// for other events, like GitHub's ping.
+func ParsePush(header http.Header, body []byte, secret string) (*Push, error) {
+	var event string
+	switch {
+	case header.Get("X-GitHub-Event") != "":
+		event = header.Get("X-GitHub-Event")
+		if err := verifyGitHub(header.Get("X-Hub-Signature-256"), secret, body); err != nil {
+			return nil, err
+		}
+		if event != "push" {
+			return nil, nil
+		}
+	case header.Get("X-Gitlab-Event") != "":
+		// GitLab sends the secret itself rather than a signature
+		token := header.Get("X-Gitlab-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return nil, errUnauthorized
+		}
+		event = header.Get("X-Gitlab-Event")
+		if event != "Push Hook" {
+			return nil, nil
+		}
+	default:
+		return nil, fmt.Errorf("not a GitHub or GitLab event")
+	}
+
+	var p pushPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("invalid %s payload: %v", event, err)
+	}
+	sha := p.CheckoutSHA
+	if sha == "" {
+		sha = p.After
+	}
+	if p.Ref == "" || sha == "" {
+		return nil, fmt.Errorf("%s payload without ref or commit", event)
+	}
+	return &Push{Ref: p.Ref, SHA: sha}, nil
+}
+
+// SyncFunc syncs commit sha to an environment.
+type SyncFunc func(ctx context.Context, env, sha string) error
+
+// Server queues a sync for every push to Branch. Syncs of one environment
+// run one at a time, pushes arriving meanwhile are coalesced into a single
+// sync of the latest commit.
+type Server struct {
+	// Secret verifies the pushes syncing the default environment, those
+	// without ?env=.
+	Secret string
+	// Secrets maps the environments pushes may pick with ?env= to their
+	// secret, so a payload signed for one environment can't be replayed
+	// against another. Other environments are refused.
+	Secrets map[string]string
+	Branch  string
+	Sync    SyncFunc
+	// Head returns the commit Branch points to. When set, a queued sync
+	// deploys the head of the branch rather than the pushed commit, so
+	// deliveries arriving out of order never deploy an older commit.
+	Head func() (string, error)
+
+	mu      sync.Mutex
+	queues  map[string]*queue
+	running sync.WaitGroup
+}
+
+// queue holds the commit waiting to be synced to an environment.
+type queue struct {
+	pending string
+	busy    bool
+}
+
+// zeroSHA is the commit of a push deleting the branch.
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayload))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	env := r.URL.Query().Get("env")
+	secret, ok := s.secret(env)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown environment %q", env), http.StatusNotFound)
+		return
+	}
+	push, err := ParsePush(r.Header, body, secret)
+	switch {
+	case err == errUnauthorized:
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case push == nil:
+		fmt.Fprintln(w, "ignored: not a push event")
+		return
+	case push.Ref != "refs/heads/"+s.Branch:
+		fmt.Fprintf(w, "ignored: push to %s\n", push.Ref)
+		return
+	case push.SHA == zeroSHA:
+		fmt.Fprintln(w, "ignored: branch deleted")
+		return
+	}
+	s.Enqueue(env, push.SHA)
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "queued sync of %s\n", push.SHA)
+}
+
+// secret returns the secret of env, false for environments pushes may not
+// sync.
+func (s *Server) secret(env string) (string, bool) {
+	if env == "" {
+		return s.Secret, s.Secret != ""
+	}
+	secret, ok := s.Secrets[env]
+	return secret, ok && secret != ""
+}
+
+// Enqueue queues a sync of sha to env, replacing any commit still waiting.
+func (s *Server) Enqueue(env, sha string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queues == nil {
+		s.queues = make(map[string]*queue)
+	}
+	q := s.queues[env]
+	if q == nil {
+		q = &queue{}
+		s.queues[env] = q
+	}
+	if q.pending != "" {
+		logging.Log.WithField(logging.Env, env).Infof("coalescing sync of %.12s into %.12s", q.pending, sha)
+	}
+	q.pending = sha
+	if !q.busy {
+		q.busy = true
+		s.running.Add(1)
+		go s.drain(env, q)
+	}
+}
+
+// drain syncs the pending commits of env until none is left.
+func (s *Server) drain(env string, q *queue) {
+	defer s.running.Done()
+	for {
+		s.mu.Lock()
+		sha := q.pending
+		q.pending = ""
+		if sha == "" {
+			q.busy = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		sha, err := s.resolve(sha)
+		if err != nil {
+			logging.Log.WithError(err).Errorf("can't resolve the head of %s, sync to environment %q skipped", s.Branch, env)
+			continue
+		}
+		logging.Log.WithField(logging.Env, env).Infof("syncing %.12s", sha)
+		if err := s.Sync(context.Background(), env, sha); err != nil {
+			logging.Log.WithError(err).Errorf("sync of %.12s to environment %q failed", sha, env)
+		}
+	}
+}
+
+// resolve returns the commit to sync for a queued push of sha.
+func (s *Server) resolve(sha string) (string, error) {
+	if s.Head == nil {
+		return sha, nil
+	}
+	head, err := s.Head()
+	if err != nil {
+		return "", err
+	}
+	if head != sha {
+		logging.Log.Infof("%s moved from pushed %.12s to %.12s", s.Branch, sha, head)
+	}
+	return head, nil
+}
+
+// Wait blocks until every queued sync has run.
+func (s *Server) Wait() {
+	s.running.Wait()
+}
@@ -0,0 +1,134 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	secret     = "s3cret"
+	prodSecret = "pr0d-s3cret"
+)
+
+func payload(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func githubRequest(target string, body []byte, event, key string) *http.Request {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(string(body)))
+	r.Header.Set("X-GitHub-Event", event)
+	r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func gitlabRequest(body []byte, token string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(string(body)))
+	r.Header.Set("X-Gitlab-Event", "Push Hook")
+	r.Header.Set("X-Gitlab-Token", token)
+	return r
+}
+
+func TestServeHTTP(t *testing.T) {
+	var mu sync.Mutex
+	var synced []string
+	s := &Server{Secret: secret, Secrets: map[string]string{"prod": prodSecret}, Branch: "main", Sync: func(ctx context.Context, env, sha string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		synced = append(synced, env+" "+sha[:7])
+		return nil
+	}}
+	github, gitlab := payload(t, "github_push.json"), payload(t, "gitlab_push.json")
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		code int
+	}{
+		{"github push", githubRequest("/webhook?env=prod", github, "push", prodSecret), http.StatusAccepted},
+		{"github ping", githubRequest("/webhook?env=prod", []byte(`{"zen": "Keep it logically awesome."}`), "ping", prodSecret), http.StatusOK},
+		{"github bad signature", githubRequest("/webhook?env=prod", github, "push", "wrong"), http.StatusUnauthorized},
+		{"replayed to another environment", githubRequest("/webhook?env=prod", github, "push", secret), http.StatusUnauthorized},
+		{"unknown environment", githubRequest("/webhook?env=staging", github, "push", secret), http.StatusNotFound},
+		{"gitlab push", gitlabRequest(gitlab, secret), http.StatusAccepted},
+		{"gitlab bad token", gitlabRequest(gitlab, "wrong"), http.StatusUnauthorized},
+		{"other branch", githubRequest("/webhook", []byte(strings.Replace(string(github), "refs/heads/main", "refs/heads/feature", 1)), "push", secret), http.StatusOK},
+		{"get", httptest.NewRequest(http.MethodGet, "/webhook", nil), http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, tt.r)
+		if w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.code, w.Code, w.Body.String())
+		}
+	}
+	s.Wait()
+	if len(synced) != 2 || !contains(synced, "prod 0d1a26e") || !contains(synced, " da15608") {
+		t.Errorf("unexpected syncs: %q", synced)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCoalesce(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var synced []string
+	s := &Server{Sync: func(ctx context.Context, env, sha string) error {
+		synced = append(synced, sha)
+		if sha == "a" {
+			close(started)
+			<-release
+		}
+		return nil
+	}}
+	s.Enqueue("prod", "a")
+	<-started
+	// a burst while "a" is syncing runs a single sync of the latest commit
+	for _, sha := range []string{"b", "c", "d"} {
+		s.Enqueue("prod", sha)
+	}
+	close(release)
+	s.Wait()
+	if strings.Join(synced, ",") != "a,d" {
+		t.Errorf("expected syncs a,d, got %v", synced)
+	}
+}
+
+func TestSyncsHead(t *testing.T) {
+	var synced []string
+	s := &Server{
+		Sync: func(ctx context.Context, env, sha string) error {
+			synced = append(synced, sha)
+			return nil
+		},
+		// a delivery of "a" arriving after "b" was pushed
+		Head: func() (string, error) { return "b", nil },
+	}
+	s.Enqueue("prod", "a")
+	s.Wait()
+	if strings.Join(synced, ",") != "b" {
+		t.Errorf("expected the head of the branch to be synced, got %v", synced)
+	}
+}